@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/bidirect/internal/logging"
+	"github.com/example/bidirect/internal/websocket"
+)
+
+func main() {
+	port := flag.Int("port", 8090, "Relay port")
+	flag.Parse()
+
+	logging.Infof("Starting BiDirect relay on port %d (publish on /stream, subscribe on /subscribe)", *port)
+
+	server := websocket.NewRelayServer(*port)
+	if err := server.Start(); err != nil {
+		logging.Errorf("Failed to start relay: %v", err)
+		os.Exit(1)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	logging.Infof("Shutting down relay")
+	server.Stop()
+}
@@ -3,9 +3,15 @@ package main
 import (
 	"flag"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/filter"
 	"github.com/example/bidirect/internal/logging"
+	"github.com/example/bidirect/internal/transition"
+	"github.com/example/bidirect/internal/websocket"
 	"github.com/example/bidirect/internal/window"
 )
 
@@ -14,17 +20,108 @@ func main() {
 
 	flag.IntVar(&cfg.InitialSize, "size", cfg.InitialSize, "Initial window size")
 	flag.IntVar(&cfg.WSPort, "port", cfg.WSPort, "WebSocket port")
+	flag.StringVar(&cfg.DialURL, "dial", cfg.DialURL, "Publisher URL to connect out to (ws://host:port/path)")
+	flag.StringVar(&cfg.AuthToken, "token", cfg.AuthToken, "Token sent when connecting out to a publisher")
+	flag.BoolVar(&cfg.Headless, "headless", cfg.Headless, "Run the receiver without a window")
+	flag.StringVar(&cfg.RecordPath, "record", cfg.RecordPath, "Record received frames to this file")
+	flag.IntVar(&cfg.DecodeWorkers, "decoders", cfg.DecodeWorkers, "Decode workers (0 = GOMAXPROCS)")
+	flag.BoolVar(&cfg.LatestOnly, "latest-only", cfg.LatestOnly, "Skip decoding frames already superseded by newer ones")
+	flag.TextVar(&cfg.Fit, "fit", cfg.Fit, "How frames fit the window: fit, fill, stretch or center")
+	flag.TextVar(&cfg.ScaleFilter, "filter", cfg.ScaleFilter, "Scaling filter: bilinear or lanczos")
+	flag.Func("chroma-key", "Make this #rrggbb color transparent (e.g. #00ff00 for a green screen)", func(v string) error {
+		cfg.ChromaKey.Enabled = true
+		return cfg.ChromaKey.Color.UnmarshalText([]byte(v))
+	})
+	flag.Float64Var(&cfg.ChromaKey.Similarity, "chroma-similarity", cfg.ChromaKey.Similarity, "Chroma distance keyed fully transparent")
+	flag.Float64Var(&cfg.ChromaKey.Smoothness, "chroma-smoothness", cfg.ChromaKey.Smoothness, "Chroma distance over which keyed edges fade in")
+	flag.Float64Var(&cfg.ChromaKey.Spill, "chroma-spill", cfg.ChromaKey.Spill, "Chroma distance over which key color spill is removed (0 = off)")
+	flag.StringVar(&cfg.Mask, "mask", cfg.Mask, "Cut frames to heart, circle, roundrect, hexagon or a grayscale image file")
+	flag.StringVar(&cfg.Filters, "filters", cfg.Filters, "Filter pipeline for every stream, e.g. fliph,rotate=90,saturation=1.2")
+	flag.IntVar(&cfg.MaxFPS, "max-fps", cfg.MaxFPS, "Most frames presented per second (0 = as they arrive)")
+	flag.BoolVar(&cfg.HUD, "hud", cfg.HUD, "Draw a performance overlay over presented frames")
+	flag.IntVar(&cfg.MaxFrameWidth, "max-width", cfg.MaxFrameWidth, "Reject frames wider than this (0 = unlimited)")
+	flag.IntVar(&cfg.MaxFrameHeight, "max-height", cfg.MaxFrameHeight, "Reject frames taller than this (0 = unlimited)")
+	flag.IntVar(&cfg.MaxFramePixels, "max-pixels", cfg.MaxFramePixels, "Reject frames with more pixels than this (0 = unlimited)")
+	flag.Int64Var(&cfg.MaxConnMemory, "max-conn-memory", cfg.MaxConnMemory, "Bytes of decoded frames one connection may hold (0 = unlimited)")
+	flag.TextVar(&cfg.Transition, "transition", cfg.Transition, "Effect when a new image replaces one on screen: none, crossfade, slide, wipe or zoom")
+	flag.DurationVar(&cfg.TransitionDuration, "transition-duration", cfg.TransitionDuration, "How long transitions take, at most 10s; frames arriving faster are shown at once")
+	flag.StringVar(&cfg.Waiting, "waiting", cfg.Waiting, "Shown until the first frame arrives: logo, pulse or an image file")
+	flag.StringVar(&cfg.SignalLost, "signal-lost", cfg.SignalLost, "Shown once no sender is connected: keep, dim, fade, logo, pulse or an image file")
+	flag.DurationVar(&cfg.SignalLostAfter, "signal-lost-after", cfg.SignalLostAfter, "How long without a connected sender before the signal counts as lost; 0 never")
+	flag.BoolVar(&cfg.HideWhenIdle, "hide-when-idle", cfg.HideWhenIdle, "Hide the window while waiting for frames and once the signal is lost")
+	flag.IntVar(&cfg.RingDepth, "ring-depth", cfg.RingDepth, "Decoded frames buffered for presentation")
+	flag.TextVar(&cfg.RingPolicy, "ring-policy", cfg.RingPolicy, "Frames to present: latest (skip to the newest) or fifo (in order, dropping the oldest when full)")
+	flag.Func("formats", "Image formats to accept, e.g. png,webp; prefix with stream= for one stream (repeatable)", func(v string) error {
+		stream, list, ok := strings.Cut(v, "=")
+		if !ok {
+			stream, list = "", v
+		}
+		names, err := websocket.ParseFormats(list)
+		if err != nil {
+			return err
+		}
+		if cfg.Formats == nil {
+			cfg.Formats = make(map[string][]string)
+		}
+		cfg.Formats[stream] = names
+		return nil
+	})
 	flag.Parse()
+	if err := transition.CheckDuration(cfg.TransitionDuration); err != nil {
+		logging.Errorf("Invalid -transition-duration: %v", err)
+		os.Exit(1)
+	}
 
 	logging.Infof("Starting BiDirect - WebSocket streaming receiver on port %d", cfg.WSPort)
 
-	w, err := window.NewWindow(cfg)
+	server := websocket.NewServer(cfg)
+	if cfg.RecordPath != "" {
+		if err := server.Record(cfg.RecordPath); err != nil {
+			logging.Errorf("Failed to start recording: %v", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.Mask != "" {
+		if err := server.LoadMask(cfg.Mask); err != nil {
+			logging.Errorf("Failed to load mask: %v", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.Filters != "" {
+		p, err := filter.Parse(cfg.Filters)
+		if err != nil {
+			logging.Errorf("Invalid filters: %v", err)
+			os.Exit(1)
+		}
+		server.SetFilters("", p)
+	}
+	if err := server.Start(); err != nil {
+		logging.Errorf("WebSocket server failed: %v", err)
+		os.Exit(1)
+	}
+	if cfg.DialURL != "" {
+		server.Dial(cfg.DialURL, cfg.AuthToken)
+	}
+
+	if cfg.Headless {
+		logging.Infof("Running headless; snapshots on /snapshot, metrics on /metrics, filters on /filters")
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		server.Stop()
+		return
+	}
+
+	w, err := window.NewWindow(cfg, server)
 	if err != nil {
+		server.Stop()
 		logging.Errorf("Failed to create window: %v", err)
 		os.Exit(1)
 	}
 
-	if err := w.Run(); err != nil {
+	err = w.Run()
+	server.Stop()
+	if err != nil {
 		logging.Errorf("Window error: %v", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	protocol "github.com/example/bidirect/internal/websocket"
+	"golang.org/x/net/websocket"
+)
+
+// streamFFmpeg runs ffmpeg with WebP output on a pipe and sends every
+// frame as soon as it has been parsed, paced to fps. It works for files
+// as well as live inputs (devices, URLs), and kills ffmpeg if sending
+// fails.
+func streamFFmpeg(ws *websocket.Conn, input, format string, fps int) error {
+	if fps <= 0 {
+		fps = 30
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+	if format != "" {
+		args = append(args, "-f", format)
+	}
+	args = append(args,
+		"-i", input,
+		"-an",
+		"-vf", fmt.Sprintf("fps=%d,scale=500:-1", fps),
+		"-c:v", "libwebp", "-quality", "90",
+		"-f", "image2pipe", "-",
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.WaitDelay = 2 * time.Second
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[VIDEO] Entrada: %s\n", input)
+	fmt.Printf("[VIDEO] Iniciando ffmpeg a %d fps...\n", fps)
+	if err := cmd.Start(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			fmt.Println("[ERROR] Asegúrate de que ffmpeg esté instalado")
+		}
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+
+	var lastErr string
+	var stderrDone sync.WaitGroup
+	stderrDone.Add(1)
+	go func() {
+		defer stderrDone.Done()
+		lines := bufio.NewScanner(stderr)
+		for lines.Scan() {
+			line := strings.TrimSpace(lines.Text())
+			if line == "" {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "[ffmpeg] %s\n", line)
+			lastErr = line
+		}
+	}()
+
+	frameCount, sendErr := sendWebPStream(ws, bufio.NewReader(stdout), fps)
+	if sendErr != nil {
+		cancel()
+	}
+	stderrDone.Wait()
+	waitErr := cmd.Wait()
+
+	if sendErr != nil {
+		return sendErr
+	}
+	if waitErr != nil {
+		if lastErr != "" {
+			return fmt.Errorf("ffmpeg: %v: %s", waitErr, lastErr)
+		}
+		return fmt.Errorf("ffmpeg: %w", waitErr)
+	}
+	fmt.Printf("\n[VIDEO] ✓ Completado: %d frames enviados\n", frameCount)
+	return nil
+}
+
+// sendWebPStream sends the concatenated WebP files ffmpeg's image2pipe
+// muxer writes, one RIFF container per frame, until r ends.
+func sendWebPStream(ws *websocket.Conn, r *bufio.Reader, fps int) (int, error) {
+	frameDelay := time.Second / time.Duration(fps)
+	start := time.Now()
+	frameCount := 0
+
+	for {
+		frame, err := readWebP(r)
+		if err == io.EOF {
+			return frameCount, nil
+		}
+		if err != nil {
+			return frameCount, err
+		}
+
+		// Files decode faster than real time; live inputs arrive at their
+		// own pace and never wait here.
+		if wait := time.Until(start.Add(time.Duration(frameCount) * frameDelay)); wait > 0 {
+			time.Sleep(wait)
+		}
+		if err := stamp(ws); err != nil {
+			return frameCount, fmt.Errorf("frame %d: %w", frameCount+1, err)
+		}
+		if err := protocol.WritePacket(ws, frame); err != nil {
+			return frameCount, fmt.Errorf("frame %d: %w", frameCount+1, err)
+		}
+		frameCount++
+		fmt.Printf("[FRAME %d] ✓ Enviado (%d bytes)\n", frameCount, len(frame))
+	}
+}
+
+// readWebP reads one RIFF/WebP container.
+func readWebP(r io.Reader) ([]byte, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("ffmpeg: salida WebP truncada")
+		}
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return nil, errors.New("ffmpeg: la salida no es WebP")
+	}
+
+	size := int(binary.LittleEndian.Uint32(header[4:8]))
+	size += size & 1
+	if size < 4 || size+8 > protocol.MaxPacketSize {
+		return nil, fmt.Errorf("ffmpeg: frame WebP de tamaño inválido: %d", size)
+	}
+
+	frame := make([]byte, 8+size)
+	copy(frame, header[:])
+	if _, err := io.ReadFull(r, frame[12:]); err != nil {
+		return nil, fmt.Errorf("ffmpeg: salida WebP truncada: %w", err)
+	}
+	return frame, nil
+}
@@ -1,53 +1,250 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/binary"
+	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/example/bidirect/internal/chroma"
+	"github.com/example/bidirect/internal/filter"
+	"github.com/example/bidirect/internal/mask"
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/transition"
+	"github.com/example/bidirect/internal/webm"
+	protocol "github.com/example/bidirect/internal/websocket"
 	"golang.org/x/net/websocket"
 )
 
+func usage() {
+	fmt.Println("Uso:")
+	fmt.Println("  send-websocket [-listen :puerto] [-token secreto] imagen.webp [ws://host:puerto/stream]")
+	fmt.Println("  send-websocket [-listen :puerto] [-token secreto] video.webm [ws://host:puerto/stream] [fps]")
+	fmt.Println("  send-websocket [-input-format formato] video.mp4|dispositivo|url [ws://host:puerto/stream] [fps]")
+	fmt.Println("  send-websocket -replay grabacion [ws://host:puerto/stream]")
+	fmt.Println("")
+	fmt.Println("Ejemplos:")
+	fmt.Println("  send-websocket test.webp ws://127.0.0.1:8080/stream")
+	fmt.Println("  send-websocket sticker.gif   (las animaciones se repiten en el receptor)")
+	fmt.Println("  send-websocket video.webm ws://127.0.0.1:8080/stream 30")
+	fmt.Println("  send-websocket -listen :9090 -token secreto video.webm")
+	fmt.Println("  send-websocket -input-format v4l2 /dev/video0 ws://127.0.0.1:8080/stream 15")
+	fmt.Println("  send-websocket -fit fill -filter lanczos video.webm")
+	fmt.Println("  send-websocket -chroma-key '#00ff00' -input-format v4l2 /dev/video0")
+	fmt.Println("  send-websocket -mask circle -input-format v4l2 /dev/video0")
+	fmt.Println("  send-websocket -filters fliph,saturation=1.2 -input-format v4l2 /dev/video0")
+	fmt.Println("  send-websocket -hud video.webm")
+	fmt.Println("  send-websocket -transition crossfade -transition-duration 600ms diapositiva.png")
+	fmt.Println("  send-websocket -timestamps -input-format v4l2 /dev/video0")
+	fmt.Println("  send-websocket -replay sesion.rec   (grabada con bidirect -record)")
+	fmt.Println("")
+	fmt.Println("Los .webm VP8 se envían tal cual con su propio ritmo, sin ffmpeg. Los demás")
+	fmt.Println("videos se convierten con ffmpeg al ritmo fps.")
+}
+
+// sendTimestamps makes every frame go out with its capture time, so the
+// receiver can measure how long frames take to reach it.
+var sendTimestamps bool
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Uso:")
-		fmt.Println("  send-websocket imagen.webp [ws://host:puerto/stream]")
-		fmt.Println("  send-websocket video.webm [ws://host:puerto/stream] [fps]")
-		fmt.Println("")
-		fmt.Println("Ejemplos:")
-		fmt.Println("  send-websocket test.webp ws://127.0.0.1:8080/stream")
-		fmt.Println("  send-websocket video.webm ws://127.0.0.1:8080/stream 30")
+	listenAddr := flag.String("listen", "", "Esperar receptores en esta dirección en lugar de conectar")
+	token := flag.String("token", "", "Token que deben presentar los receptores (modo -listen)")
+	inputFormat := flag.String("input-format", "", "Formato de entrada para ffmpeg (-f), p. ej. v4l2 o x11grab")
+	var control protocol.Control
+	flag.Func("fit", "Ajuste en el receptor: fit, fill, stretch o center", func(v string) error {
+		control.Fit = new(scale.Mode)
+		return control.Fit.UnmarshalText([]byte(v))
+	})
+	flag.Func("filter", "Filtro de escalado en el receptor: bilinear o lanczos", func(v string) error {
+		control.Filter = new(scale.Filter)
+		return control.Filter.UnmarshalText([]byte(v))
+	})
+	flag.Func("chroma-key", "Color #rrggbb que el receptor vuelve transparente (p. ej. #00ff00)", func(v string) error {
+		key := chroma.DefaultKey()
+		key.Enabled = true
+		control.ChromaKey = &key
+		return key.Color.UnmarshalText([]byte(v))
+	})
+	flag.Func("mask", "Forma del receptor: heart, circle, roundrect, hexagon o none", func(v string) error {
+		control.Mask = new(mask.Shape)
+		return control.Mask.UnmarshalText([]byte(v))
+	})
+	flag.Func("filters", "Filtros que aplica el receptor a este stream, p. ej. fliph,rotate=90", func(v string) error {
+		control.Filters = new(filter.Pipeline)
+		return control.Filters.UnmarshalText([]byte(v))
+	})
+	flag.BoolFunc("hud", "Mostrar u ocultar el panel de rendimiento del receptor", func(v string) error {
+		on, err := strconv.ParseBool(v)
+		control.HUD = &on
+		return err
+	})
+	flag.Func("transition", "Transición del receptor al cambiar de imagen: none, crossfade, slide, wipe o zoom", func(v string) error {
+		control.Transition = new(transition.Effect)
+		return control.Transition.UnmarshalText([]byte(v))
+	})
+	flag.Func("transition-duration", "Duración de las transiciones del receptor, p. ej. 500ms (máximo 10s)", func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if err := transition.CheckDuration(d); err != nil {
+			return err
+		}
+		ms := int(d / time.Millisecond)
+		control.TransitionMS = &ms
+		return nil
+	})
+	flag.BoolVar(&sendTimestamps, "timestamps", false, "Enviar al receptor la hora de captura de cada frame")
+	replay := flag.Bool("replay", false, "El archivo es una grabación de bidirect -record; se reenvía con su ritmo original")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
 		os.Exit(1)
 	}
 
-	filePath := os.Args[1]
+	filePath := args[0]
 	wsURL := "ws://127.0.0.1:8080/stream"
-	if len(os.Args) > 2 {
-		wsURL = os.Args[2]
+	if len(args) > 1 {
+		wsURL = args[1]
 	}
 
 	fps := 30
-	if len(os.Args) > 3 {
-		fmt.Sscanf(os.Args[3], "%d", &fps)
+	if len(args) > 2 {
+		fmt.Sscanf(args[2], "%d", &fps)
 	}
 
 	ext := strings.ToLower(filepath.Ext(filePath))
 
-	if ext == ".webm" {
-		sendVideoFrames(filePath, wsURL, fps)
-	} else if ext == ".webp" || ext == ".png" || ext == ".jpg" || ext == ".jpeg" {
-		sendImage(filePath, wsURL)
+	var send sendFunc
+	if *replay {
+		send = func(ws *websocket.Conn, _ <-chan struct{}) error {
+			return sendRecording(ws, filePath)
+		}
+	} else if ext == ".webm" && isVP8WebM(filePath) {
+		send = func(ws *websocket.Conn, keyframes <-chan struct{}) error {
+			return sendWebM(ws, filePath, keyframes)
+		}
+	} else if ext == ".webp" || ext == ".png" || ext == ".apng" || ext == ".gif" || ext == ".jpg" || ext == ".jpeg" ||
+		ext == ".bmp" || ext == ".tif" || ext == ".tiff" || ext == ".qoi" {
+		data := readImage(filePath)
+		send = func(ws *websocket.Conn, _ <-chan struct{}) error {
+			return sendImage(ws, data)
+		}
 	} else {
-		fmt.Printf("Formato no soportado: %s\n", ext)
+		// Anything else (other containers, devices, URLs) goes through
+		// ffmpeg; each receiver gets its own ffmpeg process.
+		send = func(ws *websocket.Conn, _ <-chan struct{}) error {
+			return streamFFmpeg(ws, filePath, *inputFormat, fps)
+		}
+	}
+
+	if control != (protocol.Control{}) {
+		sendContent := send
+		send = func(ws *websocket.Conn, keyframes <-chan struct{}) error {
+			if err := protocol.WriteControl(ws, control); err != nil {
+				return fmt.Errorf("control: %w", err)
+			}
+			return sendContent(ws, keyframes)
+		}
+	}
+
+	if *listenAddr != "" {
+		listen(*listenAddr, *token, send)
+		return
+	}
+
+	ws, err := websocket.Dial(wsURL, "", "http://localhost/")
+	if err != nil {
+		fmt.Printf("[ERROR] Conexión WebSocket a %s: %v\n", wsURL, err)
+		os.Exit(1)
+	}
+	defer ws.Close()
+	fmt.Printf("[WS] ✓ Conectado a %s\n", wsURL)
+	keyframes := make(chan struct{}, 1)
+	go readReceiver(ws, wsURL, keyframes)
+
+	if err := send(ws, keyframes); err != nil {
+		fmt.Printf("[ERROR] Envío: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("[WS] ✓ Completado a %s\n", wsURL)
+}
+
+// listen serves receivers that dial out to us (bidirect -dial). Each
+// receiver gets its own copy of the stream; after sending, the connection
+// is held open until the receiver hangs up so it does not reconnect in a loop.
+func listen(addr, token string, send sendFunc) {
+	handler := websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			got := []byte(r.Header.Get("Authorization"))
+			if token != "" && subtle.ConstantTimeCompare(got, []byte("Bearer "+token)) != 1 {
+				return fmt.Errorf("token inválido")
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			remote := ws.Request().RemoteAddr
+			fmt.Printf("[WS] ✓ Receptor conectado: %s\n", remote)
+			keyframes := make(chan struct{}, 1)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				readReceiver(ws, remote, keyframes)
+			}()
+			if err := send(ws, keyframes); err != nil {
+				fmt.Printf("[ERROR] Envío a %s: %v\n", remote, err)
+				return
+			}
+			<-done
+			fmt.Printf("[WS] Receptor desconectado: %s\n", remote)
+		},
+	}
+
+	fmt.Printf("[WS] Esperando receptores en %s\n", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		fmt.Printf("[ERROR] Servidor: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func sendImage(imagePath, wsURL string) {
+// sendFunc sends the content to one receiver. keyframes receives the
+// receiver's keyframe requests.
+type sendFunc func(ws *websocket.Conn, keyframes <-chan struct{}) error
+
+// readReceiver reads what the receiver sends back until it hangs up,
+// reporting why it rejected the stream if it does and passing its keyframe
+// requests on to keyframes without blocking.
+func readReceiver(ws *websocket.Conn, remote string, keyframes chan<- struct{}) {
+	packets := protocol.NewPacketReader(ws)
+	for {
+		typ, data, err := packets.Next()
+		if err != nil {
+			return
+		}
+		switch typ {
+		case protocol.FrameReject:
+			fmt.Printf("[ERROR] %s rechazó el stream: %s\n", remote, data)
+		case protocol.FrameKeyframeRequest:
+			select {
+			case keyframes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func readImage(imagePath string) []byte {
 	fmt.Printf("[IMAGE] Archivo: %s\n", imagePath)
 	data, err := os.ReadFile(imagePath)
 	if err != nil {
@@ -55,91 +252,197 @@ func sendImage(imagePath, wsURL string) {
 		os.Exit(1)
 	}
 	fmt.Printf("[IMAGE] Tamaño: %d bytes\n", len(data))
+	return data
+}
 
-	ws, err := websocket.Dial(wsURL, "", "http://localhost/")
-	if err != nil {
-		fmt.Printf("[ERROR] Conexión WebSocket a %s: %v\n", wsURL, err)
-		os.Exit(1)
+// stamp tells the receiver, if -timestamps is set, that the frame about
+// to be sent was captured now.
+func stamp(ws *websocket.Conn) error {
+	return stampAt(ws, time.Now())
+}
+
+// stampAt is stamp for a frame captured at t.
+func stampAt(ws *websocket.Conn, t time.Time) error {
+	if !sendTimestamps {
+		return nil
 	}
-	defer ws.Close()
-	fmt.Printf("[WS] ✓ Conectado a %s\n", wsURL)
+	return protocol.WriteTimestamp(ws, t)
+}
 
+func sendImage(ws *websocket.Conn, data []byte) error {
+	if err := stamp(ws); err != nil {
+		return err
+	}
 	packet := make([]byte, 4+len(data))
 	binary.LittleEndian.PutUint32(packet[0:4], uint32(len(data)))
 	copy(packet[4:], data)
 
-	err = websocket.Message.Send(ws, packet)
-	if err != nil {
-		fmt.Printf("[ERROR] Envío: %v\n", err)
-		os.Exit(1)
+	if err := websocket.Message.Send(ws, packet); err != nil {
+		return err
 	}
 	fmt.Printf("[IMAGE] ✓ Enviado (%d bytes)\n", len(packet))
-	fmt.Printf("[IMAGE] ✓ Completado a %s\n", wsURL)
+	return nil
 }
 
-func sendVideoFrames(videoPath, wsURL string, fps int) {
-	tmpDir, err := os.MkdirTemp("", "bidirect-frames-")
+// isVP8WebM reports whether path is a WebM file whose video track can be
+// sent as VP8 frames without transcoding.
+func isVP8WebM(path string) bool {
+	f, err := os.Open(path)
 	if err != nil {
-		fmt.Printf("Error creando directorio temporal: %v\n", err)
+		fmt.Printf("[ERROR] Lectura de video: %v\n", err)
 		os.Exit(1)
 	}
-	defer os.RemoveAll(tmpDir)
-
-	fmt.Printf("[VIDEO] Archivo: %s\n", videoPath)
-	fmt.Printf("[VIDEO] Directorio temporal: %s\n", tmpDir)
-	fmt.Printf("[VIDEO] Extrayendo frames a %d fps...\n", fps)
-
-	outputPattern := filepath.Join(tmpDir, "frame-%04d.webp")
-	cmd := exec.Command("ffmpeg",
-		"-i", videoPath,
-		"-vf", fmt.Sprintf("fps=%d,scale=500:-1", fps),
-		"-q:v", "90",
-		outputPattern,
-	)
-
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("[ERROR] ffmpeg falló: %v\n", err)
-		fmt.Println("[ERROR] Asegúrate de que ffmpeg esté instalado")
+	defer f.Close()
+
+	d, err := webm.NewReader(f)
+	if err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
 		os.Exit(1)
 	}
+	if codec := d.Track().CodecID; codec != "V_VP8" {
+		fmt.Printf("[VIDEO] Códec %s no soportado de forma nativa, se usará ffmpeg\n", codec)
+		return false
+	}
+	return true
+}
 
-	fmt.Printf("[VIDEO] ✓ Frames extraídos exitosamente\n")
+// sendWebM demuxes the video track of a WebM file and sends each frame,
+// with its alpha channel if present, at the time its container timestamp
+// gives, which is also the capture time sent with -timestamps. The file
+// cannot be made to produce a keyframe on demand, so when the receiver
+// asks for one the last keyframe is sent again, followed by the frames
+// since then that later frames are predicted from.
+func sendWebM(ws *websocket.Conn, path string, keyframes <-chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	ws, err := websocket.Dial(wsURL, "", "http://localhost/")
+	d, err := webm.NewReader(f)
 	if err != nil {
-		fmt.Printf("[ERROR] Conexión WebSocket fallida a %s: %v\n", wsURL, err)
-		os.Exit(1)
+		return err
 	}
-	defer ws.Close()
-	fmt.Printf("[WS] ✓ Conectado a %s\n", wsURL)
+	track := d.Track()
+	fmt.Printf("[VIDEO] Archivo: %s (VP8 %dx%d, alfa: %v)\n", path, track.Width, track.Height, track.Alpha)
 
-	frameDelay := time.Duration(1000/fps) * time.Millisecond
-	frameCount := 0
+	// sinceKey holds the last keyframe and the frames sent after it.
+	type sentFrame struct {
+		payload []byte
+		at      time.Time
+	}
+	var sinceKey []sentFrame
+	resend := func() error {
+		if len(sinceKey) == 0 {
+			return nil
+		}
+		fmt.Printf("[VIDEO] El receptor pidió un keyframe; se reenvían el último y %d frames posteriores\n", len(sinceKey)-1)
+		for _, f := range sinceKey {
+			if err := stampAt(ws, f.at); err != nil {
+				return err
+			}
+			if err := protocol.WriteTypedPacket(ws, protocol.FrameVP8, f.payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
-	for i := 1; i < 10000; i++ {
-		framePath := filepath.Join(tmpDir, fmt.Sprintf("frame-%04d.webp", i))
-		data, err := os.ReadFile(framePath)
-		if err != nil {
-			fmt.Printf("[VIDEO] Total de frames: %d\n", frameCount)
+	start := time.Now()
+	first := time.Duration(-1)
+	frameCount, keyCount := 0, 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		frame, err := d.Next()
+		if err == io.EOF {
 			break
 		}
-
-		packet := make([]byte, 4+len(data))
-		binary.LittleEndian.PutUint32(packet[0:4], uint32(len(data)))
-		copy(packet[4:], data)
-
-		err = websocket.Message.Send(ws, packet)
 		if err != nil {
-			fmt.Printf("[ERROR] Frame %d: %v\n", i, err)
-			os.Exit(1)
+			return err
+		}
+		if first < 0 {
+			first = frame.Timestamp
+		}
+		at := start.Add(frame.Timestamp - first)
+		for wait := time.Until(at); wait > 0; wait = time.Until(at) {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-keyframes:
+				timer.Stop()
+				if err := resend(); err != nil {
+					return fmt.Errorf("keyframe: %w", err)
+				}
+			}
+		}
+
+		payload := make([]byte, 4, 4+len(frame.Data)+len(frame.Alpha))
+		binary.LittleEndian.PutUint32(payload, uint32(len(frame.Data)))
+		payload = append(payload, frame.Data...)
+		payload = append(payload, frame.Alpha...)
+		if err := stampAt(ws, at); err != nil {
+			return fmt.Errorf("frame %d: %w", frameCount+1, err)
+		}
+		if err := protocol.WriteTypedPacket(ws, protocol.FrameVP8, payload); err != nil {
+			return fmt.Errorf("frame %d: %w", frameCount+1, err)
 		}
 
 		frameCount++
-		fmt.Printf("[FRAME %d] ✓ Enviado (%d bytes)\n", i, len(data))
-		time.Sleep(frameDelay)
+		if frame.Keyframe {
+			keyCount++
+			sinceKey = sinceKey[:0]
+		}
+		if frame.Keyframe || len(sinceKey) > 0 {
+			sinceKey = append(sinceKey, sentFrame{payload, at})
+		}
+		fmt.Printf("[FRAME %d] ✓ Enviado (%d bytes, %v)\n", frameCount, len(payload), frame.Timestamp-first)
+	}
+
+	fmt.Printf("\n[VIDEO] ✓ Completado: %d frames enviados (%d keyframes)\n", frameCount, keyCount)
+	return nil
+}
+
+// sendRecording sends the packets of a recording made with bidirect
+// -record, spaced as they were received. The capture times it holds are
+// long past, so they are left out and, with -timestamps, replaced by the
+// time each frame is sent again.
+func sendRecording(ws *websocket.Conn, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Printf("[REC] Archivo: %s\n", path)
+
+	records := protocol.NewRecordReader(f)
+	start := time.Now()
+	count := 0
+	for {
+		at, typ, data, err := records.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if typ == protocol.FrameTimestamp {
+			continue
+		}
+		if wait := time.Until(start.Add(at)); wait > 0 {
+			time.Sleep(wait)
+		}
+		if typ == protocol.FrameImage || typ == protocol.FrameVP8 {
+			if err := stamp(ws); err != nil {
+				return fmt.Errorf("paquete %d: %w", count+1, err)
+			}
+		}
+		if err := protocol.WriteTypedPacket(ws, typ, data); err != nil {
+			return fmt.Errorf("paquete %d: %w", count+1, err)
+		}
+		count++
 	}
 
-	fmt.Printf("\n[VIDEO] ✓ Completado: %d frames enviados a %s\n", frameCount, wsURL)
+	fmt.Printf("[REC] ✓ Completado: %d paquetes enviados\n", count)
+	return nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	protocol "github.com/example/bidirect/internal/websocket"
+	"golang.org/x/net/websocket"
+)
+
+func TestSendWebMResendsKeyframeOnRequest(t *testing.T) {
+	// The receiver asks for a keyframe after the first three frames and
+	// waits for the keyframe to arrive again, followed by the frames
+	// after it.
+	resent := make(chan bool, 1)
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		packets := protocol.NewPacketReader(ws)
+		var frames [][]byte
+		again := false
+		for {
+			typ, data, err := packets.Next()
+			if err != nil {
+				resent <- false
+				return
+			}
+			if typ != protocol.FrameVP8 {
+				continue
+			}
+			if again {
+				resent <- bytes.Equal(data, frames[1])
+				return
+			}
+			if len(frames) >= 3 && bytes.Equal(data, frames[0]) {
+				again = true
+				continue
+			}
+			frames = append(frames, bytes.Clone(data))
+			if len(frames) == 3 {
+				if err := protocol.WriteTypedPacket(ws, protocol.FrameKeyframeRequest, nil); err != nil {
+					resent <- false
+					return
+				}
+			}
+		}
+	}))
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	keyframes := make(chan struct{}, 1)
+	go readReceiver(ws, url, keyframes)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sendWebM(ws, "../../video.webm", keyframes)
+	}()
+
+	if !<-resent {
+		t.Error("the keyframe and the frames after it were not sent again after the receiver asked for it")
+	}
+	ws.Close()
+	<-done
+}
+
+func TestSendRecordingKeepsTiming(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.rec")
+	rec, err := protocol.NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Write(protocol.FrameTimestamp, make([]byte, 8))
+	rec.Write(protocol.FrameImage, []byte("first"))
+	time.Sleep(100 * time.Millisecond)
+	rec.Write(protocol.FrameControl, []byte(`{"hud":true}`))
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type packet struct {
+		typ  byte
+		data string
+		at   time.Time
+	}
+	got := make(chan []packet, 1)
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var packets []packet
+		defer func() { got <- packets }()
+		r := protocol.NewPacketReader(ws)
+		for {
+			typ, data, err := r.Next()
+			if err != nil {
+				return
+			}
+			packets = append(packets, packet{typ, string(data), time.Now()})
+		}
+	}))
+	defer ts.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendRecording(ws, path); err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+
+	packets := <-got
+	if len(packets) != 2 || packets[0].typ != protocol.FrameImage || packets[0].data != "first" ||
+		packets[1].typ != protocol.FrameControl || packets[1].data != `{"hud":true}` {
+		t.Fatalf("received %+v, want the image and the control message", packets)
+	}
+	if gap := packets[1].at.Sub(packets[0].at); gap < 80*time.Millisecond {
+		t.Errorf("packets %v apart, want the recorded 100ms", gap)
+	}
+}
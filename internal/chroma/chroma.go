@@ -0,0 +1,138 @@
+// Package chroma turns a key color in decoded frames into transparency,
+// so sources without an alpha channel, such as webcams and JPEGs, can be
+// shaped on the receiver.
+package chroma
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+)
+
+// Color is an opaque RGB color, written as "#rrggbb" in text form.
+type Color struct {
+	R, G, B uint8
+}
+
+func (c Color) String() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Color) UnmarshalText(text []byte) error {
+	var rgb [3]byte
+	if len(text) != 7 || text[0] != '#' {
+		return fmt.Errorf("chroma: color %q is not #rrggbb", text)
+	}
+	if _, err := hex.Decode(rgb[:], text[1:]); err != nil {
+		return fmt.Errorf("chroma: color %q is not #rrggbb", text)
+	}
+	*c = Color{rgb[0], rgb[1], rgb[2]}
+	return nil
+}
+
+// Key describes a chroma key. Distances are measured between colors in
+// the CbCr plane, where the largest possible distance is about 1.
+type Key struct {
+	Enabled bool  `json:"enabled"`
+	Color   Color `json:"color"`
+	// Similarity is the distance from Color within which pixels become
+	// fully transparent.
+	Similarity float64 `json:"similarity"`
+	// Smoothness is the width of the band beyond Similarity over which
+	// pixels fade back to opaque.
+	Smoothness float64 `json:"smoothness"`
+	// Spill is the width of the band beyond Similarity over which the key
+	// color is removed from the pixels that stay, so edges lose their
+	// green or blue fringe. 0 disables spill suppression.
+	Spill float64 `json:"spill"`
+}
+
+// DefaultKey returns a disabled green-screen key.
+func DefaultKey() Key {
+	return Key{
+		Color:      Color{G: 255},
+		Similarity: 0.4,
+		Smoothness: 0.08,
+		Spill:      0.1,
+	}
+}
+
+// chromaOf returns the BT.601 Cb and Cr of an RGB color in [0, 1].
+func chromaOf(r, g, b float64) (cb, cr float64) {
+	cb = -0.168736*r - 0.331264*g + 0.5*b
+	cr = 0.5*r - 0.418688*g - 0.081312*b
+	return cb, cr
+}
+
+// Apply keys pix, a premultiplied BGRA frame, in place. It does nothing
+// when the key is disabled.
+func (k Key) Apply(pix []byte) {
+	if !k.Enabled {
+		return
+	}
+
+	kcb, kcr := chromaOf(float64(k.Color.R)/255, float64(k.Color.G)/255, float64(k.Color.B)/255)
+	// Unit vector towards the key color, used to remove spill.
+	kn := math.Hypot(kcb, kcr)
+	kx, ky := 0.0, 0.0
+	if kn > 0 {
+		kx, ky = kcb/kn, kcr/kn
+	}
+
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := pix[i+3]
+		if a == 0 {
+			continue
+		}
+		scale := 1.0 / 255
+		if a != 0xff {
+			scale = 1.0 / float64(a)
+		}
+		b := float64(pix[i+0]) * scale
+		g := float64(pix[i+1]) * scale
+		r := float64(pix[i+2]) * scale
+
+		cb, cr := chromaOf(r, g, b)
+		d := math.Hypot(cb-kcb, cr-kcr) - k.Similarity
+
+		alpha := 1.0
+		switch {
+		case d <= 0:
+			alpha = 0
+		case d < k.Smoothness:
+			alpha = d / k.Smoothness
+		}
+		if alpha == 0 {
+			pix[i+0], pix[i+1], pix[i+2], pix[i+3] = 0, 0, 0, 0
+			continue
+		}
+
+		if k.Spill > 0 && d < k.Spill {
+			if proj := cb*kx + cr*ky; proj > 0 {
+				amount := 1 - math.Pow(max(d, 0)/k.Spill, 1.5)
+				cb -= proj * amount * kx
+				cr -= proj * amount * ky
+				y := 0.299*r + 0.587*g + 0.114*b
+				r = y + 1.402*cr
+				g = y - 0.344136*cb - 0.714136*cr
+				b = y + 1.772*cb
+			}
+		}
+
+		alpha *= float64(a)
+		pix[i+0] = premul(b, alpha)
+		pix[i+1] = premul(g, alpha)
+		pix[i+2] = premul(r, alpha)
+		pix[i+3] = uint8(alpha + 0.5)
+	}
+}
+
+// premul premultiplies a color component in [0, 1] by alpha in [0, 255].
+func premul(v, alpha float64) uint8 {
+	v = min(max(v, 0), 1) * alpha
+	return uint8(v + 0.5)
+}
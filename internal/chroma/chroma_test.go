@@ -0,0 +1,83 @@
+package chroma
+
+import "testing"
+
+func pixel(r, g, b uint8) []byte {
+	return []byte{b, g, r, 0xff}
+}
+
+func TestApplyKeysGreen(t *testing.T) {
+	k := DefaultKey()
+	k.Enabled = true
+
+	green := pixel(20, 230, 30)
+	k.Apply(green)
+	if green[3] != 0 {
+		t.Errorf("green alpha = %d, want 0", green[3])
+	}
+
+	red := pixel(200, 30, 40)
+	k.Apply(red)
+	if want := pixel(200, 30, 40); string(red) != string(want) {
+		t.Errorf("red = %v, want unchanged %v", red, want)
+	}
+}
+
+func TestApplySmoothEdge(t *testing.T) {
+	k := DefaultKey()
+	k.Enabled = true
+	k.Smoothness = 0.3
+	k.Spill = 0
+
+	// A muddy green sits between the fully keyed and the untouched band.
+	px := pixel(120, 160, 120)
+	k.Apply(px)
+	if px[3] == 0 || px[3] == 0xff {
+		t.Fatalf("alpha = %d, want partial", px[3])
+	}
+	for c := 0; c < 3; c++ {
+		if px[c] > px[3] {
+			t.Errorf("component %d = %d exceeds alpha %d", c, px[c], px[3])
+		}
+	}
+}
+
+func TestApplySuppressesSpill(t *testing.T) {
+	k := DefaultKey()
+	k.Enabled = true
+	k.Smoothness = 0
+	k.Spill = 0.5
+
+	// A greenish gray stays opaque but loses most of its green cast.
+	px := pixel(120, 160, 120)
+	k.Apply(px)
+	if px[3] != 0xff {
+		t.Fatalf("alpha = %d, want 255", px[3])
+	}
+	if cast := int(px[1]) - int(px[2]); cast >= 40 || cast < 0 {
+		t.Errorf("green cast = %d, want reduced from 40", cast)
+	}
+}
+
+func TestApplyDisabled(t *testing.T) {
+	px := pixel(0, 255, 0)
+	DefaultKey().Apply(px)
+	if px[3] != 0xff {
+		t.Error("disabled key changed the frame")
+	}
+}
+
+func TestColorText(t *testing.T) {
+	var c Color
+	if err := c.UnmarshalText([]byte("#12ab0f")); err != nil {
+		t.Fatal(err)
+	}
+	if c != (Color{0x12, 0xab, 0x0f}) || c.String() != "#12ab0f" {
+		t.Errorf("color = %v", c)
+	}
+	for _, bad := range []string{"12ab0f", "#12ab0", "#12ab0g"} {
+		if err := c.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q) succeeded", bad)
+		}
+	}
+}
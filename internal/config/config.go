@@ -1,5 +1,13 @@
 package config
 
+import (
+	"time"
+
+	"github.com/example/bidirect/internal/chroma"
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/transition"
+)
+
 type Config struct {
 	InitialSize    int
 	KeepAspect     bool
@@ -9,6 +17,51 @@ type Config struct {
 	BorderGrabSize int
 	WindowTitle    string
 	WSPort         int
+	DialURL        string
+	AuthToken      string
+	Headless       bool
+	RecordPath     string
+	DecodeWorkers  int
+	LatestOnly     bool
+	Fit            scale.Mode
+	ScaleFilter    scale.Filter
+	ChromaKey      chroma.Key
+	Mask           string
+	Filters        string
+	HUD            bool
+	// MaxFPS caps how often the window presents frames; 0 is uncapped.
+	MaxFPS int
+	// Frames claiming a larger size are rejected before decoding; 0 is
+	// unlimited.
+	MaxFrameWidth  int
+	MaxFrameHeight int
+	MaxFramePixels int
+	// MaxConnMemory bounds, in bytes, the decoded frames held on behalf of
+	// one connection; 0 is unlimited.
+	MaxConnMemory int64
+	// Formats lists the image formats accepted per stream name; the ""
+	// entry applies to streams without one. Without entries every
+	// registered format is accepted.
+	Formats map[string][]string
+	// RingDepth is how many decoded frames the receiver buffers, and
+	// RingPolicy which of them it presents.
+	RingDepth  int
+	RingPolicy RingPolicy
+	// Transition is the effect shown when a new frame replaces one that
+	// has been on screen for at least TransitionDuration.
+	Transition         transition.Effect
+	TransitionDuration time.Duration
+	// Waiting is shown until the first frame arrives: "logo", "pulse" or
+	// the path of an image.
+	Waiting string
+	// SignalLost is what becomes of the last frame once no publisher has
+	// been connected for SignalLostAfter: "keep", "dim", "fade", "logo",
+	// "pulse" or the path of an image.
+	SignalLost      string
+	SignalLostAfter time.Duration
+	// HideWhenIdle hides the window while waiting and once the signal is
+	// lost.
+	HideWhenIdle bool
 }
 
 func DefaultConfig() Config {
@@ -21,5 +74,19 @@ func DefaultConfig() Config {
 		BorderGrabSize: 8,
 		WindowTitle:    "BiDirect",
 		WSPort:         8080,
+		Fit:            scale.Fit,
+		ScaleFilter:    scale.Bilinear,
+		ChromaKey:      chroma.DefaultKey(),
+		MaxFPS:         60,
+		MaxFrameWidth:  8192,
+		MaxFrameHeight: 8192,
+		MaxFramePixels: 4096 * 4096,
+		MaxConnMemory:  512 << 20,
+		RingDepth:      3,
+		// Transitions are off until an effect is chosen.
+		TransitionDuration: 400 * time.Millisecond,
+		Waiting:            "logo",
+		SignalLost:         "keep",
+		SignalLostAfter:    5 * time.Second,
 	}
 }
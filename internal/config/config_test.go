@@ -1,6 +1,12 @@
 package config
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/transition"
+)
 
 func TestDefaultConfig(t *testing.T) {
 	cfg := DefaultConfig()
@@ -8,11 +14,11 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.InitialSize != 400 {
 		t.Errorf("InitialSize = %d, want 400", cfg.InitialSize)
 	}
-	if !cfg.KeepAspect {
-		t.Error("KeepAspect should be true")
+	if cfg.KeepAspect {
+		t.Error("KeepAspect should be false")
 	}
-	if cfg.MinSize != 150 {
-		t.Errorf("MinSize = %d, want 150", cfg.MinSize)
+	if cfg.MinSize != 100 {
+		t.Errorf("MinSize = %d, want 100", cfg.MinSize)
 	}
 	if cfg.AlphaThreshold != 10 {
 		t.Errorf("AlphaThreshold = %d, want 10", cfg.AlphaThreshold)
@@ -20,13 +26,34 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.BorderGrabSize != 8 {
 		t.Errorf("BorderGrabSize = %d, want 8", cfg.BorderGrabSize)
 	}
-	if cfg.FPS != 60 {
-		t.Errorf("FPS = %d, want 60", cfg.FPS)
+	if cfg.WindowTitle != "BiDirect" {
+		t.Errorf("WindowTitle = %q, want BiDirect", cfg.WindowTitle)
+	}
+	if cfg.WSPort != 8080 {
+		t.Errorf("WSPort = %d, want 8080", cfg.WSPort)
+	}
+	if cfg.Fit != scale.Fit || cfg.ScaleFilter != scale.Bilinear {
+		t.Errorf("scaling = %v/%v, want fit/bilinear", cfg.Fit, cfg.ScaleFilter)
+	}
+	if cfg.ChromaKey.Enabled {
+		t.Error("ChromaKey should be disabled")
+	}
+	if cfg.MaxFPS != 60 {
+		t.Errorf("MaxFPS = %d, want 60", cfg.MaxFPS)
+	}
+	if cfg.MaxFrameWidth != 8192 || cfg.MaxFrameHeight != 8192 || cfg.MaxFramePixels != 4096*4096 {
+		t.Errorf("frame limits = %dx%d, %d pixels", cfg.MaxFrameWidth, cfg.MaxFrameHeight, cfg.MaxFramePixels)
+	}
+	if cfg.MaxConnMemory != 512<<20 {
+		t.Errorf("MaxConnMemory = %d, want 512MB", cfg.MaxConnMemory)
+	}
+	if cfg.RingDepth != 3 || cfg.RingPolicy != LatestWins {
+		t.Errorf("ring = %d slots, policy %v; want 3, latest", cfg.RingDepth, cfg.RingPolicy)
 	}
-	if !cfg.AnimationEnabled {
-		t.Error("AnimationEnabled should be true")
+	if cfg.Transition != transition.None || cfg.TransitionDuration != 400*time.Millisecond {
+		t.Errorf("transition = %v for %v, want none for 400ms", cfg.Transition, cfg.TransitionDuration)
 	}
-	if cfg.WindowTitle != "Heart" {
-		t.Errorf("WindowTitle = %q, want Heart", cfg.WindowTitle)
+	if cfg.Waiting != "logo" || cfg.SignalLost != "keep" || cfg.SignalLostAfter != 5*time.Second {
+		t.Errorf("idle = %q, %q after %v", cfg.Waiting, cfg.SignalLost, cfg.SignalLostAfter)
 	}
 }
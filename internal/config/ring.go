@@ -0,0 +1,39 @@
+package config
+
+import "fmt"
+
+// RingPolicy is what the receiver's frame buffer does when frames arrive
+// faster than they are presented.
+type RingPolicy int
+
+const (
+	// LatestWins presents only the newest frame; older ones that were never
+	// presented are skipped.
+	LatestWins RingPolicy = iota
+	// FIFO presents frames in arrival order. When the buffer is full the
+	// oldest frame not yet presented is dropped, bounding the delay.
+	FIFO
+)
+
+var ringPolicyNames = [...]string{LatestWins: "latest", FIFO: "fifo"}
+
+func (p RingPolicy) String() string {
+	if p >= 0 && int(p) < len(ringPolicyNames) {
+		return ringPolicyNames[p]
+	}
+	return fmt.Sprintf("RingPolicy(%d)", int(p))
+}
+
+func (p RingPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *RingPolicy) UnmarshalText(text []byte) error {
+	for i, name := range ringPolicyNames {
+		if string(text) == name {
+			*p = RingPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("config: unknown ring policy %q (want latest or fifo)", text)
+}
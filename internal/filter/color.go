@@ -0,0 +1,129 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+)
+
+// lut maps straight (not premultiplied) 8-bit color components.
+type lut [256]uint8
+
+func newLUT(fn func(v float64) float64) *lut {
+	var t lut
+	for i := range t {
+		v := fn(float64(i)/255) * 255
+		t[i] = uint8(min(max(v, 0), 255) + 0.5)
+	}
+	return &t
+}
+
+// apply maps the color components of every pixel, undoing and redoing the
+// premultiplication for translucent ones.
+func (t *lut) apply(pix []byte) {
+	for i := 0; i+3 < len(pix); i += 4 {
+		switch a := pix[i+3]; a {
+		case 0:
+		case 0xff:
+			pix[i+0], pix[i+1], pix[i+2] = t[pix[i+0]], t[pix[i+1]], t[pix[i+2]]
+		default:
+			for c := i; c < i+3; c++ {
+				straight := min(255, (uint32(pix[c])*255+uint32(a)/2)/uint32(a))
+				pix[c] = uint8((uint32(t[straight])*uint32(a) + 127) / 255)
+			}
+		}
+	}
+}
+
+// Brightness adds Offset, from -1 to 1, to every color component.
+type Brightness struct {
+	Offset float64
+	table  *lut
+}
+
+func NewBrightness(offset float64) Brightness {
+	return Brightness{offset, newLUT(func(v float64) float64 { return v + offset })}
+}
+
+func (b Brightness) String() string { return "brightness=" + formatFloat(b.Offset) }
+func (b Brightness) Apply(f *Frame) { b.table.apply(f.Pix) }
+
+// Contrast scales every color component's distance from mid-gray by
+// Factor; 1 leaves the frame unchanged.
+type Contrast struct {
+	Factor float64
+	table  *lut
+}
+
+func NewContrast(factor float64) Contrast {
+	return Contrast{factor, newLUT(func(v float64) float64 { return (v-0.5)*factor + 0.5 })}
+}
+
+func (c Contrast) String() string { return "contrast=" + formatFloat(c.Factor) }
+func (c Contrast) Apply(f *Frame) { c.table.apply(f.Pix) }
+
+// Gamma applies a gamma correction; values above 1 brighten midtones.
+type Gamma struct {
+	Gamma float64
+	table *lut
+}
+
+func NewGamma(gamma float64) Gamma {
+	return Gamma{gamma, newLUT(func(v float64) float64 { return math.Pow(v, 1/gamma) })}
+}
+
+func (g Gamma) String() string { return "gamma=" + formatFloat(g.Gamma) }
+func (g Gamma) Apply(f *Frame) { g.table.apply(f.Pix) }
+
+// Saturation scales every pixel's distance from its own luma by Factor:
+// 0 is grayscale, 1 leaves the frame unchanged and larger values
+// intensify colors.
+type Saturation struct {
+	Factor float64
+}
+
+func (s Saturation) String() string { return "saturation=" + formatFloat(s.Factor) }
+
+func (s Saturation) Apply(f *Frame) {
+	// Mixing with the luma is linear, so it works on premultiplied colors
+	// as long as the result is clamped to alpha.
+	k := int32(s.Factor * 1024)
+	pix := f.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := int32(pix[i+3])
+		if a == 0 {
+			continue
+		}
+		b, g, r := int32(pix[i+0]), int32(pix[i+1]), int32(pix[i+2])
+		y := (r*306 + g*601 + b*117 + 512) >> 10
+		pix[i+0] = clampTo(y+((b-y)*k+512)>>10, a)
+		pix[i+1] = clampTo(y+((g-y)*k+512)>>10, a)
+		pix[i+2] = clampTo(y+((r-y)*k+512)>>10, a)
+	}
+}
+
+func clampTo(v, hi int32) uint8 {
+	return uint8(min(max(v, 0), hi))
+}
+
+// Grayscale replaces every pixel's color with its luma.
+type Grayscale struct{}
+
+func (Grayscale) String() string { return "grayscale" }
+func (Grayscale) Apply(f *Frame) { Saturation{}.Apply(f) }
+
+// Opacity multiplies the whole frame by Alpha, from 0 to 1.
+type Opacity struct {
+	Alpha float64
+}
+
+func (o Opacity) String() string { return fmt.Sprintf("opacity=%s", formatFloat(o.Alpha)) }
+
+func (o Opacity) Apply(f *Frame) {
+	k := uint32(min(max(o.Alpha, 0), 1)*256 + 0.5)
+	if k >= 256 {
+		return
+	}
+	for i, v := range f.Pix {
+		f.Pix[i] = uint8((uint32(v)*k + 128) >> 8)
+	}
+}
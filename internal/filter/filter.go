@@ -0,0 +1,215 @@
+// Package filter transforms decoded frames on their way from the decoder
+// to the ring buffer.
+//
+// Pipelines are written as a comma-separated list of filters, each a name
+// optionally followed by "=" and colon-separated arguments:
+//
+//	crop=X:Y:W:H  rotate=90|180|270  fliph  flipv
+//	brightness=B  contrast=C  saturation=S  gamma=G  grayscale  opacity=A
+//
+// For example "fliph,crop=0:60:640:360,saturation=1.2".
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Frame is a premultiplied BGRA image passed through a pipeline.
+type Frame struct {
+	Pix    []byte
+	Width  int
+	Height int
+
+	// Get, if set, supplies buffers to filters that cannot work in place,
+	// and Put takes back the buffers they replace.
+	Get func(n int) []byte
+	Put func(b []byte)
+}
+
+// replace swaps in a new pixel buffer of the given size and returns the
+// old one, which the caller must release with release once done with it.
+func (f *Frame) replace(width, height int) (old []byte) {
+	old = f.Pix
+	n := width * height * 4
+	if f.Get != nil {
+		f.Pix = f.Get(n)
+	} else {
+		f.Pix = make([]byte, n)
+	}
+	f.Width, f.Height = width, height
+	return old
+}
+
+func (f *Frame) release(b []byte) {
+	if f.Put != nil {
+		f.Put(b)
+	}
+}
+
+// Filter transforms a frame, in place or by replacing its buffer.
+// Filters are immutable and safe for concurrent use.
+type Filter interface {
+	Apply(f *Frame)
+	// String returns the filter in pipeline syntax.
+	String() string
+}
+
+// Pipeline is an ordered, immutable list of filters. A nil Pipeline has
+// no filters.
+type Pipeline struct {
+	filters []Filter
+}
+
+func New(filters ...Filter) *Pipeline {
+	if len(filters) == 0 {
+		return nil
+	}
+	return &Pipeline{filters: filters}
+}
+
+// Apply runs every filter in order.
+func (p *Pipeline) Apply(f *Frame) {
+	if p == nil {
+		return
+	}
+	for _, filter := range p.filters {
+		filter.Apply(f)
+	}
+}
+
+// Len returns the number of filters.
+func (p *Pipeline) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.filters)
+}
+
+func (p *Pipeline) String() string {
+	if p == nil {
+		return ""
+	}
+	names := make([]string, len(p.filters))
+	for i, filter := range p.filters {
+		names[i] = filter.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func (p *Pipeline) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Pipeline) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	if parsed == nil {
+		*p = Pipeline{}
+	} else {
+		*p = *parsed
+	}
+	return nil
+}
+
+// Parse parses a pipeline spec. An empty spec yields a nil Pipeline.
+func Parse(spec string) (*Pipeline, error) {
+	var filters []Filter
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(item, "=")
+		f, err := parseFilter(strings.ToLower(name), arg)
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", item, err)
+		}
+		filters = append(filters, f)
+	}
+	return New(filters...), nil
+}
+
+func parseFilter(name, arg string) (Filter, error) {
+	switch name {
+	case "crop":
+		v, err := parseInts(arg, 4)
+		if err != nil {
+			return nil, err
+		}
+		if v[2] <= 0 || v[3] <= 0 {
+			return nil, fmt.Errorf("crop size must be positive")
+		}
+		return Crop{X: v[0], Y: v[1], Width: v[2], Height: v[3]}, nil
+	case "rotate":
+		v, err := parseInts(arg, 1)
+		if err != nil {
+			return nil, err
+		}
+		return NewRotate(v[0])
+	case "fliph", "mirror":
+		return FlipH{}, noArg(arg)
+	case "flipv":
+		return FlipV{}, noArg(arg)
+	case "grayscale":
+		return Grayscale{}, noArg(arg)
+	case "brightness":
+		v, err := parseFloat(arg)
+		return NewBrightness(v), err
+	case "contrast":
+		v, err := parseFloat(arg)
+		return NewContrast(v), err
+	case "gamma":
+		v, err := parseFloat(arg)
+		if err == nil && v <= 0 {
+			err = fmt.Errorf("gamma must be positive")
+		}
+		return NewGamma(v), err
+	case "saturation":
+		v, err := parseFloat(arg)
+		return Saturation{Factor: v}, err
+	case "opacity":
+		v, err := parseFloat(arg)
+		if err == nil && (v < 0 || v > 1) {
+			err = fmt.Errorf("opacity must be between 0 and 1")
+		}
+		return Opacity{Alpha: v}, err
+	}
+	return nil, fmt.Errorf("unknown filter")
+}
+
+func noArg(arg string) error {
+	if arg != "" {
+		return fmt.Errorf("takes no argument")
+	}
+	return nil
+}
+
+func parseInts(arg string, n int) ([]int, error) {
+	parts := strings.Split(arg, ":")
+	if len(parts) != n {
+		return nil, fmt.Errorf("want %d integer arguments", n)
+	}
+	v := make([]int, n)
+	for i, p := range parts {
+		var err error
+		if v[i], err = strconv.Atoi(p); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func parseFloat(arg string) (float64, error) {
+	if arg == "" {
+		return 0, fmt.Errorf("missing argument")
+	}
+	return strconv.ParseFloat(arg, 64)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
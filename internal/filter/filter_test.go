@@ -0,0 +1,123 @@
+package filter
+
+import (
+	"testing"
+)
+
+// numbered returns a w x h opaque frame whose pixel i has blue = i.
+func numbered(w, h int) *Frame {
+	f := &Frame{Pix: make([]byte, w*h*4), Width: w, Height: h}
+	for i := 0; i < w*h; i++ {
+		f.Pix[i*4+0] = byte(i)
+		f.Pix[i*4+3] = 0xff
+	}
+	return f
+}
+
+// ids returns the blue component of every pixel, row by row.
+func ids(f *Frame) []byte {
+	out := make([]byte, f.Width*f.Height)
+	for i := range out {
+		out[i] = f.Pix[i*4]
+	}
+	return out
+}
+
+func TestGeometry(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	tests := []struct {
+		spec string
+		w, h int
+		want []byte
+	}{
+		{"fliph", 3, 2, []byte{2, 1, 0, 5, 4, 3}},
+		{"flipv", 3, 2, []byte{3, 4, 5, 0, 1, 2}},
+		{"rotate=180", 3, 2, []byte{5, 4, 3, 2, 1, 0}},
+		{"rotate=90", 2, 3, []byte{3, 0, 4, 1, 5, 2}},
+		{"rotate=270", 2, 3, []byte{2, 5, 1, 4, 0, 3}},
+		{"crop=1:0:2:2", 2, 2, []byte{1, 2, 4, 5}},
+		{"crop=2:1:5:5", 1, 1, []byte{5}},
+		{"rotate=90,fliph", 2, 3, []byte{0, 3, 1, 4, 2, 5}},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		f := numbered(3, 2)
+		p.Apply(f)
+		if f.Width != tt.w || f.Height != tt.h || string(ids(f)) != string(tt.want) {
+			t.Errorf("%s: got %dx%d %v, want %dx%d %v", tt.spec, f.Width, f.Height, ids(f), tt.w, tt.h, tt.want)
+		}
+	}
+}
+
+func TestRotateUsesBuffers(t *testing.T) {
+	var got, put int
+	f := numbered(4, 2)
+	f.Get = func(n int) []byte { got++; return make([]byte, n) }
+	f.Put = func([]byte) { put++ }
+
+	r, _ := NewRotate(90)
+	r.Apply(f)
+	if got != 1 || put != 1 {
+		t.Errorf("Get called %d times, Put %d times; want 1 and 1", got, put)
+	}
+}
+
+func TestColorFilters(t *testing.T) {
+	px := func(b, g, r, a byte) *Frame {
+		return &Frame{Pix: []byte{b, g, r, a}, Width: 1, Height: 1}
+	}
+	tests := []struct {
+		spec string
+		in   *Frame
+		want []byte
+	}{
+		{"brightness=0.2", px(10, 100, 240, 255), []byte{61, 151, 255, 255}},
+		// Translucent pixels are adjusted as straight colors.
+		{"brightness=0.2", px(50, 0, 100, 128), []byte{76, 26, 125, 128}},
+		{"contrast=2", px(64, 128, 192, 255), []byte{0, 129, 255, 255}},
+		{"gamma=1", px(1, 2, 3, 255), []byte{1, 2, 3, 255}},
+		{"grayscale", px(0, 0, 255, 255), []byte{76, 76, 76, 255}},
+		{"saturation=1", px(10, 100, 240, 255), []byte{10, 100, 240, 255}},
+		{"saturation=3", px(100, 100, 120, 128), []byte{88, 88, 128, 128}},
+		{"opacity=0.5", px(200, 100, 50, 255), []byte{100, 50, 25, 128}},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		p.Apply(tt.in)
+		if string(tt.in.Pix) != string(tt.want) {
+			t.Errorf("%s = %v, want %v", tt.spec, tt.in.Pix, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	spec := "mirror, crop=0:10:64:32,rotate=270,brightness=-0.1,contrast=1.5,saturation=0.5,gamma=2.2,grayscale,opacity=0.8"
+	p, err := Parse(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "fliph,crop=0:10:64:32,rotate=270,brightness=-0.1,contrast=1.5,saturation=0.5,gamma=2.2,grayscale,opacity=0.8"
+	if got := p.String(); got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+	if p.Len() != 9 {
+		t.Errorf("Len = %d, want 9", p.Len())
+	}
+
+	if p, err := Parse(" "); err != nil || p != nil {
+		t.Errorf("Parse(blank) = %v, %v; want nil pipeline", p, err)
+	}
+
+	for _, bad := range []string{"blur", "rotate=45", "crop=1:2:3", "crop=0:0:0:10", "fliph=1", "gamma=0", "opacity=2", "brightness"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package filter
+
+import "fmt"
+
+// Crop keeps the Width x Height rectangle at (X, Y), clipped to the frame.
+type Crop struct {
+	X, Y, Width, Height int
+}
+
+func (c Crop) String() string {
+	return fmt.Sprintf("crop=%d:%d:%d:%d", c.X, c.Y, c.Width, c.Height)
+}
+
+func (c Crop) Apply(f *Frame) {
+	x0, y0 := min(max(c.X, 0), f.Width), min(max(c.Y, 0), f.Height)
+	x1, y1 := min(max(c.X+c.Width, x0), f.Width), min(max(c.Y+c.Height, y0), f.Height)
+	w, h := x1-x0, y1-y0
+	if w == f.Width && h == f.Height {
+		return
+	}
+
+	// Rows only ever move towards the start of the buffer, so they can be
+	// copied in place.
+	for y := 0; y < h; y++ {
+		src := ((y0+y)*f.Width + x0) * 4
+		copy(f.Pix[y*w*4:(y+1)*w*4], f.Pix[src:src+w*4])
+	}
+	f.Pix = f.Pix[:w*h*4]
+	f.Width, f.Height = w, h
+}
+
+// Rotate turns the frame clockwise by a multiple of 90 degrees.
+type Rotate struct {
+	degrees int
+}
+
+func NewRotate(degrees int) (Rotate, error) {
+	switch degrees {
+	case 90, 180, 270:
+		return Rotate{degrees}, nil
+	}
+	return Rotate{}, fmt.Errorf("rotation must be 90, 180 or 270 degrees")
+}
+
+func (r Rotate) String() string {
+	return fmt.Sprintf("rotate=%d", r.degrees)
+}
+
+func (r Rotate) Apply(f *Frame) {
+	if r.degrees == 180 {
+		reversePixels(f.Pix[:f.Width*f.Height*4])
+		return
+	}
+	if r.degrees != 90 && r.degrees != 270 {
+		return
+	}
+
+	w, h := f.Width, f.Height
+	src := f.replace(h, w)
+	dst := f.Pix
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Clockwise, (x, y) moves to (h-1-y, x); counterclockwise to
+			// (y, w-1-x). The rotated frame is h pixels wide.
+			var dx, dy int
+			if r.degrees == 90 {
+				dx, dy = h-1-y, x
+			} else {
+				dx, dy = y, w-1-x
+			}
+			copy(dst[(dy*h+dx)*4:(dy*h+dx)*4+4], src[(y*w+x)*4:(y*w+x)*4+4])
+		}
+	}
+	f.release(src)
+}
+
+// FlipH mirrors the frame left to right, as a webcam preview does.
+type FlipH struct{}
+
+func (FlipH) String() string { return "fliph" }
+
+func (FlipH) Apply(f *Frame) {
+	for y := 0; y < f.Height; y++ {
+		reversePixels(f.Pix[y*f.Width*4 : (y+1)*f.Width*4])
+	}
+}
+
+// FlipV turns the frame upside down.
+type FlipV struct{}
+
+func (FlipV) String() string { return "flipv" }
+
+func (FlipV) Apply(f *Frame) {
+	stride := f.Width * 4
+	for top, bottom := 0, f.Height-1; top < bottom; top, bottom = top+1, bottom-1 {
+		a := f.Pix[top*stride : (top+1)*stride]
+		b := f.Pix[bottom*stride : (bottom+1)*stride]
+		for i := range a {
+			a[i], b[i] = b[i], a[i]
+		}
+	}
+}
+
+// reversePixels reverses the order of the 4-byte pixels in pix.
+func reversePixels(pix []byte) {
+	for i, j := 0, len(pix)-4; i < j; i, j = i+4, j-4 {
+		pix[i+0], pix[j+0] = pix[j+0], pix[i+0]
+		pix[i+1], pix[j+1] = pix[j+1], pix[i+1]
+		pix[i+2], pix[j+2] = pix[j+2], pix[i+2]
+		pix[i+3], pix[j+3] = pix[j+3], pix[i+3]
+	}
+}
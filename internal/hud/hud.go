@@ -0,0 +1,110 @@
+// Package hud draws a performance heads-up display into frames.
+package hud
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Stats is what the HUD shows.
+type Stats struct {
+	ReceiveFPS float64
+	RenderFPS  float64
+	// Dropped counts the frames overwritten before they were presented.
+	Dropped uint64
+	// Decode is the average time spent decoding a frame.
+	Decode time.Duration
+	// Latency is the time from receiving the newest frame to presenting it,
+	// and Transit the time from its capture on the sender to receiving it,
+	// or zero if the sender did not timestamp it.
+	Latency   time.Duration
+	Transit   time.Duration
+	Width     int
+	Height    int
+	Publisher string
+
+	// Seq, Stream, ConnID, Format and EncodedSize describe the frame on
+	// screen.
+	Seq         uint64
+	Stream      string
+	ConnID      uint64
+	Format      string
+	EncodedSize int
+}
+
+// Lines returns the HUD's text.
+func (s Stats) Lines() []string {
+	publisher := s.Publisher
+	if publisher == "" {
+		publisher = "-"
+	}
+	transit := "-"
+	if s.Transit > 0 {
+		transit = formatMillis(s.Transit)
+	}
+	format := s.Format
+	if format == "" {
+		format = "-"
+	}
+	return []string{
+		fmt.Sprintf("recv   %5.1f fps", s.ReceiveFPS),
+		fmt.Sprintf("render %5.1f fps", s.RenderFPS),
+		fmt.Sprintf("drop   %d", s.Dropped),
+		fmt.Sprintf("decode %s", formatMillis(s.Decode)),
+		fmt.Sprintf("latency %s", formatMillis(s.Latency)),
+		fmt.Sprintf("transit %s", transit),
+		fmt.Sprintf("frame  %dx%d %s %.1f KB", s.Width, s.Height, format, float64(s.EncodedSize)/1024),
+		fmt.Sprintf("seq    %d on %s", s.Seq, s.Stream),
+		fmt.Sprintf("from   %s #%d", publisher, s.ConnID),
+	}
+}
+
+func formatMillis(d time.Duration) string {
+	return fmt.Sprintf("%.1f ms", float64(d)/float64(time.Millisecond))
+}
+
+const (
+	margin  = 4
+	padding = 4
+)
+
+var (
+	face       = basicfont.Face7x13
+	background = image.NewUniform(color.RGBA{A: 160})
+	foreground = image.NewUniform(color.White)
+)
+
+// Draw composites the HUD for s over the top-left corner of pix, a
+// premultiplied BGRA frame, clipped to the frame.
+func Draw(pix []byte, width, height int, s Stats) {
+	if width <= 0 || height <= 0 || len(pix) < width*height*4 {
+		return
+	}
+	// The HUD is drawn in black and white only, so treating BGRA as RGBA
+	// swaps nothing visible.
+	dst := &image.RGBA{Pix: pix, Stride: width * 4, Rect: image.Rect(0, 0, width, height)}
+
+	lines := s.Lines()
+	widest := 0
+	for _, line := range lines {
+		widest = max(widest, len(line))
+	}
+	lineHeight := face.Height
+	panel := image.Rect(margin, margin,
+		margin+2*padding+widest*face.Advance,
+		margin+2*padding+len(lines)*lineHeight)
+	draw.Draw(dst, panel.Intersect(dst.Rect), background, image.Point{}, draw.Over)
+
+	d := font.Drawer{Dst: dst, Src: foreground, Face: face}
+	for i, line := range lines {
+		d.Dot = fixed.P(margin+padding, margin+padding+i*lineHeight+face.Ascent)
+		d.DrawString(line)
+	}
+}
@@ -0,0 +1,62 @@
+package hud
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLines(t *testing.T) {
+	s := Stats{
+		ReceiveFPS:  29.97,
+		RenderFPS:   60,
+		Dropped:     17,
+		Decode:      2500 * time.Microsecond,
+		Latency:     18 * time.Millisecond,
+		Width:       1280,
+		Height:      720,
+		Transit:     40 * time.Millisecond,
+		Publisher:   "10.0.0.2:51234",
+		Seq:         812,
+		Stream:      "cam",
+		ConnID:      3,
+		Format:      "vp8",
+		EncodedSize: 24 * 1024,
+	}
+	got := strings.Join(s.Lines(), "\n")
+	for _, want := range []string{"30.0 fps", "60.0 fps", "drop   17", "2.5 ms", "18.0 ms", "40.0 ms", "1280x720 vp8 24.0 KB",
+		"812 on cam", "10.0.0.2:51234 #3"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("HUD text lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	const w, h = 200, 120
+	pix := make([]byte, w*h*4)
+	Draw(pix, w, h, Stats{Publisher: "test"})
+
+	// The panel darkens the corner and the text lights some of it.
+	if pix[(6*w+6)*4+3] == 0 {
+		t.Error("panel was not drawn")
+	}
+	white := 0
+	for i := 0; i < len(pix); i += 4 {
+		if pix[i] == 0xff && pix[i+1] == 0xff && pix[i+2] == 0xff {
+			white++
+		}
+	}
+	if white == 0 {
+		t.Error("no text was drawn")
+	}
+	if a := pix[((h-1)*w+w-1)*4+3]; a != 0 {
+		t.Errorf("far corner alpha = %d, want untouched", a)
+	}
+}
+
+func TestDrawClipsToSmallFrames(t *testing.T) {
+	pix := make([]byte, 10*5*4)
+	Draw(pix, 10, 5, Stats{})
+	Draw(nil, 0, 0, Stats{})
+}
@@ -0,0 +1,248 @@
+// Package mask cuts frames to a shape by multiplying them with an alpha
+// mask, either a built-in anti-aliased shape or a grayscale image.
+package mask
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"sync"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/example/bidirect/internal/scale"
+	_ "golang.org/x/image/webp"
+)
+
+// Shape is a built-in procedural mask.
+type Shape int
+
+const (
+	None Shape = iota
+	Heart
+	Circle
+	RoundRect
+	Hexagon
+)
+
+var shapeNames = [...]string{None: "none", Heart: "heart", Circle: "circle", RoundRect: "roundrect", Hexagon: "hexagon"}
+
+func (s Shape) String() string {
+	if s >= 0 && int(s) < len(shapeNames) {
+		return shapeNames[s]
+	}
+	return fmt.Sprintf("Shape(%d)", int(s))
+}
+
+func (s Shape) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Shape) UnmarshalText(text []byte) error {
+	for i, name := range shapeNames {
+		if string(text) == name {
+			*s = Shape(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("mask: unknown shape %q (want none, heart, circle, roundrect or hexagon)", text)
+}
+
+// Mask is an alpha mask that scales to the size of each frame. The
+// coverage computed for the last frame size is cached, so a stream of
+// same-sized frames pays for it once. A Mask is safe for concurrent use.
+type Mask struct {
+	shape Shape
+	img   []byte // grayscale mask replicated into BGRA, when not a Shape
+	imgW  int
+	imgH  int
+
+	mu       sync.Mutex
+	w, h     int
+	coverage []byte
+}
+
+// New returns the mask for a built-in shape, or nil for None.
+func New(shape Shape) *Mask {
+	if shape == None {
+		return nil
+	}
+	return &Mask{shape: shape}
+}
+
+// FromImage returns a mask whose coverage is the luminance of img
+// multiplied by its alpha: white is opaque and black is transparent.
+func FromImage(img image.Image) *Mask {
+	b := img.Bounds()
+	m := &Mask{shape: None, imgW: b.Dx(), imgH: b.Dy(), img: make([]byte, b.Dx()*b.Dy()*4)}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			// The gray model works on premultiplied components, so
+			// transparent pixels come out black.
+			v := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			i := (y*b.Dx() + x) * 4
+			m.img[i+0], m.img[i+1], m.img[i+2], m.img[i+3] = v, v, v, v
+		}
+	}
+	return m
+}
+
+// Load reads a grayscale mask image from path.
+func Load(path string) (*Mask, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("mask: decoding %s: %w", path, err)
+	}
+	return FromImage(img), nil
+}
+
+// Parse returns the built-in shape named spec, or else loads spec as a
+// mask image.
+func Parse(spec string) (*Mask, error) {
+	var shape Shape
+	if shape.UnmarshalText([]byte(spec)) == nil {
+		return New(shape), nil
+	}
+	return Load(spec)
+}
+
+// Shape returns the mask's built-in shape, or None for an image mask.
+func (m *Mask) Shape() Shape {
+	if m == nil {
+		return None
+	}
+	return m.shape
+}
+
+func (m *Mask) String() string {
+	if m != nil && m.img != nil {
+		return fmt.Sprintf("image %dx%d", m.imgW, m.imgH)
+	}
+	return m.Shape().String()
+}
+
+// Apply multiplies pix, a premultiplied BGRA frame, by the mask scaled to
+// width x height. A nil mask leaves the frame untouched.
+func (m *Mask) Apply(pix []byte, width, height int) {
+	if m == nil || width <= 0 || height <= 0 || len(pix) < width*height*4 {
+		return
+	}
+	cov := m.Coverage(width, height)
+	for i, c := range cov {
+		switch c {
+		case 0xff:
+		case 0:
+			pix[i*4+0], pix[i*4+1], pix[i*4+2], pix[i*4+3] = 0, 0, 0, 0
+		default:
+			p := pix[i*4 : i*4+4 : i*4+4]
+			p[0] = mul8(p[0], c)
+			p[1] = mul8(p[1], c)
+			p[2] = mul8(p[2], c)
+			p[3] = mul8(p[3], c)
+		}
+	}
+}
+
+func mul8(a, b uint8) uint8 {
+	v := uint32(a)*uint32(b) + 128
+	return uint8((v + v>>8) >> 8)
+}
+
+// Coverage returns the mask's alpha, one byte per pixel, at width x
+// height. The slice is shared and must not be modified.
+func (m *Mask) Coverage(width, height int) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.coverage != nil && m.w == width && m.h == height {
+		return m.coverage
+	}
+
+	cov := make([]byte, width*height)
+	if m.img != nil {
+		sc := scale.Scaler{Mode: scale.Stretch, Filter: scale.Bilinear}
+		scaled := sc.Scale(m.img, m.imgW, m.imgH, width, height)
+		for i := range cov {
+			cov[i] = scaled[i*4+3]
+		}
+	} else {
+		m.rasterize(cov, width, height)
+	}
+	m.w, m.h, m.coverage = width, height, cov
+	return cov
+}
+
+// rasterize renders the shape's signed distance field with one pixel of
+// anti-aliasing. Shapes other than RoundRect keep their proportions and
+// are centered in the largest square that fits.
+func (m *Mask) rasterize(cov []byte, width, height int) {
+	side := float64(min(width, height))
+	cx, cy := float64(width)/2, float64(height)/2
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Pixel center relative to the frame center, in pixels.
+			px := float64(x) + 0.5 - cx
+			py := float64(y) + 0.5 - cy
+
+			var d float64
+			switch m.shape {
+			case Circle:
+				d = math.Hypot(px, py) - side/2
+			case RoundRect:
+				d = roundRect(px, py, float64(width)/2, float64(height)/2, side*0.15)
+			case Hexagon:
+				r := side / 2
+				d = hexagon(px/r, py/r) * r
+			case Heart:
+				// The unit heart spans x in ±0.604 and y from 0 to 1.104,
+				// tip down; fit its height to the square.
+				k := side / 1.21
+				d = heart(px/k, -py/k+0.552) * k
+			}
+			cov[y*width+x] = uint8(min(max(0.5-d, 0), 1)*255 + 0.5)
+		}
+	}
+}
+
+// Signed distance functions, negative inside, after Inigo Quilez.
+
+func roundRect(px, py, bx, by, r float64) float64 {
+	qx := math.Abs(px) - bx + r
+	qy := math.Abs(py) - by + r
+	return math.Hypot(max(qx, 0), max(qy, 0)) + min(max(qx, qy), 0) - r
+}
+
+// hexagon is a pointy-topped hexagon with circumradius 1.
+func hexagon(px, py float64) float64 {
+	const kx, ky, kz = -0.866025404, 0.5, 0.577350269
+	const r = 0.866025404 // inradius
+	// Swap axes so the vertices point up and down.
+	px, py = math.Abs(py), math.Abs(px)
+	dot := min(kx*px+ky*py, 0)
+	px -= 2 * dot * kx
+	py -= 2 * dot * ky
+	px -= min(max(px, -kz*r), kz*r)
+	py -= r
+	return math.Hypot(px, py) * math.Copysign(1, py)
+}
+
+func heart(px, py float64) float64 {
+	px = math.Abs(px)
+	if py+px > 1 {
+		return math.Hypot(px-0.25, py-0.75) - math.Sqrt2/4
+	}
+	a := (px)*(px) + (py-1)*(py-1)
+	t := 0.5 * max(px+py, 0)
+	b := (px-t)*(px-t) + (py-t)*(py-t)
+	return math.Sqrt(min(a, b)) * math.Copysign(1, px-py)
+}
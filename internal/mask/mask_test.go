@@ -0,0 +1,103 @@
+package mask
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestShapes(t *testing.T) {
+	const w, h = 120, 100
+	tests := []struct {
+		shape       Shape
+		opaque      [][2]int
+		transparent [][2]int
+	}{
+		{Circle, [][2]int{{60, 50}, {60, 5}, {15, 50}}, [][2]int{{0, 0}, {5, 50}, {20, 10}}},
+		{RoundRect, [][2]int{{60, 50}, {3, 50}, {60, 97}}, [][2]int{{0, 0}, {119, 99}}},
+		{Hexagon, [][2]int{{60, 50}, {60, 5}, {20, 50}}, [][2]int{{12, 50}, {15, 5}, {105, 95}}},
+		// Lobes on top, a notch between them and the tip at the bottom.
+		{Heart, [][2]int{{60, 50}, {40, 20}, {80, 20}, {60, 90}}, [][2]int{{60, 6}, {15, 90}, {105, 90}, {0, 0}}},
+	}
+	for _, tt := range tests {
+		cov := New(tt.shape).Coverage(w, h)
+		for _, p := range tt.opaque {
+			if c := cov[p[1]*w+p[0]]; c != 0xff {
+				t.Errorf("%v at %v = %d, want 255", tt.shape, p, c)
+			}
+		}
+		for _, p := range tt.transparent {
+			if c := cov[p[1]*w+p[0]]; c != 0 {
+				t.Errorf("%v at %v = %d, want 0", tt.shape, p, c)
+			}
+		}
+	}
+}
+
+func TestShapeEdgesAreAntialiased(t *testing.T) {
+	cov := New(Circle).Coverage(101, 101)
+	partial := 0
+	for _, c := range cov {
+		if c != 0 && c != 0xff {
+			partial++
+		}
+	}
+	// About one partial pixel per pixel of circumference.
+	if partial < 200 || partial > 450 {
+		t.Errorf("%d partially covered pixels, want about 315", partial)
+	}
+}
+
+func TestApplyPremultiplies(t *testing.T) {
+	m := FromImage(&image.Gray{Pix: []byte{0, 128, 255}, Stride: 3, Rect: image.Rect(0, 0, 3, 1)})
+	pix := []byte{
+		200, 100, 50, 255,
+		200, 100, 50, 255,
+		200, 100, 50, 255,
+	}
+	m.Apply(pix, 3, 1)
+
+	want := []byte{
+		0, 0, 0, 0,
+		100, 50, 25, 128,
+		200, 100, 50, 255,
+	}
+	if string(pix) != string(want) {
+		t.Errorf("Apply = %v, want %v", pix, want)
+	}
+}
+
+func TestImageMaskScales(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{A: 255})
+	img.Set(1, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+
+	cov := FromImage(img).Coverage(16, 2)
+	for y := 0; y < 2; y++ {
+		if l, r := cov[y*16+1], cov[y*16+14]; l != 0 || r != 0xff {
+			t.Errorf("row %d: left %d, right %d; want 0 and 255", y, l, r)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse("hexagon")
+	if err != nil || m.Shape() != Hexagon {
+		t.Errorf("Parse(hexagon) = %v, %v", m, err)
+	}
+	if m, err := Parse("none"); err != nil || m != nil {
+		t.Errorf("Parse(none) = %v, %v; want nil mask", m, err)
+	}
+	if _, err := Parse("no-such-mask.png"); err == nil {
+		t.Error("Parse of a missing file succeeded")
+	}
+}
+
+func TestNilMask(t *testing.T) {
+	var m *Mask
+	pix := []byte{1, 2, 3, 4}
+	m.Apply(pix, 1, 1)
+	if pix[3] != 4 || m.String() != "none" {
+		t.Errorf("nil mask changed the frame or is named %q", m.String())
+	}
+}
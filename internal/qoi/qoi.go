@@ -0,0 +1,128 @@
+// Package qoi decodes images in the Quite OK Image format
+// (https://qoiformat.org/qoi-specification.pdf).
+package qoi
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Magic starts every QOI image.
+const Magic = "qoif"
+
+const headerSize = 14
+
+const (
+	opIndex = 0x00
+	opDiff  = 0x40
+	opLuma  = 0x80
+	opRun   = 0xc0
+	opRGB   = 0xfe
+	opRGBA  = 0xff
+	opMask  = 0xc0
+)
+
+// maxRun is the most pixels a single byte of the stream can produce, which
+// bounds the size a truncated or hostile stream can claim.
+const maxRun = 62
+
+var errTruncated = errors.New("qoi: truncated image")
+
+func parseHeader(b []byte) (image.Config, error) {
+	if len(b) < headerSize || string(b[:4]) != Magic {
+		return image.Config{}, errors.New("qoi: invalid header")
+	}
+	width := binary.BigEndian.Uint32(b[4:])
+	height := binary.BigEndian.Uint32(b[8:])
+	if channels := b[12]; channels != 3 && channels != 4 {
+		return image.Config{}, errors.New("qoi: invalid channel count")
+	}
+	if width == 0 || height == 0 || width > 1<<24 || height > 1<<24 {
+		return image.Config{}, errors.New("qoi: invalid dimensions")
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: int(width), Height: int(height)}, nil
+}
+
+// DecodeConfig returns the dimensions of a QOI image without decoding it.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return image.Config{}, errTruncated
+	}
+	return parseHeader(header[:])
+}
+
+// Decode reads a QOI image as an *image.NRGBA.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	data = data[headerSize:]
+	pixels := cfg.Width * cfg.Height
+	if pixels > len(data)*maxRun {
+		return nil, errTruncated
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	pos, run := 0, 0
+	for out := 0; out < len(img.Pix); out += 4 {
+		if run > 0 {
+			run--
+		} else {
+			if pos >= len(data) {
+				return nil, errTruncated
+			}
+			b := data[pos]
+			pos++
+			switch {
+			case b == opRGB:
+				if pos+3 > len(data) {
+					return nil, errTruncated
+				}
+				copy(px[:3], data[pos:pos+3])
+				pos += 3
+			case b == opRGBA:
+				if pos+4 > len(data) {
+					return nil, errTruncated
+				}
+				copy(px[:], data[pos:pos+4])
+				pos += 4
+			case b&opMask == opIndex:
+				px = index[b]
+			case b&opMask == opDiff:
+				px[0] += (b>>4)&3 - 2
+				px[1] += (b>>2)&3 - 2
+				px[2] += b&3 - 2
+			case b&opMask == opLuma:
+				if pos >= len(data) {
+					return nil, errTruncated
+				}
+				dg := b&0x3f - 32
+				b2 := data[pos]
+				pos++
+				px[0] += dg + b2>>4 - 8
+				px[1] += dg
+				px[2] += dg + b2&0x0f - 8
+			default: // opRun
+				run = int(b & 0x3f)
+			}
+		}
+		index[hash(px)] = px
+		copy(img.Pix[out:out+4], px[:])
+	}
+	return img, nil
+}
+
+func hash(px [4]byte) byte {
+	return (px[0]*3 + px[1]*5 + px[2]*7 + px[3]*11) % 64
+}
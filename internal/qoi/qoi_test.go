@@ -0,0 +1,63 @@
+package qoi
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func qoiImage(width, height byte, ops ...byte) []byte {
+	b := []byte{'q', 'o', 'i', 'f', 0, 0, 0, width, 0, 0, 0, height, 4, 0}
+	b = append(b, ops...)
+	return append(b, 0, 0, 0, 0, 0, 0, 0, 1)
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+		want []byte
+	}{
+		{
+			"ops", qoiImage(2, 2,
+				opRGBA, 10, 20, 30, 255,
+				0x79,       // diff +1, 0, -1
+				0xa2, 0x97, // luma dg +2, dr-dg +1, db-dg -1
+				0x09, // index of the first pixel
+			), 2, 2,
+			[]byte{10, 20, 30, 255, 11, 20, 29, 255, 14, 22, 30, 255, 10, 20, 30, 255},
+		},
+		{
+			"run", qoiImage(3, 1, opRGB, 1, 2, 3, opRun|1), 3, 1,
+			[]byte{1, 2, 3, 255, 1, 2, 3, 255, 1, 2, 3, 255},
+		},
+	}
+	for _, tt := range tests {
+		cfg, err := DecodeConfig(bytes.NewReader(tt.data))
+		if err != nil || cfg.Width != tt.w || cfg.Height != tt.h {
+			t.Errorf("%s: DecodeConfig = %+v, %v; want %dx%d", tt.name, cfg, err, tt.w, tt.h)
+		}
+		img, err := Decode(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := img.(*image.NRGBA).Pix; !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: pixels = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeRejectsBadInput(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"magic":    []byte("qoix\x00\x00\x00\x01\x00\x00\x00\x01\x04\x00"),
+		"channels": []byte("qoif\x00\x00\x00\x01\x00\x00\x00\x01\x05\x00"),
+		"short":    qoiImage(4, 4, opRGB, 1, 2),
+		// A header claiming far more pixels than the stream could hold.
+		"oversized": append([]byte("qoif\x00\x10\x00\x00\x00\x10\x00\x00\x04\x00"), opRun|61),
+	} {
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package scale
+
+import "math"
+
+// weightBits is the fixed-point precision of filter weights.
+const weightBits = 14
+
+// Scaler resamples premultiplied BGRA frames with a Mode and Filter. It
+// keeps the filter weights and buffers of the last geometry it scaled, so
+// a stream of same-sized frames does not allocate. A Scaler is not safe
+// for concurrent use.
+type Scaler struct {
+	Mode   Mode
+	Filter Filter
+
+	key    geometry
+	dst    [4]int // destination rectangle: x0, y0, x1, y1
+	xw, yw weights
+	tmp    []int32
+	out    []byte
+}
+
+type geometry struct {
+	mode           Mode
+	filter         Filter
+	sw, sh, dw, dh int
+}
+
+// weights maps every destination pixel along one axis to a fixed number
+// of source taps.
+type weights struct {
+	taps   int
+	idx    []int32
+	w      []int32
+	lo, hi int32 // range of source indices referenced
+}
+
+// Scale resamples the sw x sh frame src into a dw x dh frame and returns
+// it. Pixels outside the frame's placement are transparent. The result is
+// owned by the Scaler and valid until the next call.
+func (s *Scaler) Scale(src []byte, sw, sh, dw, dh int) []byte {
+	if sw <= 0 || sh <= 0 || dw <= 0 || dh <= 0 || len(src) < sw*sh*4 {
+		return nil
+	}
+	if key := (geometry{s.Mode, s.Filter, sw, sh, dw, dh}); key != s.key || s.out == nil {
+		s.prepare(key)
+	}
+
+	out := s.out
+	clear(out)
+	dx0, dy0, dx1, dy1 := s.dst[0], s.dst[1], s.dst[2], s.dst[3]
+	rw := dx1 - dx0
+	if rw <= 0 || dy1 <= dy0 {
+		return out
+	}
+
+	// Horizontal pass: every source row the vertical pass reads, resampled
+	// to the destination width.
+	xw := &s.xw
+	for y := s.yw.lo; y <= s.yw.hi; y++ {
+		row := src[int(y)*sw*4 : (int(y)+1)*sw*4]
+		t := s.tmp[int(y-s.yw.lo)*rw*4:]
+		for x := 0; x < rw; x++ {
+			var b, g, r, a int32
+			taps := xw.idx[x*xw.taps : (x+1)*xw.taps]
+			ws := xw.w[x*xw.taps : (x+1)*xw.taps]
+			for k, i := range taps {
+				p := row[int(i)*4 : int(i)*4+4 : int(i)*4+4]
+				w := ws[k]
+				b += w * int32(p[0])
+				g += w * int32(p[1])
+				r += w * int32(p[2])
+				a += w * int32(p[3])
+			}
+			t[x*4+0], t[x*4+1], t[x*4+2], t[x*4+3] = b, g, r, a
+		}
+	}
+
+	// Vertical pass into the destination rectangle.
+	yw := &s.yw
+	for y := 0; y < dy1-dy0; y++ {
+		taps := yw.idx[y*yw.taps : (y+1)*yw.taps]
+		ws := yw.w[y*yw.taps : (y+1)*yw.taps]
+		d := out[((dy0+y)*dw+dx0)*4 : ((dy0+y)*dw+dx1)*4]
+		for x := 0; x < rw; x++ {
+			var b, g, r, a int64
+			for k, i := range taps {
+				t := s.tmp[(int(i-yw.lo)*rw+x)*4:]
+				w := int64(ws[k])
+				b += w * int64(t[0])
+				g += w * int64(t[1])
+				r += w * int64(t[2])
+				a += w * int64(t[3])
+			}
+			// Negative lobes can overshoot; keep the result a valid
+			// premultiplied color.
+			av := clamp8(a)
+			d[x*4+0] = min(clamp8(b), av)
+			d[x*4+1] = min(clamp8(g), av)
+			d[x*4+2] = min(clamp8(r), av)
+			d[x*4+3] = av
+		}
+	}
+	return out
+}
+
+// clamp8 rounds a value carrying two rounds of weights to a byte.
+func clamp8(v int64) uint8 {
+	v = (v + 1<<(2*weightBits-1)) >> (2 * weightBits)
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+func (s *Scaler) prepare(key geometry) {
+	s.key = key
+	src, dst := Layout(key.mode, key.sw, key.sh, key.dw, key.dh)
+	s.dst = [4]int{dst.Min.X, dst.Min.Y, dst.Max.X, dst.Max.Y}
+
+	s.xw = makeWeights(key.filter, src.Min.X, src.Dx(), key.sw, dst.Dx())
+	s.yw = makeWeights(key.filter, src.Min.Y, src.Dy(), key.sh, dst.Dy())
+
+	s.out = grow(s.out, key.dw*key.dh*4)
+	rows := int(s.yw.hi-s.yw.lo) + 1
+	if n := rows * dst.Dx() * 4; cap(s.tmp) < n {
+		s.tmp = make([]int32, n)
+	} else {
+		s.tmp = s.tmp[:n]
+	}
+}
+
+func grow(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+// makeWeights computes the taps that resample srcLen pixels starting at
+// srcOff, out of a full axis of fullLen, into dstLen pixels. Taps that
+// fall outside the axis are clamped to its edge pixels.
+func makeWeights(f Filter, srcOff, srcLen, fullLen, dstLen int) weights {
+	if dstLen <= 0 {
+		return weights{}
+	}
+	ratio := float64(srcLen) / float64(dstLen)
+	// When shrinking, widen the kernel so every source pixel contributes.
+	scale := max(ratio, 1)
+	radius := f.support() * scale
+	taps := 2 * int(math.Ceil(radius))
+
+	wt := weights{
+		taps: taps,
+		idx:  make([]int32, dstLen*taps),
+		w:    make([]int32, dstLen*taps),
+		lo:   int32(fullLen - 1),
+	}
+	fw := make([]float64, taps)
+	for i := 0; i < dstLen; i++ {
+		center := float64(srcOff) + (float64(i)+0.5)*ratio
+		start := int(math.Floor(center - radius + 0.5))
+
+		sum := 0.0
+		for k := range fw {
+			fw[k] = f.at((float64(start+k) + 0.5 - center) / scale)
+			sum += fw[k]
+		}
+
+		idx := wt.idx[i*taps : (i+1)*taps]
+		w := wt.w[i*taps : (i+1)*taps]
+		total, peak := int32(0), 0
+		for k := range fw {
+			j := min(max(start+k, 0), fullLen-1)
+			idx[k] = int32(j)
+			w[k] = int32(math.Round(fw[k] / sum * (1 << weightBits)))
+			total += w[k]
+			if w[k] > w[peak] {
+				peak = k
+			}
+			wt.lo = min(wt.lo, int32(j))
+			wt.hi = max(wt.hi, int32(j))
+		}
+		// Put the rounding error on the largest tap so flat areas stay
+		// exactly flat.
+		w[peak] += 1<<weightBits - total
+	}
+	return wt
+}
@@ -0,0 +1,147 @@
+// Package scale resamples premultiplied BGRA frames into a target size.
+package scale
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Mode selects how a frame is placed in a target of a different size.
+type Mode int
+
+const (
+	// Fit scales the frame to fit inside the target, keeping its aspect
+	// ratio and leaving transparent bars.
+	Fit Mode = iota
+	// Fill scales the frame to cover the target, keeping its aspect ratio
+	// and cropping what overflows.
+	Fill
+	// Stretch scales the frame to the target size exactly.
+	Stretch
+	// Center shows the frame unscaled in the middle of the target.
+	Center
+)
+
+var modeNames = [...]string{Fit: "fit", Fill: "fill", Stretch: "stretch", Center: "center"}
+
+func (m Mode) String() string {
+	if m >= 0 && int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Mode) UnmarshalText(text []byte) error {
+	for i, name := range modeNames {
+		if string(text) == name {
+			*m = Mode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("scale: unknown mode %q (want fit, fill, stretch or center)", text)
+}
+
+// Filter selects the resampling kernel.
+type Filter int
+
+const (
+	// Bilinear is a triangle filter: cheap and free of ringing.
+	Bilinear Filter = iota
+	// Lanczos is a 3-lobe Lanczos filter: sharper, at about twice the cost.
+	Lanczos
+)
+
+var filterNames = [...]string{Bilinear: "bilinear", Lanczos: "lanczos"}
+
+func (f Filter) String() string {
+	if f >= 0 && int(f) < len(filterNames) {
+		return filterNames[f]
+	}
+	return fmt.Sprintf("Filter(%d)", int(f))
+}
+
+func (f Filter) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *Filter) UnmarshalText(text []byte) error {
+	for i, name := range filterNames {
+		if string(text) == name {
+			*f = Filter(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("scale: unknown filter %q (want bilinear or lanczos)", text)
+}
+
+// support is the kernel radius in source pixels at a scale of 1.
+func (f Filter) support() float64 {
+	if f == Lanczos {
+		return 3
+	}
+	return 1
+}
+
+func (f Filter) at(x float64) float64 {
+	x = math.Abs(x)
+	if f == Lanczos {
+		if x >= 3 {
+			return 0
+		}
+		if x < 1e-9 {
+			return 1
+		}
+		px := math.Pi * x
+		return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+	}
+	if x >= 1 {
+		return 0
+	}
+	return 1 - x
+}
+
+// Layout returns the part of a sw x sh frame that is shown and where it
+// goes in a dw x dh target.
+func Layout(mode Mode, sw, sh, dw, dh int) (src, dst image.Rectangle) {
+	src = image.Rect(0, 0, sw, sh)
+	dst = image.Rect(0, 0, dw, dh)
+	if sw <= 0 || sh <= 0 || dw <= 0 || dh <= 0 {
+		return image.Rectangle{}, image.Rectangle{}
+	}
+
+	switch mode {
+	case Fit:
+		// Compare sw/sh with dw/dh without rounding.
+		if sw*dh > dw*sh {
+			h := max(1, (sh*dw+sw/2)/sw)
+			dst = centered(dw, h, dw, dh)
+		} else {
+			w := max(1, (sw*dh+sh/2)/sh)
+			dst = centered(w, dh, dw, dh)
+		}
+	case Fill:
+		if sw*dh > dw*sh {
+			w := max(1, (dw*sh+dh/2)/dh)
+			src = centered(w, sh, sw, sh)
+		} else {
+			h := max(1, (dh*sw+dw/2)/dw)
+			src = centered(sw, h, sw, sh)
+		}
+	case Center:
+		w, h := min(sw, dw), min(sh, dh)
+		src = centered(w, h, sw, sh)
+		dst = centered(w, h, dw, dh)
+	}
+	return src, dst
+}
+
+// centered returns a w x h rectangle in the middle of a bw x bh one.
+func centered(w, h, bw, bh int) image.Rectangle {
+	x, y := (bw-w)/2, (bh-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
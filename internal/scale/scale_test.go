@@ -0,0 +1,184 @@
+package scale
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func solid(w, h int, b, g, r, a byte) []byte {
+	px := make([]byte, w*h*4)
+	for i := 0; i < len(px); i += 4 {
+		px[i+0], px[i+1], px[i+2], px[i+3] = b, g, r, a
+	}
+	return px
+}
+
+func TestLayout(t *testing.T) {
+	tests := []struct {
+		mode     Mode
+		sw, sh   int
+		dw, dh   int
+		src, dst image.Rectangle
+	}{
+		{Stretch, 100, 50, 40, 40, image.Rect(0, 0, 100, 50), image.Rect(0, 0, 40, 40)},
+		{Fit, 100, 50, 40, 40, image.Rect(0, 0, 100, 50), image.Rect(0, 10, 40, 30)},
+		{Fit, 50, 100, 40, 40, image.Rect(0, 0, 50, 100), image.Rect(10, 0, 30, 40)},
+		{Fit, 20, 20, 40, 40, image.Rect(0, 0, 20, 20), image.Rect(0, 0, 40, 40)},
+		{Fill, 100, 50, 40, 40, image.Rect(25, 0, 75, 50), image.Rect(0, 0, 40, 40)},
+		{Fill, 50, 100, 40, 40, image.Rect(0, 25, 50, 75), image.Rect(0, 0, 40, 40)},
+		{Center, 100, 50, 40, 40, image.Rect(30, 5, 70, 45), image.Rect(0, 0, 40, 40)},
+		{Center, 10, 20, 40, 40, image.Rect(0, 0, 10, 20), image.Rect(15, 10, 25, 30)},
+		{Fit, 1000, 1, 10, 10, image.Rect(0, 0, 1000, 1), image.Rect(0, 4, 10, 5)},
+	}
+	for _, tt := range tests {
+		src, dst := Layout(tt.mode, tt.sw, tt.sh, tt.dw, tt.dh)
+		if src != tt.src || dst != tt.dst {
+			t.Errorf("Layout(%v, %dx%d, %dx%d) = %v, %v; want %v, %v",
+				tt.mode, tt.sw, tt.sh, tt.dw, tt.dh, src, dst, tt.src, tt.dst)
+		}
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	for _, m := range []Mode{Fit, Fill, Stretch, Center} {
+		var got Mode
+		if err := got.UnmarshalText([]byte(m.String())); err != nil || got != m {
+			t.Errorf("Mode round trip of %v = %v, %v", m, got, err)
+		}
+	}
+	for _, f := range []Filter{Bilinear, Lanczos} {
+		var got Filter
+		if err := got.UnmarshalText([]byte(f.String())); err != nil || got != f {
+			t.Errorf("Filter round trip of %v = %v, %v", f, got, err)
+		}
+	}
+	var m Mode
+	if err := m.UnmarshalText([]byte("zoom")); err == nil {
+		t.Error("UnmarshalText(zoom) succeeded")
+	}
+}
+
+func TestScaleIdentity(t *testing.T) {
+	src := make([]byte, 13*7*4)
+	rand.New(rand.NewSource(1)).Read(src)
+	for i := 0; i < len(src); i += 4 {
+		src[i+0] = min(src[i+0], src[i+3])
+		src[i+1] = min(src[i+1], src[i+3])
+		src[i+2] = min(src[i+2], src[i+3])
+	}
+
+	for _, f := range []Filter{Bilinear, Lanczos} {
+		for _, m := range []Mode{Fit, Fill, Stretch, Center} {
+			s := Scaler{Mode: m, Filter: f}
+			got := s.Scale(src, 13, 7, 13, 7)
+			for i := range src {
+				if got[i] != src[i] {
+					t.Fatalf("%v/%v: byte %d = %d, want %d", m, f, i, got[i], src[i])
+				}
+			}
+		}
+	}
+}
+
+func TestScaleSolidStaysSolid(t *testing.T) {
+	src := solid(37, 23, 10, 20, 30, 200)
+	for _, f := range []Filter{Bilinear, Lanczos} {
+		for _, size := range [][2]int{{100, 80}, {9, 5}, {37, 60}} {
+			s := Scaler{Mode: Stretch, Filter: f}
+			got := s.Scale(src, 37, 23, size[0], size[1])
+			for i := 0; i < len(got); i += 4 {
+				if got[i] != 10 || got[i+1] != 20 || got[i+2] != 30 || got[i+3] != 200 {
+					t.Fatalf("%v to %v: pixel %d = %v", f, size, i/4, got[i:i+4])
+				}
+			}
+		}
+	}
+}
+
+func TestScaleFitLetterbox(t *testing.T) {
+	s := Scaler{Mode: Fit}
+	got := s.Scale(solid(100, 50, 255, 255, 255, 255), 100, 50, 40, 40)
+
+	for y := 0; y < 40; y++ {
+		a := got[(y*40+20)*4+3]
+		inside := y >= 10 && y < 30
+		if inside && a != 255 || !inside && a != 0 {
+			t.Errorf("alpha at row %d = %d (inside = %v)", y, a, inside)
+		}
+	}
+}
+
+func TestScaleDownAverages(t *testing.T) {
+	// A one-pixel checkerboard halved should come out flat mid-gray.
+	const w, h = 64, 64
+	src := make([]byte, w*h*4)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := byte(0)
+			if (x+y)%2 == 0 {
+				v = 255
+			}
+			i := (y*w + x) * 4
+			src[i+0], src[i+1], src[i+2], src[i+3] = v, v, v, 255
+		}
+	}
+
+	s := Scaler{Mode: Stretch, Filter: Bilinear}
+	got := s.Scale(src, w, h, w/2, h/2)
+	for y := 2; y < h/2-2; y++ {
+		for x := 2; x < w/2-2; x++ {
+			if v := got[(y*w/2+x)*4]; v < 120 || v > 135 {
+				t.Fatalf("pixel (%d, %d) = %d, want about 128", x, y, v)
+			}
+		}
+	}
+}
+
+func TestScaleLanczosStaysPremultiplied(t *testing.T) {
+	// Hard alpha edges make Lanczos ring; colors must never exceed alpha.
+	src := make([]byte, 32*32*4)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if (x/4+y/4)%2 == 0 {
+				i := (y*32 + x) * 4
+				src[i+0], src[i+1], src[i+2], src[i+3] = 255, 255, 255, 255
+			}
+		}
+	}
+
+	s := Scaler{Mode: Stretch, Filter: Lanczos}
+	for _, size := range []int{13, 77} {
+		got := s.Scale(src, 32, 32, size, size)
+		for i := 0; i < len(got); i += 4 {
+			if got[i] > got[i+3] || got[i+1] > got[i+3] || got[i+2] > got[i+3] {
+				t.Fatalf("size %d: pixel %d = %v is not premultiplied", size, i/4, got[i:i+4])
+			}
+		}
+	}
+}
+
+func TestScaleReusesBuffers(t *testing.T) {
+	src := solid(64, 48, 1, 2, 3, 255)
+	s := Scaler{Filter: Lanczos}
+	s.Scale(src, 64, 48, 100, 100)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		s.Scale(src, 64, 48, 100, 100)
+	})
+	if allocs != 0 {
+		t.Errorf("Scale allocated %v times per call, want 0", allocs)
+	}
+}
+
+func BenchmarkScale(b *testing.B) {
+	src := solid(1280, 720, 1, 2, 3, 255)
+	for _, f := range []Filter{Bilinear, Lanczos} {
+		b.Run(f.String(), func(b *testing.B) {
+			s := Scaler{Filter: f}
+			for i := 0; i < b.N; i++ {
+				s.Scale(src, 1280, 720, 400, 400)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Package transition blends between two premultiplied BGRA frames when
+// the picture changes. Every effect only scales and sums premultiplied
+// values, so shaped and translucent content fades without dark fringes.
+package transition
+
+import (
+	"fmt"
+	"time"
+)
+
+// Effect selects how a new frame replaces the one on screen.
+type Effect int
+
+const (
+	// None replaces the frame at once.
+	None Effect = iota
+	// Crossfade fades the old frame out while the new one fades in.
+	Crossfade
+	// Slide pushes the old frame out to the left with the new one.
+	Slide
+	// Wipe reveals the new frame from left to right behind a soft edge.
+	Wipe
+	// Zoom grows the new frame from the center while fading it in.
+	Zoom
+)
+
+var effectNames = [...]string{None: "none", Crossfade: "crossfade", Slide: "slide", Wipe: "wipe", Zoom: "zoom"}
+
+// Valid reports whether e is one of the effects above.
+func (e Effect) Valid() bool {
+	return e >= 0 && int(e) < len(effectNames)
+}
+
+func (e Effect) String() string {
+	if e.Valid() {
+		return effectNames[e]
+	}
+	return fmt.Sprintf("Effect(%d)", int(e))
+}
+
+func (e Effect) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+func (e *Effect) UnmarshalText(text []byte) error {
+	for i, name := range effectNames {
+		if string(text) == name {
+			*e = Effect(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("transition: unknown effect %q (want none, crossfade, slide, wipe or zoom)", text)
+}
+
+// MaxDuration is the longest a transition may take. Longer requests are
+// refused so that a sender cannot keep the receiver blending for good.
+const MaxDuration = 10 * time.Second
+
+// CheckDuration returns an error unless d is between 0 and MaxDuration.
+func CheckDuration(d time.Duration) error {
+	if d < 0 || d > MaxDuration {
+		return fmt.Errorf("transition: duration %v is not between 0 and %v", d, MaxDuration)
+	}
+	return nil
+}
+
+// Spec is a transition effect and how long it takes.
+type Spec struct {
+	Effect   Effect
+	Duration time.Duration
+}
+
+// Enabled reports whether s changes frames other than at once.
+func (s Spec) Enabled() bool {
+	return s.Effect != None && s.Duration > 0
+}
+
+func (s Spec) String() string {
+	if !s.Enabled() {
+		return None.String()
+	}
+	return fmt.Sprintf("%v over %v", s.Effect, s.Duration)
+}
+
+// Blend writes into dst the picture t of the way, from 0 to 1, through the
+// transition from one frame to the next. dst, from and to are premultiplied
+// BGRA frames of width x height; dst must not share memory with either of
+// the others.
+func Blend(dst, from, to []byte, width, height int, effect Effect, t float64) {
+	n := width * height * 4
+	if width <= 0 || height <= 0 || len(dst) < n || len(from) < n || len(to) < n {
+		return
+	}
+	t = min(max(t, 0), 1)
+	// Easing in and out keeps the start and end of motion gentle.
+	t = t * t * (3 - 2*t)
+
+	switch effect {
+	case Crossfade:
+		mix(dst[:n], from[:n], to[:n], weight(t))
+	case Slide:
+		slide(dst, from, to, width, height, t)
+	case Wipe:
+		wipe(dst, from, to, width, height, t)
+	case Zoom:
+		zoom(dst, from, to, width, height, t)
+	default:
+		copy(dst[:n], to[:n])
+	}
+}
+
+// weight converts t into a fixed-point weight out of 256.
+func weight(t float64) uint32 {
+	return uint32(t*256 + 0.5)
+}
+
+// mix writes a*(1-w) + b*w into dst, with w out of 256.
+func mix(dst, a, b []byte, w uint32) {
+	for i := range dst {
+		dst[i] = byte((uint32(a[i])*(256-w) + uint32(b[i])*w + 128) >> 8)
+	}
+}
+
+func slide(dst, from, to []byte, width, height int, t float64) {
+	offset := int(t*float64(width) + 0.5)
+	stride := width * 4
+	split := (width - offset) * 4
+	for y := 0; y < height; y++ {
+		row := y * stride
+		copy(dst[row:row+split], from[row+offset*4:row+stride])
+		copy(dst[row+split:row+stride], to[row:row+offset*4])
+	}
+}
+
+func wipe(dst, from, to []byte, width, height int, t float64) {
+	// The edge sweeps from just left of the frame to just past its right
+	// side, so both ends show one frame only.
+	edge := max(width/16, 1)
+	pos := t * float64(width+edge)
+	stride := width * 4
+	for x := 0; x < width; x++ {
+		w := weight(min(max((pos-float64(x))/float64(edge), 0), 1))
+		for y := 0; y < height; y++ {
+			i := y*stride + x*4
+			mix(dst[i:i+4], from[i:i+4], to[i:i+4], w)
+		}
+	}
+}
+
+func zoom(dst, from, to []byte, width, height int, t float64) {
+	// The new frame is sampled nearest-neighbor at scale t about the
+	// center, transparent outside, and faded in over the old one.
+	w := weight(t)
+	cx, cy := float64(width)/2, float64(height)/2
+	var clear [4]byte
+	for y := 0; y < height; y++ {
+		sy := -1
+		if t > 0 {
+			sy = int(cy + (float64(y)+0.5-cy)/t)
+		}
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * 4
+			src := clear[:]
+			if sy >= 0 && sy < height {
+				if sx := int(cx + (float64(x)+0.5-cx)/t); sx >= 0 && sx < width {
+					j := (sy*width + sx) * 4
+					src = to[j : j+4]
+				}
+			}
+			mix(dst[i:i+4], from[i:i+4], src, w)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package transition
+
+import (
+	"bytes"
+	"testing"
+)
+
+// solid returns a width x height frame with every pixel set to bgra.
+func solid(width, height int, bgra [4]byte) []byte {
+	pix := make([]byte, width*height*4)
+	for i := 0; i < len(pix); i += 4 {
+		copy(pix[i:], bgra[:])
+	}
+	return pix
+}
+
+func pixel(pix []byte, width, x, y int) [4]byte {
+	i := (y*width + x) * 4
+	return [4]byte(pix[i : i+4])
+}
+
+func TestBlendEnds(t *testing.T) {
+	const w, h = 16, 8
+	from := solid(w, h, [4]byte{0, 0, 255, 255})
+	to := solid(w, h, [4]byte{255, 0, 0, 255})
+	dst := make([]byte, w*h*4)
+	for _, effect := range []Effect{None, Crossfade, Slide, Wipe, Zoom} {
+		Blend(dst, from, to, w, h, effect, 1)
+		if !bytes.Equal(dst, to) {
+			t.Errorf("%v at t=1 is not the new frame", effect)
+		}
+		if effect == None {
+			continue
+		}
+		Blend(dst, from, to, w, h, effect, 0)
+		if !bytes.Equal(dst, from) {
+			t.Errorf("%v at t=0 is not the old frame", effect)
+		}
+	}
+}
+
+func TestCrossfadePremultiplied(t *testing.T) {
+	// Fading an opaque red frame to a fully transparent one must stay
+	// premultiplied: color never exceeds alpha, so no dark or bright
+	// fringe appears when the result is composited.
+	from := solid(1, 1, [4]byte{0, 0, 200, 200})
+	to := solid(1, 1, [4]byte{0, 0, 0, 0})
+	dst := make([]byte, 4)
+	for _, tt := range []float64{0.1, 0.25, 0.5, 0.75, 0.9} {
+		Blend(dst, from, to, 1, 1, Crossfade, tt)
+		if dst[2] > dst[3] {
+			t.Errorf("t=%.2f: red %d exceeds alpha %d", tt, dst[2], dst[3])
+		}
+		if dst[2] != dst[3] {
+			t.Errorf("t=%.2f: red %d and alpha %d diverged", tt, dst[2], dst[3])
+		}
+	}
+	Blend(dst, from, to, 1, 1, Crossfade, 0.5)
+	if dst[3] != 100 {
+		t.Errorf("halfway alpha = %d, want 100", dst[3])
+	}
+}
+
+func TestSlideAndWipeHalfway(t *testing.T) {
+	const w, h = 16, 2
+	oldPix, newPix := [4]byte{1, 1, 1, 255}, [4]byte{2, 2, 2, 255}
+	from, to := solid(w, h, oldPix), solid(w, h, newPix)
+	dst := make([]byte, w*h*4)
+
+	Blend(dst, from, to, w, h, Slide, 0.5)
+	if pixel(dst, w, 0, 1) != oldPix || pixel(dst, w, w-1, 1) != newPix {
+		t.Errorf("slide halfway: left %v, right %v", pixel(dst, w, 0, 1), pixel(dst, w, w-1, 1))
+	}
+
+	Blend(dst, from, to, w, h, Wipe, 0.5)
+	if pixel(dst, w, 0, 0) != newPix || pixel(dst, w, w-1, 0) != oldPix {
+		t.Errorf("wipe halfway: left %v, right %v", pixel(dst, w, 0, 0), pixel(dst, w, w-1, 0))
+	}
+}
+
+func TestZoomGrowsFromCenter(t *testing.T) {
+	const w, h = 20, 20
+	from := solid(w, h, [4]byte{})
+	to := solid(w, h, [4]byte{255, 255, 255, 255})
+	dst := make([]byte, w*h*4)
+	Blend(dst, from, to, w, h, Zoom, 0.5)
+	if c := pixel(dst, w, w/2, h/2); c[3] == 0 {
+		t.Error("center is still transparent halfway through")
+	}
+	if c := pixel(dst, w, 0, 0); c[3] != 0 {
+		t.Errorf("corner = %v halfway through, want transparent", c)
+	}
+}
+
+func TestEffectText(t *testing.T) {
+	var e Effect
+	if err := e.UnmarshalText([]byte("wipe")); err != nil || e != Wipe {
+		t.Errorf("UnmarshalText(wipe) = %v, %v", e, err)
+	}
+	if err := e.UnmarshalText([]byte("dissolve")); err == nil {
+		t.Error("UnmarshalText accepted an unknown effect")
+	}
+}
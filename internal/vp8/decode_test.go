@@ -0,0 +1,106 @@
+package vp8
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/example/bidirect/internal/webm"
+)
+
+// decodeNext decodes frame as the next frame of d's stream.
+func decodeNext(d *Decoder, frame []byte) (*image.YCbCr, error) {
+	d.Init(bytes.NewReader(frame), len(frame))
+	if _, err := d.DecodeFrameHeader(); err != nil {
+		return nil, err
+	}
+	return d.DecodeFrame()
+}
+
+// psnr returns the peak signal-to-noise ratio in decibels between two
+// w×h planes.
+func psnr(a, b []uint8, strideA, strideB, w, h int) float64 {
+	var sum float64
+	for y := 0; y < h; y++ {
+		ra, rb := a[y*strideA:][:w], b[y*strideB:][:w]
+		for x := range ra {
+			d := float64(ra[x]) - float64(rb[x])
+			sum += d * d
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255*float64(w*h)/sum)
+}
+
+func TestDecodeInterframes(t *testing.T) {
+	f, err := os.Open("../../video.webm")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+	r, err := webm.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Frame 128 of the bundled video is a keyframe in the middle of a
+	// continuous shot, so the interframes decoded since frame 0 must have
+	// built up nearly the same picture. Misparsed modes or motion vectors
+	// and wrong luma filters add up from frame to frame and leave the last
+	// interframe far from it; off-by-one rounding does not, as each frame's
+	// residuals hide most of it.
+	const next = 128
+	d := NewDecoder()
+	last := new(image.YCbCr)
+	for i := 0; i <= next; i++ {
+		frame, err := r.Next()
+		if err == io.EOF {
+			t.Fatalf("video ends after %d frames", i)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Keyframe != (i == 0 || i == next) {
+			t.Fatalf("frame %d: Keyframe = %v", i, frame.Keyframe)
+		}
+		if i == 1 {
+			if _, err := decodeNext(NewDecoder(), frame.Data); !errors.Is(err, ErrNoKeyFrame) {
+				t.Errorf("interframe on a new decoder: err = %v, want ErrNoKeyFrame", err)
+			}
+		}
+		img, err := decodeNext(d, frame.Data)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if i == next {
+			w, h := img.Rect.Dx(), img.Rect.Dy()
+			planes := []struct {
+				name   string
+				a, b   []uint8
+				sa, sb int
+				pw, ph int
+			}{
+				{"Y", last.Y, img.Y, last.YStride, img.YStride, w, h},
+				{"Cb", last.Cb, img.Cb, last.CStride, img.CStride, (w + 1) / 2, (h + 1) / 2},
+				{"Cr", last.Cr, img.Cr, last.CStride, img.CStride, (w + 1) / 2, (h + 1) / 2},
+			}
+			for _, p := range planes {
+				if db := psnr(p.a, p.b, p.sa, p.sb, p.pw, p.ph); db < 35 {
+					t.Errorf("%s: frame %d differs from keyframe %d by %.1f dB, want at least 35", p.name, i-1, next, db)
+				}
+			}
+			break
+		}
+		// The decoder reuses its frame buffers.
+		last.Y = append(last.Y[:0], img.Y...)
+		last.Cb = append(last.Cb[:0], img.Cb...)
+		last.Cr = append(last.Cr[:0], img.Cr...)
+		last.YStride, last.CStride, last.Rect = img.YStride, img.CStride, img.Rect
+	}
+}
@@ -0,0 +1,473 @@
+package vp8
+
+// This file implements parsing the interframe headers, macroblock modes and
+// motion vectors, as specified in sections 9.7 to 9.10 and chapters 16 and
+// 17, and keeping the reference frames up to date.
+
+// The reference frames are specified in section 9.7. Intra-predicted
+// macroblocks use no reference frame.
+const (
+	refIntra = iota
+	refLast
+	refGolden
+	refAltRef
+	nRefFrame
+)
+
+// The loop filter mode deltas apply to these groups of macroblock modes, as
+// specified in section 9.6. lfModeNone is used by macroblocks that get no
+// mode delta: 16x16 intra-predicted ones.
+const (
+	lfModeBPred = iota
+	lfModeZero
+	lfModeMV
+	lfModeSplit
+	nLFMode
+	lfModeNone = lfModeZero
+)
+
+const (
+	nYModeProb  = 4
+	nUVModeProb = 3
+	nMVProb     = 19
+)
+
+// Indexes into a motion vector component's probabilities.
+const (
+	mvpIsShort = 0
+	mvpSign    = 1
+	mvpShort   = 2
+	mvpLong    = 9
+	mvLongBits = 10
+)
+
+// entropy holds the probabilities that persist from one frame to the next.
+type entropy struct {
+	tokenProb  [nPlane][nBand][nContext][nProb]uint8
+	yModeProb  [nYModeProb]uint8
+	uvModeProb [nUVModeProb]uint8
+	mvProb     [2][nMVProb]uint8
+}
+
+// defaultEntropy holds the probabilities a key frame starts from.
+var defaultEntropy = entropy{
+	tokenProb:  defaultTokenProb,
+	yModeProb:  [nYModeProb]uint8{112, 86, 140, 37},
+	uvModeProb: [nUVModeProb]uint8{162, 101, 204},
+	mvProb: [2][nMVProb]uint8{
+		{
+			162, 128,
+			225, 146, 172, 147, 214, 39, 156,
+			128, 129, 132, 75, 145, 178, 206, 239, 254, 254,
+		},
+		{
+			164, 128,
+			204, 170, 119, 235, 140, 230, 228,
+			128, 130, 130, 74, 148, 180, 203, 236, 254, 254,
+		},
+	},
+}
+
+// motionVector is a motion vector in eighths of a luma pixel. Luma motion
+// vectors have quarter pixel precision, so x and y are even for them.
+type motionVector struct {
+	x, y int16
+}
+
+// mbInfo is what later macroblocks need to know about a decoded one.
+type mbInfo struct {
+	// segment persists across frames that do not update the segment map.
+	segment uint8
+	// ref is the reference frame, or refIntra.
+	ref uint8
+	// split is whether each 4x4 luma region has its own motion vector in
+	// mvs. mv is the macroblock's motion vector, or that of its last
+	// region when split.
+	split bool
+	mv    motionVector
+	mvs   [16]motionVector
+}
+
+// parseReferenceHeader parses which reference frames an interframe replaces
+// and whether it keeps its probability updates, as specified in sections 9.7
+// and 9.8.
+func (d *Decoder) parseReferenceHeader() {
+	d.refresh[refGolden] = d.fp.readBit(uniformProb)
+	d.refresh[refAltRef] = d.fp.readBit(uniformProb)
+	d.copyTo = [nRefFrame]uint8{}
+	if !d.refresh[refGolden] {
+		d.copyTo[refGolden] = uint8(d.fp.readUint(uniformProb, 2))
+	}
+	if !d.refresh[refAltRef] {
+		d.copyTo[refAltRef] = uint8(d.fp.readUint(uniformProb, 2))
+	}
+	d.signBias[refGolden] = d.fp.readBit(uniformProb)
+	d.signBias[refAltRef] = d.fp.readBit(uniformProb)
+	d.refreshEntropy = d.fp.readBit(uniformProb)
+	d.refresh[refLast] = d.fp.readBit(uniformProb)
+}
+
+// parseInterProb parses the rest of an interframe header, as specified in
+// section 9.10.
+func (d *Decoder) parseInterProb() {
+	d.probIntra = uint8(d.fp.readUint(uniformProb, 8))
+	d.probLast = uint8(d.fp.readUint(uniformProb, 8))
+	d.probGolden = uint8(d.fp.readUint(uniformProb, 8))
+	if d.fp.readBit(uniformProb) {
+		for i := range d.yModeProb {
+			d.yModeProb[i] = uint8(d.fp.readUint(uniformProb, 8))
+		}
+	}
+	if d.fp.readBit(uniformProb) {
+		for i := range d.uvModeProb {
+			d.uvModeProb[i] = uint8(d.fp.readUint(uniformProb, 8))
+		}
+	}
+	// Motion vector probability updates are specified in section 17.2.
+	for i := range d.mvProb {
+		for j := range d.mvProb[i] {
+			if d.fp.readBit(mvUpdateProb[i][j]) {
+				if p := uint8(d.fp.readUint(uniformProb, 7)); p != 0 {
+					d.mvProb[i][j] = p << 1
+				} else {
+					d.mvProb[i][j] = 1
+				}
+			}
+		}
+	}
+}
+
+// updateReferences points the reference frames at the frame just decoded or
+// at each other, as the frame header asked. Copies see the reference frames
+// as they were before this frame.
+func (d *Decoder) updateReferences() {
+	old := d.ref
+	switch d.copyTo[refGolden] {
+	case 1:
+		d.ref[refGolden] = old[refLast]
+	case 2:
+		d.ref[refGolden] = old[refAltRef]
+	}
+	switch d.copyTo[refAltRef] {
+	case 1:
+		d.ref[refAltRef] = old[refLast]
+	case 2:
+		d.ref[refAltRef] = old[refGolden]
+	}
+	for r, refresh := range d.refresh {
+		if refresh {
+			d.ref[r] = d.frame
+		}
+	}
+}
+
+// parseInterModes parses the prediction modes and motion vectors of a
+// macroblock in an interframe, as specified in chapter 16.
+func (d *Decoder) parseInterModes(mbx, mby int, info *mbInfo) {
+	if !d.fp.readBit(d.probIntra) {
+		d.parseIntraModes(info)
+		return
+	}
+	d.inter = true
+	info.ref = refLast
+	if d.fp.readBit(d.probLast) {
+		info.ref = refGolden + uint8(btou(d.fp.readBit(d.probGolden)))
+	}
+	info.split = false
+	best, nearest, near, cnt := d.findNearMVs(mbx, mby, info.ref)
+	switch {
+	case !d.fp.readBit(modeContexts[cnt[0]][0]):
+		info.mv = motionVector{}
+		d.lfMode = lfModeZero
+	case !d.fp.readBit(modeContexts[cnt[1]][1]):
+		info.mv = d.clampMV(nearest, mbx, mby)
+		d.lfMode = lfModeMV
+	case !d.fp.readBit(modeContexts[cnt[2]][2]):
+		info.mv = d.clampMV(near, mbx, mby)
+		d.lfMode = lfModeMV
+	case !d.fp.readBit(modeContexts[cnt[3]][3]):
+		info.mv = d.readMV(d.clampMV(best, mbx, mby))
+		d.lfMode = lfModeMV
+	default:
+		d.parseSplitMVs(mbx, mby, info, d.clampMV(best, mbx, mby))
+		d.lfMode = lfModeSplit
+	}
+}
+
+// parseIntraModes parses the prediction modes of an intra-predicted
+// macroblock in an interframe, as specified in section 16.1. Unlike in key
+// frames, the probabilities do not depend on the neighboring macroblocks.
+func (d *Decoder) parseIntraModes(info *mbInfo) {
+	d.inter = false
+	info.ref, info.split, info.mv = refIntra, false, motionVector{}
+	d.usePredY16 = true
+	d.lfMode = lfModeNone
+	if !d.fp.readBit(d.yModeProb[0]) {
+		d.predY16 = predDC
+	} else if !d.fp.readBit(d.yModeProb[1]) {
+		if !d.fp.readBit(d.yModeProb[2]) {
+			d.predY16 = predVE
+		} else {
+			d.predY16 = predHE
+		}
+	} else if !d.fp.readBit(d.yModeProb[3]) {
+		d.predY16 = predTM
+	} else {
+		d.usePredY16 = false
+		d.lfMode = lfModeBPred
+		for j := range d.predY4 {
+			for i := range d.predY4[j] {
+				d.predY4[j][i] = d.readPredModeY4(&bModeProb)
+			}
+		}
+	}
+	d.predC8 = d.readPredModeC8(&d.uvModeProb)
+}
+
+// neighbor returns the macroblock at (mbx, mby) of the current frame.
+// Macroblocks outside the frame count as intra-predicted.
+func (d *Decoder) neighbor(mbx, mby int) *mbInfo {
+	if mbx < 0 || mby < 0 {
+		return &outsideMB
+	}
+	return &d.mbInfo[d.mbw*mby+mbx]
+}
+
+var outsideMB mbInfo
+
+// findNearMVs finds the best, nearest and near motion vectors among those of
+// the macroblocks above, left of and above-left of (mbx, mby), and counts
+// how often each was seen, as specified in section 16.3. The counts select
+// the probabilities of the macroblock's mode.
+func (d *Decoder) findNearMVs(mbx, mby int, ref uint8) (best, nearest, near motionVector, cnt [4]int) {
+	var mvs [4]motionVector
+	n := 0
+	for i, m := range [3]*mbInfo{
+		d.neighbor(mbx, mby-1),
+		d.neighbor(mbx-1, mby),
+		d.neighbor(mbx-1, mby-1),
+	} {
+		if m.ref == refIntra {
+			continue
+		}
+		weight := 2
+		if i == 2 {
+			weight = 1
+		}
+		if m.mv == (motionVector{}) {
+			cnt[0] += weight
+			continue
+		}
+		mv := m.mv
+		if d.signBias[m.ref] != d.signBias[ref] {
+			mv = motionVector{-mv.x, -mv.y}
+		}
+		if n == 0 || mv != mvs[n] {
+			n++
+			mvs[n] = mv
+		}
+		cnt[n] += weight
+	}
+	// Three distinct motion vectors: merge the above-left one with the
+	// nearest if they are equal.
+	if cnt[3] > 0 && mvs[3] == mvs[1] {
+		cnt[1]++
+	}
+	cnt[3] = 0
+	for i, m := range [3]*mbInfo{
+		d.neighbor(mbx, mby-1),
+		d.neighbor(mbx-1, mby),
+		d.neighbor(mbx-1, mby-1),
+	} {
+		if m.split {
+			cnt[3] += 2 - i/2
+		}
+	}
+	if cnt[2] > cnt[1] {
+		cnt[1], cnt[2] = cnt[2], cnt[1]
+		mvs[1], mvs[2] = mvs[2], mvs[1]
+	}
+	if cnt[1] >= cnt[0] {
+		mvs[0] = mvs[1]
+	}
+	return mvs[0], mvs[1], mvs[2], cnt
+}
+
+// clampMV limits mv so that the macroblock at (mbx, mby) is predicted from
+// no further than 16 pixels beyond the frame's edges, as specified in
+// section 18.1.
+func (d *Decoder) clampMV(mv motionVector, mbx, mby int) motionVector {
+	const margin = 16 << 3
+	minX, maxX := -(mbx*16<<3)-margin, ((d.mbw-1-mbx)*16<<3)+margin
+	minY, maxY := -(mby*16<<3)-margin, ((d.mbh-1-mby)*16<<3)+margin
+	return motionVector{
+		int16(clip(int32(mv.x), int32(minX), int32(maxX))),
+		int16(clip(int32(mv.y), int32(minY), int32(maxY))),
+	}
+}
+
+// readMV reads a motion vector relative to base, as specified in chapter 17.
+func (d *Decoder) readMV(base motionVector) motionVector {
+	y := d.readMVComponent(&d.mvProb[0])
+	x := d.readMVComponent(&d.mvProb[1])
+	return motionVector{base.x + 2*x, base.y + 2*y}
+}
+
+// readMVComponent reads one component of a motion vector, in quarter
+// pixels, as specified in section 17.1.
+func (d *Decoder) readMVComponent(p *[nMVProb]uint8) int16 {
+	var v int16
+	if d.fp.readBit(p[mvpIsShort]) {
+		for i := 0; i < 3; i++ {
+			v += int16(btou(d.fp.readBit(p[mvpLong+i]))) << i
+		}
+		for i := mvLongBits - 1; i > 3; i-- {
+			v += int16(btou(d.fp.readBit(p[mvpLong+i]))) << i
+		}
+		// Bit 3 is implicit when no higher bit is set, since short values
+		// cover everything below 8.
+		if v&^0xf == 0 || d.fp.readBit(p[mvpLong+3]) {
+			v += 8
+		}
+	} else if !d.fp.readBit(p[mvpShort]) {
+		b := btou(d.fp.readBit(p[mvpShort+1]))
+		v = int16(2*b + btou(d.fp.readBit(p[mvpShort+2+b])))
+	} else {
+		b := btou(d.fp.readBit(p[mvpShort+4]))
+		v = int16(4 + 2*b + btou(d.fp.readBit(p[mvpShort+5+b])))
+	}
+	if v != 0 && d.fp.readBit(p[mvpSign]) {
+		v = -v
+	}
+	return v
+}
+
+// parseSplitMVs parses the motion vectors of a macroblock whose luma
+// regions are predicted separately, as specified in section 16.4. New
+// motion vectors are relative to best.
+func (d *Decoder) parseSplitMVs(mbx, mby int, info *mbInfo, best motionVector) {
+	s := splitQuarters
+	if !d.fp.readBit(110) {
+		s = split4x4
+	} else if d.fp.readBit(111) {
+		s = int(btou(d.fp.readBit(150)))
+	}
+	left, above := d.neighbor(mbx-1, mby), d.neighbor(mbx, mby-1)
+	for part := uint8(0); part < splitCount[s]; part++ {
+		// k is the first region of the partition.
+		k := 0
+		for splits[s][k] != part {
+			k++
+		}
+		var leftMV, aboveMV motionVector
+		switch {
+		case k&3 != 0:
+			leftMV = info.mvs[k-1]
+		case left.split:
+			leftMV = left.mvs[k+3]
+		default:
+			leftMV = left.mv
+		}
+		switch {
+		case k >= 4:
+			aboveMV = info.mvs[k-4]
+		case above.split:
+			aboveMV = above.mvs[k+12]
+		default:
+			aboveMV = above.mv
+		}
+		prob := subMVRefProb(leftMV, aboveMV)
+		var mv motionVector
+		switch {
+		case !d.fp.readBit(prob[0]):
+			mv = leftMV
+		case !d.fp.readBit(prob[1]):
+			mv = aboveMV
+		case !d.fp.readBit(prob[2]):
+			mv = motionVector{}
+		default:
+			mv = d.readMV(best)
+		}
+		for i, p := range splits[s] {
+			if p == part {
+				info.mvs[i] = mv
+			}
+		}
+	}
+	info.split = true
+	info.mv = info.mvs[15]
+}
+
+// subMVRefProb returns the probabilities of a split region's motion vector
+// given those of the regions left of and above it.
+func subMVRefProb(left, above motionVector) *[3]uint8 {
+	var zero motionVector
+	switch {
+	case left == above && above == zero:
+		return &subMVRefProbs[4]
+	case left == above:
+		return &subMVRefProbs[3]
+	case above == zero:
+		return &subMVRefProbs[2]
+	case left == zero:
+		return &subMVRefProbs[1]
+	}
+	return &subMVRefProbs[0]
+}
+
+// The ways to split a macroblock's luma regions into partitions that share
+// a motion vector are specified in section 16.4.
+const (
+	split16x8 = iota
+	split8x16
+	splitQuarters
+	split4x4
+)
+
+var (
+	splitCount = [4]uint8{2, 2, 4, 16}
+	splits     = [4][16]uint8{
+		{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1},
+		{0, 0, 1, 1, 0, 0, 1, 1, 0, 0, 1, 1, 0, 0, 1, 1},
+		{0, 0, 1, 1, 0, 0, 1, 1, 2, 2, 3, 3, 2, 2, 3, 3},
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	}
+	subMVRefProbs = [5][3]uint8{
+		{147, 136, 18},
+		{106, 145, 1},
+		{179, 121, 1},
+		{223, 1, 34},
+		{208, 1, 1},
+	}
+)
+
+// modeContexts are the probabilities of an inter-predicted macroblock's
+// mode given how often the near motion vectors were seen, as specified in
+// section 16.3.
+var modeContexts = [6][4]uint8{
+	{7, 1, 1, 143},
+	{14, 18, 14, 107},
+	{135, 64, 57, 68},
+	{60, 56, 128, 65},
+	{159, 134, 128, 34},
+	{234, 188, 128, 28},
+}
+
+// bModeProb are the probabilities of a 4x4 region's predictor mode in an
+// interframe, as specified in section 16.1.
+var bModeProb = [9]uint8{120, 90, 79, 133, 87, 85, 80, 111, 151}
+
+// mvUpdateProb are the probabilities that a motion vector probability is
+// updated, as specified in section 17.2.
+var mvUpdateProb = [2][nMVProb]uint8{
+	{
+		237, 246,
+		253, 253, 254, 254, 254, 254, 254,
+		254, 254, 254, 254, 254, 250, 250, 252, 254, 254,
+	},
+	{
+		231, 243,
+		245, 253, 254, 254, 254, 254, 254,
+		254, 254, 254, 254, 254, 251, 251, 254, 254, 254,
+	},
+}
@@ -0,0 +1,177 @@
+package vp8
+
+// This file implements predicting macroblocks from reference frames, as
+// specified in chapter 18.
+//
+// Motion vectors may point past the edges of the reference frame. Pixels
+// there repeat the nearest edge pixel of the frame, rounded up to whole
+// macroblocks.
+
+// predictInter fills the workspace with the prediction of the current
+// macroblock from its reference frame.
+func (d *Decoder) predictInter(mbx, mby int) {
+	info := &d.mbInfo[d.mbw*mby+mbx]
+	ref := d.ref[info.ref]
+	if !info.split {
+		d.predictBlock(ybrYY, ybrYX, ref.Y, ref.YStride, 16*d.mbw, 16*d.mbh, 16*mbx, 16*mby, 16, 16, info.mv)
+		mv := d.chromaMV(motionVector{
+			roundHalf(info.mv.x),
+			roundHalf(info.mv.y),
+		})
+		d.predictBlock(ybrBY, ybrBX, ref.Cb, ref.CStride, 8*d.mbw, 8*d.mbh, 8*mbx, 8*mby, 8, 8, mv)
+		d.predictBlock(ybrRY, ybrRX, ref.Cr, ref.CStride, 8*d.mbw, 8*d.mbh, 8*mbx, 8*mby, 8, 8, mv)
+		return
+	}
+	for j := 0; j < 4; j++ {
+		for i := 0; i < 4; i++ {
+			d.predictBlock(ybrYY+4*j, ybrYX+4*i, ref.Y, ref.YStride, 16*d.mbw, 16*d.mbh,
+				16*mbx+4*i, 16*mby+4*j, 4, 4, info.mvs[4*j+i])
+		}
+	}
+	// Each 4x4 chroma region moves by the average of the four luma regions
+	// it covers.
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 2; i++ {
+			k := 8*j + 2*i
+			var x, y int
+			for _, mv := range [4]motionVector{info.mvs[k], info.mvs[k+1], info.mvs[k+4], info.mvs[k+5]} {
+				x += int(mv.x)
+				y += int(mv.y)
+			}
+			mv := d.chromaMV(motionVector{roundEighth(x), roundEighth(y)})
+			d.predictBlock(ybrBY+4*j, ybrBX+4*i, ref.Cb, ref.CStride, 8*d.mbw, 8*d.mbh,
+				8*mbx+4*i, 8*mby+4*j, 4, 4, mv)
+			d.predictBlock(ybrRY+4*j, ybrRX+4*i, ref.Cr, ref.CStride, 8*d.mbw, 8*d.mbh,
+				8*mbx+4*i, 8*mby+4*j, 4, 4, mv)
+		}
+	}
+}
+
+// roundHalf halves a luma motion vector component to get the chroma one,
+// rounding away from zero.
+func roundHalf(v int16) int16 {
+	if v < 0 {
+		return (v - 1) / 2
+	}
+	return (v + 1) / 2
+}
+
+// roundEighth divides the sum of four luma motion vector components by eight
+// to get the chroma one of their average, rounding away from zero.
+func roundEighth(v int) int16 {
+	if v < 0 {
+		return int16((v - 4) / 8)
+	}
+	return int16((v + 4) / 8)
+}
+
+// chromaMV drops the fractional part of a chroma motion vector for streams
+// of version 3, which use whole pixel chroma motion.
+func (d *Decoder) chromaMV(mv motionVector) motionVector {
+	if d.frameHeader.VersionNumber == 3 {
+		mv.x &^= 7
+		mv.y &^= 7
+	}
+	return mv
+}
+
+// predictBlock writes the w×h block at (x, y) of a w×h plane, moved by mv,
+// to the workspace at row by and column bx.
+func (d *Decoder) predictBlock(by, bx int, pix []uint8, stride, pw, ph, x, y, w, h int, mv motionVector) {
+	// src holds the block with the two pixels before and three after it in
+	// each direction that the six-tap filter reads.
+	var src [16 + 5][16 + 5]uint8
+	x += int(mv.x >> 3)
+	y += int(mv.y >> 3)
+	if x >= 2 && y >= 2 && x+w+3 <= pw && y+h+3 <= ph {
+		for r := 0; r < h+5; r++ {
+			copy(src[r][:w+5], pix[(y+r-2)*stride+x-2:])
+		}
+	} else {
+		for r := 0; r < h+5; r++ {
+			row := (clampInt(y+r-2, ph-1)) * stride
+			for c := 0; c < w+5; c++ {
+				src[r][c] = pix[row+clampInt(x+c-2, pw-1)]
+			}
+		}
+	}
+
+	fx, fy := int(mv.x&7), int(mv.y&7)
+	if fx == 0 && fy == 0 {
+		for r := 0; r < h; r++ {
+			copy(d.ybr[by+r][bx:bx+w], src[r+2][2:2+w])
+		}
+		return
+	}
+	if d.frameHeader.VersionNumber != 0 {
+		d.predictBilinear(by, bx, &src, w, h, fx, fy)
+		return
+	}
+	// The horizontal pass filters the rows the vertical pass reads.
+	var tmp [16 + 5][16]uint8
+	hf := &sixTapFilters[fx]
+	for r := 0; r < h+5; r++ {
+		s := &src[r]
+		for c := 0; c < w; c++ {
+			tmp[r][c] = filterTaps(hf,
+				s[c], s[c+1], s[c+2], s[c+3], s[c+4], s[c+5])
+		}
+	}
+	vf := &sixTapFilters[fy]
+	for r := 0; r < h; r++ {
+		for c := 0; c < w; c++ {
+			d.ybr[by+r][bx+c] = filterTaps(vf,
+				tmp[r][c], tmp[r+1][c], tmp[r+2][c], tmp[r+3][c], tmp[r+4][c], tmp[r+5][c])
+		}
+	}
+}
+
+// predictBilinear is the second half of predictBlock for streams of
+// versions 1 to 3, which use bilinear filters.
+func (d *Decoder) predictBilinear(by, bx int, src *[16 + 5][16 + 5]uint8, w, h, fx, fy int) {
+	var tmp [16 + 1][16]int32
+	h0, h1 := int32(128-16*fx), int32(16*fx)
+	for r := 0; r < h+1; r++ {
+		s := &src[r+2]
+		for c := 0; c < w; c++ {
+			tmp[r][c] = (int32(s[c+2])*h0 + int32(s[c+3])*h1 + 64) >> 7
+		}
+	}
+	v0, v1 := int32(128-16*fy), int32(16*fy)
+	for r := 0; r < h; r++ {
+		for c := 0; c < w; c++ {
+			d.ybr[by+r][bx+c] = uint8((tmp[r][c]*v0 + tmp[r+1][c]*v1 + 64) >> 7)
+		}
+	}
+}
+
+// filterTaps applies a six-tap filter to six consecutive pixels.
+func filterTaps(f *[6]int32, p0, p1, p2, p3, p4, p5 uint8) uint8 {
+	v := f[0]*int32(p0) + f[1]*int32(p1) + f[2]*int32(p2) +
+		f[3]*int32(p3) + f[4]*int32(p4) + f[5]*int32(p5)
+	return clip8((v + 64) >> 7)
+}
+
+// clampInt clamps x to [0, max].
+func clampInt(x, max int) int {
+	if x < 0 {
+		return 0
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
+
+// sixTapFilters are the subpixel interpolation filters for each eighth of a
+// pixel, as specified in section 18.3. Luma uses the even ones only.
+var sixTapFilters = [8][6]int32{
+	{0, 0, 128, 0, 0, 0},
+	{0, -6, 123, 12, -1, 0},
+	{2, -11, 108, 36, -8, 1},
+	{0, -9, 93, 50, -6, 0},
+	{3, -16, 77, 77, -16, 3},
+	{0, -6, 50, 93, -9, 0},
+	{1, -8, 36, 108, -11, 2},
+	{0, -1, 12, 123, -6, 0},
+}
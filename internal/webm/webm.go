@@ -0,0 +1,543 @@
+// Package webm is a streaming Matroska/WebM demuxer for the video track of
+// a file. It reads EBML sequentially, so it works on pipes and on files
+// being written with unknown-size segments and clusters, and skips
+// everything it does not need (audio, cues, tags).
+package webm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Element IDs, with their length marker bits kept as in the Matroska
+// specification.
+const (
+	idEBML            = 0x1A45DFA3
+	idSegment         = 0x18538067
+	idInfo            = 0x1549A966
+	idTimecodeScale   = 0x2AD7B1
+	idTracks          = 0x1654AE6B
+	idTrackEntry      = 0xAE
+	idTrackNumber     = 0xD7
+	idTrackType       = 0x83
+	idCodecID         = 0x86
+	idDefaultDuration = 0x23E383
+	idVideo           = 0xE0
+	idPixelWidth      = 0xB0
+	idPixelHeight     = 0xBA
+	idAlphaMode       = 0x53C0
+	idCluster         = 0x1F43B675
+	idTimecode        = 0xE7
+	idSimpleBlock     = 0xA3
+	idBlockGroup      = 0xA0
+	idBlock           = 0xA1
+	idBlockAdditions  = 0x75A1
+	idBlockMore       = 0xA6
+	idBlockAddID      = 0xEE
+	idBlockAdditional = 0xA5
+	idReferenceBlock  = 0xFB
+)
+
+const (
+	trackTypeVideo = 1
+
+	// unknownSize marks an element whose end is only known when the next
+	// element of the same or a higher level starts.
+	unknownSize = -1
+
+	// maxElementSize bounds the elements that are read into memory.
+	maxElementSize = 64 << 20
+)
+
+// Track describes the video track being demuxed.
+type Track struct {
+	Number          uint64
+	CodecID         string
+	Width           int
+	Height          int
+	Alpha           bool
+	DefaultDuration time.Duration
+}
+
+// Frame is one video frame with its presentation time.
+type Frame struct {
+	Data []byte
+	// Alpha is the BlockAdditional carrying the alpha channel, encoded
+	// with the same codec as Data, or nil.
+	Alpha     []byte
+	Timestamp time.Duration
+	Keyframe  bool
+}
+
+// Reader reads the frames of the first video track.
+type Reader struct {
+	r             *bufio.Reader
+	track         Track
+	timecodeScale time.Duration
+	clusterTime   int64
+	pending       []Frame
+}
+
+// NewReader reads the file header up to the track list and selects the
+// first video track.
+func NewReader(r io.Reader) (*Reader, error) {
+	d := &Reader{r: bufio.NewReaderSize(r, 64<<10), timecodeScale: time.Millisecond}
+
+	id, size, err := d.readHeader()
+	if err != nil {
+		return nil, fmt.Errorf("webm: %w", err)
+	}
+	if id != idEBML {
+		return nil, errors.New("webm: not an EBML file")
+	}
+	if err := d.skip(size); err != nil {
+		return nil, fmt.Errorf("webm: %w", err)
+	}
+
+	for d.track.Number == 0 {
+		id, size, err := d.readHeader()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("webm: no video track")
+			}
+			return nil, fmt.Errorf("webm: %w", err)
+		}
+		switch id {
+		case idSegment:
+			// Descend into the segment's children.
+		case idInfo, idTracks:
+			body, err := d.readBody(size)
+			if err != nil {
+				return nil, fmt.Errorf("webm: %w", err)
+			}
+			if id == idInfo {
+				err = d.parseInfo(body)
+			} else {
+				err = d.parseTracks(body)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("webm: %w", err)
+			}
+		case idCluster:
+			return nil, errors.New("webm: cluster before track list")
+		default:
+			if err := d.skip(size); err != nil {
+				return nil, fmt.Errorf("webm: %w", err)
+			}
+		}
+	}
+	return d, nil
+}
+
+// Track returns the video track being read.
+func (d *Reader) Track() Track {
+	return d.track
+}
+
+// Next returns the next frame of the video track, or io.EOF at the end of
+// the file.
+func (d *Reader) Next() (Frame, error) {
+	for len(d.pending) == 0 {
+		if err := d.readElement(); err != nil {
+			return Frame{}, err
+		}
+	}
+	f := d.pending[0]
+	d.pending = d.pending[1:]
+	return f, nil
+}
+
+// readElement consumes one element below the segment level, queueing the
+// frames of any block that belongs to the video track.
+func (d *Reader) readElement() error {
+	id, size, err := d.readHeader()
+	if err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("webm: %w", err)
+	}
+
+	switch id {
+	case idSegment, idCluster:
+		// Descend; their children are read as they come.
+		return nil
+	case idTimecode:
+		body, err := d.readBody(size)
+		if err != nil {
+			return fmt.Errorf("webm: %w", err)
+		}
+		d.clusterTime = int64(readUint(body))
+		return nil
+	case idSimpleBlock:
+		body, err := d.readBody(size)
+		if err != nil {
+			return fmt.Errorf("webm: %w", err)
+		}
+		return d.parseBlock(body, true, false, nil)
+	case idBlockGroup:
+		body, err := d.readBody(size)
+		if err != nil {
+			return fmt.Errorf("webm: %w", err)
+		}
+		return d.parseBlockGroup(body)
+	}
+	if err := d.skip(size); err != nil {
+		return fmt.Errorf("webm: %w", err)
+	}
+	return nil
+}
+
+func (d *Reader) parseInfo(body []byte) error {
+	return eachChild(body, func(id uint64, data []byte) error {
+		if id == idTimecodeScale {
+			if scale := readUint(data); scale > 0 {
+				d.timecodeScale = time.Duration(scale)
+			}
+		}
+		return nil
+	})
+}
+
+func (d *Reader) parseTracks(body []byte) error {
+	return eachChild(body, func(id uint64, data []byte) error {
+		if id != idTrackEntry || d.track.Number != 0 {
+			return nil
+		}
+		var t Track
+		var typ uint64
+		err := eachChild(data, func(id uint64, data []byte) error {
+			switch id {
+			case idTrackNumber:
+				t.Number = readUint(data)
+			case idTrackType:
+				typ = readUint(data)
+			case idCodecID:
+				t.CodecID = string(bytes.TrimRight(data, "\x00"))
+			case idDefaultDuration:
+				t.DefaultDuration = time.Duration(readUint(data))
+			case idVideo:
+				return eachChild(data, func(id uint64, data []byte) error {
+					switch id {
+					case idPixelWidth:
+						t.Width = int(readUint(data))
+					case idPixelHeight:
+						t.Height = int(readUint(data))
+					case idAlphaMode:
+						t.Alpha = readUint(data) == 1
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if typ == trackTypeVideo && t.Number != 0 {
+			d.track = t
+		}
+		return nil
+	})
+}
+
+func (d *Reader) parseBlockGroup(body []byte) error {
+	var block, alpha []byte
+	keyframe := true
+	err := eachChild(body, func(id uint64, data []byte) error {
+		switch id {
+		case idBlock:
+			block = data
+		case idReferenceBlock:
+			keyframe = false
+		case idBlockAdditions:
+			return eachChild(data, func(id uint64, data []byte) error {
+				if id != idBlockMore {
+					return nil
+				}
+				addID := uint64(1)
+				var additional []byte
+				err := eachChild(data, func(id uint64, data []byte) error {
+					switch id {
+					case idBlockAddID:
+						addID = readUint(data)
+					case idBlockAdditional:
+						additional = data
+					}
+					return nil
+				})
+				// BlockAddID 1 carries the alpha channel in WebM.
+				if addID == 1 && additional != nil {
+					alpha = additional
+				}
+				return err
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		return errors.New("webm: block group without a block")
+	}
+	return d.parseBlock(block, false, keyframe, alpha)
+}
+
+// parseBlock queues the frames of a Block or SimpleBlock. For a Block,
+// keyframe comes from the enclosing group; a SimpleBlock has its own flag.
+func (d *Reader) parseBlock(b []byte, simple, keyframe bool, alpha []byte) error {
+	track, n := readVint(b)
+	if n == 0 || len(b) < n+3 {
+		return errors.New("webm: short block")
+	}
+	if track != d.track.Number {
+		return nil
+	}
+	rel := int64(int16(binary.BigEndian.Uint16(b[n:])))
+	flags := b[n+2]
+	b = b[n+3:]
+
+	if simple {
+		keyframe = flags&0x80 != 0
+	}
+
+	frames, err := unlace(b, (flags>>1)&0x03)
+	if err != nil {
+		return err
+	}
+
+	ts := time.Duration(d.clusterTime+rel) * d.timecodeScale
+	for i, data := range frames {
+		f := Frame{
+			Data:      data,
+			Timestamp: ts + time.Duration(i)*d.track.DefaultDuration,
+			Keyframe:  keyframe && i == 0,
+		}
+		if i == 0 {
+			f.Alpha = alpha
+		}
+		d.pending = append(d.pending, f)
+	}
+	return nil
+}
+
+// Lacing modes, from bits 1-2 of the block flags.
+const (
+	lacingNone  = 0
+	lacingXiph  = 1
+	lacingFixed = 2
+	lacingEBML  = 3
+)
+
+// unlace splits a block's payload into its frames.
+func unlace(b []byte, lacing byte) ([][]byte, error) {
+	if lacing == lacingNone {
+		return [][]byte{b}, nil
+	}
+	if len(b) < 1 {
+		return nil, errors.New("webm: short laced block")
+	}
+	count := int(b[0]) + 1
+	b = b[1:]
+
+	sizes := make([]int, count)
+	switch lacing {
+	case lacingXiph:
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(b) == 0 {
+					return nil, errors.New("webm: short Xiph lace")
+				}
+				c := b[0]
+				b = b[1:]
+				sizes[i] += int(c)
+				if c != 255 {
+					break
+				}
+			}
+		}
+	case lacingEBML:
+		first, n := readVint(b)
+		if n == 0 {
+			return nil, errors.New("webm: bad EBML lace")
+		}
+		b = b[n:]
+		sizes[0] = int(first)
+		for i := 1; i < count-1; i++ {
+			v, n := readVint(b)
+			if n == 0 {
+				return nil, errors.New("webm: bad EBML lace")
+			}
+			b = b[n:]
+			// Differences are stored with a bias that makes them signed.
+			sizes[i] = sizes[i-1] + int(int64(v)-(int64(1)<<(7*n-1)-1))
+		}
+	case lacingFixed:
+		if len(b)%count != 0 {
+			return nil, errors.New("webm: uneven fixed-size lace")
+		}
+		for i := range sizes {
+			sizes[i] = len(b) / count
+		}
+	}
+
+	if lacing != lacingFixed {
+		rest := len(b)
+		for _, s := range sizes[:count-1] {
+			rest -= s
+		}
+		sizes[count-1] = rest
+	}
+
+	frames := make([][]byte, count)
+	for i, s := range sizes {
+		if s < 0 || s > len(b) {
+			return nil, errors.New("webm: lace sizes exceed the block")
+		}
+		frames[i] = b[:s]
+		b = b[s:]
+	}
+	return frames, nil
+}
+
+// readHeader reads an element ID and size from the stream.
+func (d *Reader) readHeader() (id uint64, size int64, err error) {
+	id, _, err = d.readVint(true)
+	if err != nil {
+		return 0, 0, err
+	}
+	v, n, err := d.readVint(false)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	if v == 1<<(7*n)-1 {
+		return id, unknownSize, nil
+	}
+	return id, int64(v), nil
+}
+
+// readVint reads a variable-length integer, keeping the length marker
+// for element IDs.
+func (d *Reader) readVint(keepMarker bool) (uint64, int, error) {
+	first, err := d.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	n := 1
+	for mask := byte(0x80); first&mask == 0; mask >>= 1 {
+		if mask == 1 {
+			return 0, 0, errors.New("invalid variable-length integer")
+		}
+		n++
+	}
+	v := uint64(first)
+	if !keepMarker {
+		v &= uint64(0xff >> n)
+	}
+	for i := 1; i < n; i++ {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, 0, err
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, n, nil
+}
+
+func (d *Reader) readBody(size int64) ([]byte, error) {
+	if size == unknownSize || size > maxElementSize {
+		return nil, fmt.Errorf("element of size %d cannot be read", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (d *Reader) skip(size int64) error {
+	if size == unknownSize {
+		return errors.New("cannot skip an element of unknown size")
+	}
+	if _, err := d.r.Discard(int(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// eachChild calls fn for every child element of an in-memory master
+// element.
+func eachChild(b []byte, fn func(id uint64, data []byte) error) error {
+	for len(b) > 0 {
+		id, n := readID(b)
+		if n == 0 {
+			return errors.New("webm: bad element ID")
+		}
+		b = b[n:]
+		size, n := readVint(b)
+		if n == 0 || size > uint64(len(b)-n) {
+			return errors.New("webm: element exceeds its parent")
+		}
+		b = b[n:]
+		if err := fn(id, b[:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// readVint decodes a variable-length integer without its length marker,
+// returning 0 bytes read on malformed input.
+func readVint(b []byte) (uint64, int) {
+	id, n := readID(b)
+	if n == 0 {
+		return 0, 0
+	}
+	return id &^ (1 << (7 * n)), n
+}
+
+// readID decodes a variable-length integer keeping its length marker.
+func readID(b []byte) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if len(b) < n {
+		return 0, 0
+	}
+	var v uint64
+	for _, c := range b[:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
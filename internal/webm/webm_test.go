@@ -0,0 +1,174 @@
+package webm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// element encodes an EBML element with an 8-byte size, or an unknown
+// size when size is negative.
+func element(id uint64, body ...[]byte) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	data := bytes.Join(body, nil)
+	n := uint64(len(data))
+	b = append(b, 0x01, byte(n>>48), byte(n>>40), byte(n>>32), byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	return append(b, data...)
+}
+
+func unknownSizeElement(id uint64) []byte {
+	return append(element(id)[:4], 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+}
+
+func uintElement(id, v uint64) []byte {
+	return element(id, []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+func block(track byte, rel int16, flags byte, payload ...byte) []byte {
+	return append([]byte{0x80 | track, byte(uint16(rel) >> 8), byte(rel), flags}, payload...)
+}
+
+func testFile() []byte {
+	header := element(idEBML, element(0x4282, []byte("webm")))
+	tracks := element(idTracks,
+		element(idTrackEntry,
+			uintElement(idTrackNumber, 1),
+			uintElement(idTrackType, 2),
+			element(idCodecID, []byte("A_OPUS"))),
+		element(idTrackEntry,
+			uintElement(idTrackNumber, 2),
+			uintElement(idTrackType, trackTypeVideo),
+			element(idCodecID, []byte("V_VP8")),
+			uintElement(idDefaultDuration, uint64(10*time.Millisecond)),
+			element(idVideo,
+				uintElement(idPixelWidth, 64),
+				uintElement(idPixelHeight, 32),
+				uintElement(idAlphaMode, 1))))
+
+	cluster1 := element(idCluster,
+		uintElement(idTimecode, 1000),
+		element(idSimpleBlock, block(2, 0, 0x80, 'k')),
+		element(idSimpleBlock, block(1, 0, 0x80, 'a', 'u', 'd')),
+		// Xiph lacing: sizes 2 and 1, then the last frame takes the rest.
+		element(idSimpleBlock, block(2, 20, 0x02, 2, 2, 1, 'x', 'x', 'y', 'z', 'z', 'z')),
+		element(idBlockGroup,
+			element(idBlock, block(2, 40, 0, 'c')),
+			uintElement(idReferenceBlock, 1),
+			element(idBlockAdditions,
+				element(idBlockMore,
+					uintElement(idBlockAddID, 1),
+					element(idBlockAdditional, []byte("alpha"))))))
+
+	// An unknown-size cluster, as written by live encoders.
+	cluster2 := bytes.Join([][]byte{
+		unknownSizeElement(idCluster),
+		uintElement(idTimecode, 2000),
+		// EBML lacing: first size 1, then +1, the last takes the rest.
+		element(idSimpleBlock, block(2, -5, 0x06, 2, 0x81, 0xc0, 'p', 'q', 'q', 'r')),
+		// Fixed-size lacing.
+		element(idSimpleBlock, block(2, 5, 0x04, 1, 'm', 'm', 'n', 'n')),
+	}, nil)
+
+	segment := append(unknownSizeElement(idSegment), tracks...)
+	segment = append(segment, cluster1...)
+	segment = append(segment, cluster2...)
+	return append(header, segment...)
+}
+
+func TestReader(t *testing.T) {
+	d, err := NewReader(bytes.NewReader(testFile()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Track()
+	if track.Number != 2 || track.CodecID != "V_VP8" || track.Width != 64 || track.Height != 32 || !track.Alpha {
+		t.Errorf("track = %+v", track)
+	}
+
+	ms := time.Millisecond
+	want := []struct {
+		data, alpha string
+		ts          time.Duration
+		key         bool
+	}{
+		{"k", "", 1000 * ms, true},
+		{"xx", "", 1020 * ms, false},
+		{"y", "", 1030 * ms, false},
+		{"zzz", "", 1040 * ms, false},
+		{"c", "alpha", 1040 * ms, false},
+		{"p", "", 1995 * ms, false},
+		{"qq", "", 2005 * ms, false},
+		{"r", "", 2015 * ms, false},
+		{"mm", "", 2005 * ms, false},
+		{"nn", "", 2015 * ms, false},
+	}
+	for i, w := range want {
+		f, err := d.Next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if string(f.Data) != w.data || string(f.Alpha) != w.alpha || f.Timestamp != w.ts || f.Keyframe != w.key {
+			t.Errorf("frame %d = {%q %q %v %v}, want {%q %q %v %v}", i,
+				f.Data, f.Alpha, f.Timestamp, f.Keyframe, w.data, w.alpha, w.ts, w.key)
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("after the last frame: %v, want io.EOF", err)
+	}
+}
+
+func TestReaderRejectsNonWebM(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("RIFF....WEBP"))); err == nil {
+		t.Error("NewReader accepted a WebP file")
+	}
+}
+
+func TestReaderBundledVideo(t *testing.T) {
+	f, err := os.Open("../../video.webm")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+
+	d, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track := d.Track(); track.CodecID != "V_VP8" || track.Width != 1920 || track.Height != 1080 {
+		t.Errorf("track = %+v", track)
+	}
+
+	var frames, keyframes int
+	var last time.Duration
+	for {
+		f, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A VP8 frame tag has its low bit clear on keyframes.
+		if isKey := f.Data[0]&1 == 0; isKey != f.Keyframe {
+			t.Errorf("frame %d: Keyframe = %v, bitstream says %v", frames, f.Keyframe, isKey)
+		}
+		if f.Timestamp < last {
+			t.Errorf("frame %d: timestamp %v before %v", frames, f.Timestamp, last)
+		}
+		if f.Keyframe {
+			keyframes++
+		}
+		last = f.Timestamp
+		frames++
+	}
+	if frames < 2 || keyframes < 1 {
+		t.Errorf("read %d frames with %d keyframes", frames, keyframes)
+	}
+}
@@ -0,0 +1,582 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"slices"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// Animation is an animated image decoded into full-canvas premultiplied
+// BGRA frames, with disposal and blending already applied.
+type Animation struct {
+	Width  int
+	Height int
+	Frames [][]byte
+	Delays []time.Duration
+	// LoopCount is how many times the sequence plays; 0 loops forever.
+	LoopCount int
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// DecodeAnimation decodes an animated GIF, APNG or animated WebP. It
+// returns nil without an error when data is not an animation, so the
+// caller can fall back to DecodeImageToBGRA. The canvas is checked against
+// limits before decoding, and the decoded frames together may not exceed
+// limits.MaxMemory.
+func DecodeAnimation(data []byte, limits Limits) (*Animation, error) {
+	codec, err := SniffCodec(data)
+	if err != nil || codec.DecodeAnimation == nil {
+		return nil, nil
+	}
+	if _, err := codec.checkSize(data, limits); err != nil {
+		return nil, err
+	}
+	return codec.decodeAnimation(data, limits)
+}
+
+// frameDelay applies the browsers' convention of treating very short
+// delays, which many encoders write as 0, as 100ms.
+func frameDelay(d time.Duration) time.Duration {
+	if d < 20*time.Millisecond {
+		return 100 * time.Millisecond
+	}
+	return d
+}
+
+// compositor accumulates frames on a canvas and snapshots it after each
+// one.
+type compositor struct {
+	canvas *image.RGBA
+	anim   *Animation
+	limits Limits
+	size   int64
+}
+
+func newCompositor(width, height, loopCount int, limits Limits) (*compositor, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid animation size %dx%d", width, height)
+	}
+	if err := limits.CheckSize(width, height); err != nil {
+		return nil, err
+	}
+	return &compositor{
+		canvas: image.NewRGBA(image.Rect(0, 0, width, height)),
+		anim:   &Animation{Width: width, Height: height, LoopCount: loopCount},
+		limits: limits,
+	}, nil
+}
+
+// draw composites frame into r, blending over the canvas or replacing it.
+func (c *compositor) draw(r image.Rectangle, frame image.Image, blend bool) {
+	op := draw.Src
+	if blend {
+		op = draw.Over
+	}
+	draw.Draw(c.canvas, r, frame, frame.Bounds().Min, op)
+}
+
+func (c *compositor) clear(r image.Rectangle) {
+	draw.Draw(c.canvas, r, image.Transparent, image.Point{}, draw.Src)
+}
+
+func (c *compositor) emit(delay time.Duration) error {
+	c.size += int64(len(c.canvas.Pix))
+	if c.limits.MaxMemory > 0 && c.size > c.limits.MaxMemory {
+		return &LimitError{Limit: "memory", Value: c.size, Max: c.limits.MaxMemory}
+	}
+	bgra := make([]byte, len(c.canvas.Pix))
+	convertToBGRA(bgra, c.canvas)
+	c.anim.Frames = append(c.anim.Frames, bgra)
+	c.anim.Delays = append(c.anim.Delays, frameDelay(delay))
+	return nil
+}
+
+func (c *compositor) result() (*Animation, error) {
+	if len(c.anim.Frames) == 0 {
+		return nil, errors.New("animation has no frames")
+	}
+	return c.anim, nil
+}
+
+// gifCost returns the bytes the full-canvas frames of an animated GIF
+// take once decoded, without decoding it. gif.DecodeAll allocates every
+// frame before returning, so each frame is checked against limits, and
+// the total against limits.MaxMemory, from the image descriptors first.
+func gifCost(data []byte, limits Limits) (int64, error) {
+	const header = 13
+	if len(data) < header {
+		return 0, errors.New("gif: truncated header")
+	}
+	width := int(binary.LittleEndian.Uint16(data[6:]))
+	height := int(binary.LittleEndian.Uint16(data[8:]))
+	off := header
+	if flags := data[10]; flags&0x80 != 0 {
+		off += 3 << (flags&0x07 + 1)
+	}
+
+	// skipBlocks skips the data sub-blocks starting at off.
+	skipBlocks := func() bool {
+		for off < len(data) {
+			n := int(data[off])
+			off += 1 + n
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := 0
+	for off < len(data) {
+		switch data[off] {
+		case 0x21: // extension: label, then sub-blocks
+			off += 2
+			if !skipBlocks() {
+				return 0, errors.New("gif: truncated extension")
+			}
+		case 0x2c: // image descriptor, color table, LZW code size, sub-blocks
+			if off+10 > len(data) {
+				return 0, errors.New("gif: truncated image descriptor")
+			}
+			w := int(binary.LittleEndian.Uint16(data[off+5:]))
+			h := int(binary.LittleEndian.Uint16(data[off+7:]))
+			if err := limits.CheckSize(w, h); err != nil {
+				return 0, err
+			}
+			flags := data[off+9]
+			off += 10
+			if flags&0x80 != 0 {
+				off += 3 << (flags&0x07 + 1)
+			}
+			off++
+			if !skipBlocks() {
+				return 0, errors.New("gif: truncated image data")
+			}
+			frames++
+		default: // trailer
+			off = len(data)
+		}
+	}
+
+	return animationBytes(width, height, frames, limits)
+}
+
+// animationBytes returns the bytes an animation of frames width x height
+// frames takes once decoded, or a LimitError if that exceeds
+// limits.MaxMemory.
+func animationBytes(width, height, frames int, limits Limits) (int64, error) {
+	cost := frameBytes(width, height) * int64(frames)
+	if limits.MaxMemory > 0 && cost > limits.MaxMemory {
+		return 0, &LimitError{Limit: "memory", Value: cost, Max: limits.MaxMemory}
+	}
+	return cost, nil
+}
+
+func decodeGIF(data []byte, limits Limits) (*Animation, error) {
+	if _, err := gifCost(data, limits); err != nil {
+		return nil, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) < 2 {
+		return nil, nil
+	}
+
+	// GIF counts repeats after the first play; -1 means play once.
+	loops := 0
+	switch {
+	case g.LoopCount < 0:
+		loops = 1
+	case g.LoopCount > 0:
+		loops = g.LoopCount + 1
+	}
+	c, err := newCompositor(g.Config.Width, g.Config.Height, loops, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var saved []byte
+		if disposal == gif.DisposalPrevious {
+			saved = slices.Clone(c.canvas.Pix)
+		}
+
+		c.draw(frame.Bounds(), frame, true)
+		if err := c.emit(time.Duration(g.Delay[i]) * 10 * time.Millisecond); err != nil {
+			return nil, err
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			c.clear(frame.Bounds())
+		case gif.DisposalPrevious:
+			copy(c.canvas.Pix, saved)
+		}
+	}
+	return c.result()
+}
+
+type apngFrame struct {
+	rect     image.Rectangle
+	delay    time.Duration
+	disposal byte
+	blend    bool
+	data     []byte
+}
+
+// apngCost is gifCost for APNG: it checks every fcTL frame against limits
+// and returns what the frames take on the canvas. A PNG without an acTL
+// chunk is a still and costs nothing beyond its one frame.
+func apngCost(data []byte, limits Limits) (int64, error) {
+	var width, height, frames int
+	animated := false
+	off := len(pngSignature)
+	for off+12 <= len(data) {
+		n := int(binary.BigEndian.Uint32(data[off:]))
+		if n < 0 || off+12+n > len(data) {
+			return 0, errors.New("apng: truncated chunk")
+		}
+		typ := string(data[off+4 : off+8])
+		chunk := data[off+8 : off+8+n]
+		off += 12 + n
+
+		switch typ {
+		case "IHDR":
+			if n < 13 {
+				return 0, errors.New("apng: short IHDR")
+			}
+			width = int(binary.BigEndian.Uint32(chunk))
+			height = int(binary.BigEndian.Uint32(chunk[4:]))
+		case "acTL":
+			animated = true
+		case "fcTL":
+			if n < 26 {
+				return 0, errors.New("apng: short fcTL")
+			}
+			w := int(binary.BigEndian.Uint32(chunk[4:]))
+			h := int(binary.BigEndian.Uint32(chunk[8:]))
+			if err := limits.CheckSize(w, h); err != nil {
+				return 0, err
+			}
+			frames++
+		case "IDAT":
+			// acTL has to come before the image data, so this is a still.
+			if !animated {
+				return 0, nil
+			}
+		case "IEND":
+			off = len(data)
+		}
+	}
+	if !animated {
+		return 0, nil
+	}
+	return animationBytes(width, height, frames, limits)
+}
+
+// decodeAPNG decodes a PNG with an acTL chunk by rebuilding each frame as
+// a standalone PNG for image/png.
+func decodeAPNG(data []byte, limits Limits) (*Animation, error) {
+	if _, err := apngCost(data, limits); err != nil {
+		return nil, err
+	}
+	var (
+		ihdr     []byte
+		shared   [][]byte
+		frames   []*apngFrame
+		current  *apngFrame
+		animated bool
+		plays    int
+	)
+
+	off := len(pngSignature)
+	for off+12 <= len(data) {
+		n := int(binary.BigEndian.Uint32(data[off:]))
+		if n < 0 || off+12+n > len(data) {
+			return nil, errors.New("apng: truncated chunk")
+		}
+		typ := string(data[off+4 : off+8])
+		chunk := data[off+8 : off+8+n]
+		raw := data[off : off+12+n]
+		off += 12 + n
+
+		switch typ {
+		case "IHDR":
+			if n < 13 {
+				return nil, errors.New("apng: short IHDR")
+			}
+			ihdr = chunk
+		case "acTL":
+			if n < 8 {
+				return nil, errors.New("apng: short acTL")
+			}
+			animated = true
+			plays = int(binary.BigEndian.Uint32(chunk[4:]))
+		case "PLTE", "tRNS":
+			shared = append(shared, raw)
+		case "fcTL":
+			if n < 26 {
+				return nil, errors.New("apng: short fcTL")
+			}
+			w := int(binary.BigEndian.Uint32(chunk[4:]))
+			h := int(binary.BigEndian.Uint32(chunk[8:]))
+			x := int(binary.BigEndian.Uint32(chunk[12:]))
+			y := int(binary.BigEndian.Uint32(chunk[16:]))
+			num := time.Duration(binary.BigEndian.Uint16(chunk[20:]))
+			den := time.Duration(binary.BigEndian.Uint16(chunk[22:]))
+			if den == 0 {
+				den = 100
+			}
+			current = &apngFrame{
+				rect:     image.Rect(x, y, x+w, y+h),
+				delay:    num * time.Second / den,
+				disposal: chunk[24],
+				blend:    chunk[25] == 1,
+			}
+			frames = append(frames, current)
+		case "IDAT":
+			if !animated {
+				return nil, nil
+			}
+			// The default image is only part of the animation when an
+			// fcTL precedes it.
+			if current != nil {
+				current.data = append(current.data, chunk...)
+			}
+		case "fdAT":
+			if current == nil || n < 4 {
+				return nil, errors.New("apng: unexpected fdAT")
+			}
+			current.data = append(current.data, chunk[4:]...)
+		case "IEND":
+			off = len(data)
+		}
+	}
+	if !animated {
+		return nil, nil
+	}
+	if ihdr == nil {
+		return nil, errors.New("apng: missing IHDR")
+	}
+
+	c, err := newCompositor(int(binary.BigEndian.Uint32(ihdr)), int(binary.BigEndian.Uint32(ihdr[4:])), plays, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range frames {
+		if !f.rect.In(c.canvas.Bounds()) || f.rect.Empty() {
+			return nil, fmt.Errorf("apng: frame %d outside the canvas", i)
+		}
+		img, err := png.Decode(bytes.NewReader(standalonePNG(ihdr, shared, f)))
+		if err != nil {
+			return nil, fmt.Errorf("apng: frame %d: %w", i, err)
+		}
+
+		disposal := f.disposal
+		if i == 0 && disposal == 2 {
+			disposal = 1
+		}
+		var saved []byte
+		if disposal == 2 {
+			saved = slices.Clone(c.canvas.Pix)
+		}
+
+		c.draw(f.rect, img, f.blend)
+		if err := c.emit(f.delay); err != nil {
+			return nil, err
+		}
+
+		switch disposal {
+		case 1:
+			c.clear(f.rect)
+		case 2:
+			copy(c.canvas.Pix, saved)
+		}
+	}
+	return c.result()
+}
+
+func standalonePNG(ihdr []byte, shared [][]byte, f *apngFrame) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+
+	header := slices.Clone(ihdr)
+	binary.BigEndian.PutUint32(header[0:], uint32(f.rect.Dx()))
+	binary.BigEndian.PutUint32(header[4:], uint32(f.rect.Dy()))
+	writePNGChunk(&buf, "IHDR", header)
+	for _, raw := range shared {
+		buf.Write(raw)
+	}
+	writePNGChunk(&buf, "IDAT", f.data)
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	buf.WriteString(typ)
+	buf.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// webpCost is gifCost for animated WebP: it checks every ANMF frame
+// against limits and returns what the frames take on the canvas. Other
+// WebP files cost nothing beyond their one frame.
+func webpCost(data []byte, limits Limits) (int64, error) {
+	chunks, err := riffChunks(data[12:])
+	if err != nil {
+		return 0, fmt.Errorf("webp: %w", err)
+	}
+	const animationBit = 0x02
+	if len(chunks) == 0 || chunks[0].id != "VP8X" || len(chunks[0].data) < 10 ||
+		chunks[0].data[0]&animationBit == 0 {
+		return 0, nil
+	}
+	vp8x := chunks[0].data
+
+	frames := 0
+	for _, ch := range chunks {
+		if ch.id != "ANMF" {
+			continue
+		}
+		if len(ch.data) < 16 {
+			return 0, errors.New("webp: short ANMF chunk")
+		}
+		if err := limits.CheckSize(1+int(uint24(ch.data[6:])), 1+int(uint24(ch.data[9:]))); err != nil {
+			return 0, err
+		}
+		frames++
+	}
+	return animationBytes(1+int(uint24(vp8x[4:])), 1+int(uint24(vp8x[7:])), frames, limits)
+}
+
+// decodeAnimatedWebP decodes a VP8X WebP with the animation flag by
+// rewrapping each ANMF frame as a standalone WebP for x/image/webp.
+func decodeAnimatedWebP(data []byte, limits Limits) (*Animation, error) {
+	if _, err := webpCost(data, limits); err != nil {
+		return nil, err
+	}
+	chunks, err := riffChunks(data[12:])
+	if err != nil {
+		return nil, fmt.Errorf("webp: %w", err)
+	}
+	const animationBit = 0x02
+	if len(chunks) == 0 || chunks[0].id != "VP8X" || len(chunks[0].data) < 10 ||
+		chunks[0].data[0]&animationBit == 0 {
+		return nil, nil
+	}
+	vp8x := chunks[0].data
+
+	loops := 0
+	for _, ch := range chunks {
+		if ch.id == "ANIM" && len(ch.data) >= 6 {
+			loops = int(binary.LittleEndian.Uint16(ch.data[4:]))
+		}
+	}
+	c, err := newCompositor(1+int(uint24(vp8x[4:])), 1+int(uint24(vp8x[7:])), loops, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ch := range chunks {
+		if ch.id != "ANMF" {
+			continue
+		}
+		if len(ch.data) < 16 {
+			return nil, errors.New("webp: short ANMF chunk")
+		}
+		x, y := 2*int(uint24(ch.data[0:])), 2*int(uint24(ch.data[3:]))
+		w, h := 1+int(uint24(ch.data[6:])), 1+int(uint24(ch.data[9:]))
+		rect := image.Rect(x, y, x+w, y+h)
+		if !rect.In(c.canvas.Bounds()) {
+			return nil, fmt.Errorf("webp: frame %d outside the canvas", len(c.anim.Frames))
+		}
+		delay := time.Duration(uint24(ch.data[12:])) * time.Millisecond
+		flags := ch.data[15]
+
+		img, err := webp.Decode(bytes.NewReader(standaloneWebP(ch.data[16:], w, h)))
+		if err != nil {
+			return nil, fmt.Errorf("webp: frame %d: %w", len(c.anim.Frames), err)
+		}
+
+		c.draw(rect, img, flags&0x02 == 0)
+		if err := c.emit(delay); err != nil {
+			return nil, err
+		}
+		if flags&0x01 != 0 {
+			c.clear(rect)
+		}
+	}
+	return c.result()
+}
+
+// standaloneWebP wraps an ANMF frame's bitstream chunks in a RIFF header,
+// adding a VP8X chunk when the frame carries a separate alpha plane.
+func standaloneWebP(frame []byte, width, height int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if bytes.HasPrefix(frame, []byte("ALPH")) {
+		const alphaBit = 0x10
+		vp8x := make([]byte, 10)
+		vp8x[0] = alphaBit
+		putUint24(vp8x[4:], uint32(width-1))
+		putUint24(vp8x[7:], uint32(height-1))
+		body.WriteString("VP8X")
+		binary.Write(&body, binary.LittleEndian, uint32(len(vp8x)))
+		body.Write(vp8x)
+	}
+	body.Write(frame)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+type riffChunk struct {
+	id   string
+	data []byte
+}
+
+func riffChunks(b []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(b) >= 8 {
+		n := int(binary.LittleEndian.Uint32(b[4:]))
+		if n < 0 || n > len(b)-8 {
+			return nil, errors.New("truncated chunk")
+		}
+		chunks = append(chunks, riffChunk{id: string(b[:4]), data: b[8 : 8+n]})
+		b = b[min(len(b), 8+n+n&1):]
+	}
+	return chunks, nil
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
@@ -0,0 +1,301 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+)
+
+// pixel returns the BGRA bytes at (x, y) of a frame.
+func pixel(frame []byte, width, x, y int) [4]byte {
+	i := (y*width + x) * 4
+	return [4]byte(frame[i : i+4])
+}
+
+func TestDecodeAnimatedGIF(t *testing.T) {
+	pal := color.Palette{color.Transparent, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}}
+	red := image.NewPaletted(image.Rect(0, 0, 4, 4), pal)
+	for i := range red.Pix {
+		red.Pix[i] = 1
+	}
+	blue := image.NewPaletted(image.Rect(2, 2, 4, 4), pal)
+	for i := range blue.Pix {
+		blue.Pix[i] = 2
+	}
+
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:     []*image.Paletted{red, blue, blue},
+		Delay:     []int{5, 0, 7},
+		Disposal:  []byte{gif.DisposalBackground, gif.DisposalNone, gif.DisposalNone},
+		LoopCount: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := DecodeAnimation(buf.Bytes(), Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim == nil || len(anim.Frames) != 3 {
+		t.Fatalf("got %v, want 3 frames", anim)
+	}
+	if anim.LoopCount != 3 {
+		t.Errorf("LoopCount = %d, want 3", anim.LoopCount)
+	}
+	wantDelays := []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 70 * time.Millisecond}
+	for i, d := range anim.Delays {
+		if d != wantDelays[i] {
+			t.Errorf("Delays[%d] = %v, want %v", i, d, wantDelays[i])
+		}
+	}
+
+	if p := pixel(anim.Frames[0], 4, 0, 0); p != [4]byte{0, 0, 255, 255} {
+		t.Errorf("frame 0 (0,0) = %v, want red", p)
+	}
+	// The first frame was disposed to background, so only the blue patch
+	// remains.
+	if p := pixel(anim.Frames[1], 4, 0, 0); p != [4]byte{} {
+		t.Errorf("frame 1 (0,0) = %v, want transparent", p)
+	}
+	if p := pixel(anim.Frames[1], 4, 3, 3); p != [4]byte{255, 0, 0, 255} {
+		t.Errorf("frame 1 (3,3) = %v, want blue", p)
+	}
+}
+
+func TestDecodeAnimationIgnoresStills(t *testing.T) {
+	for _, data := range [][]byte{encodePNG(t, 2, 2), readTestFile(t, "../../test.webp")} {
+		anim, err := DecodeAnimation(data, Limits{})
+		if anim != nil || err != nil {
+			t.Errorf("DecodeAnimation(still) = %v, %v; want nil, nil", anim, err)
+		}
+	}
+}
+
+func readTestFile(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Skip(err)
+	}
+	return data
+}
+
+// buildAPNG assembles an APNG from full-size frames using image/png for
+// the pixel data.
+func buildAPNG(t *testing.T, frames []image.Image, blend []bool) []byte {
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	for i, img := range frames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		var idat []byte
+		b := buf.Bytes()[len(pngSignature):]
+		for len(b) >= 12 {
+			n := int(binary.BigEndian.Uint32(b))
+			switch string(b[4:8]) {
+			case "IHDR":
+				if i == 0 {
+					writePNGChunk(&out, "IHDR", b[8:8+n])
+					actl := binary.BigEndian.AppendUint32(nil, uint32(len(frames)))
+					writePNGChunk(&out, "acTL", binary.BigEndian.AppendUint32(actl, 0))
+				}
+			case "IDAT":
+				idat = append(idat, b[8:8+n]...)
+			}
+			b = b[12+n:]
+		}
+
+		size := img.Bounds().Size()
+		fctl := binary.BigEndian.AppendUint32(nil, uint32(2*i))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(size.X))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(size.Y))
+		fctl = append(fctl, make([]byte, 8)...)
+		fctl = binary.BigEndian.AppendUint16(fctl, 1)
+		fctl = binary.BigEndian.AppendUint16(fctl, 4)
+		fctl = append(fctl, 0, 0)
+		if blend[i] {
+			fctl[25] = 1
+		}
+		writePNGChunk(&out, "fcTL", fctl)
+		if i == 0 {
+			writePNGChunk(&out, "IDAT", idat)
+		} else {
+			// fdAT carries a sequence number ahead of the data.
+			writePNGChunk(&out, "fdAT", append(binary.BigEndian.AppendUint32(nil, uint32(2*i+1)), idat...))
+		}
+	}
+	writePNGChunk(&out, "IEND", nil)
+	return out.Bytes()
+}
+
+func TestDecodeAPNG(t *testing.T) {
+	red := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	half := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < len(red.Pix); i += 4 {
+		copy(red.Pix[i:], []byte{255, 0, 0, 255})
+		copy(half.Pix[i:], []byte{0, 0, 255, 128})
+	}
+	// Keep image/png from writing the first frame as opaque RGB, which
+	// would not match the RGBA data of the others.
+	red.Pix[3] = 254
+
+	anim, err := DecodeAnimation(buildAPNG(t, []image.Image{red, half, half}, []bool{false, true, false}), Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim == nil || len(anim.Frames) != 3 {
+		t.Fatalf("got %v, want 3 frames", anim)
+	}
+	if anim.Delays[0] != 250*time.Millisecond || anim.LoopCount != 0 {
+		t.Errorf("delay %v loops %d, want 250ms and 0", anim.Delays[0], anim.LoopCount)
+	}
+	// Blended: half blue over red. Replaced: half blue, premultiplied.
+	if p := pixel(anim.Frames[1], 2, 1, 1); p != [4]byte{128, 0, 127, 255} {
+		t.Errorf("blended pixel = %v", p)
+	}
+	if p := pixel(anim.Frames[2], 2, 1, 1); p != [4]byte{128, 0, 0, 128} {
+		t.Errorf("replaced pixel = %v", p)
+	}
+}
+
+// buildAnimatedWebP returns an animated WebP of the given number of
+// frames, each a copy of still, a VP8X WebP, and its size.
+func buildAnimatedWebP(t *testing.T, still []byte, frames int) (data []byte, width, height int) {
+	chunks, err := riffChunks(still[12:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp8x := chunks[0].data
+	width, height = 1+int(uint24(vp8x[4:])), 1+int(uint24(vp8x[7:]))
+
+	// ANMF frames carrying the still's ALPH and VP8 chunks.
+	var frame []byte
+	for _, ch := range chunks[1:] {
+		if ch.id == "ALPH" || ch.id == "VP8 " {
+			frame = append(frame, ch.id...)
+			frame = binary.LittleEndian.AppendUint32(frame, uint32(len(ch.data)))
+			frame = append(frame, ch.data...)
+			if len(ch.data)%2 == 1 {
+				frame = append(frame, 0)
+			}
+		}
+	}
+	anmf := make([]byte, 16)
+	putUint24(anmf[6:], uint32(width-1))
+	putUint24(anmf[9:], uint32(height-1))
+	putUint24(anmf[12:], 40)
+	anmf[15] = 0x02 // replace rather than blend over the previous frame
+	anmf = append(anmf, frame...)
+
+	body := []byte("WEBP")
+	appendChunk := func(id string, data []byte) {
+		body = append(body, id...)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+		body = append(body, data...)
+	}
+	header := make([]byte, 10)
+	header[0] = 0x02 | 0x10
+	copy(header[4:], vp8x[4:10])
+	appendChunk("VP8X", header)
+	appendChunk("ANIM", make([]byte, 6))
+	for range frames {
+		appendChunk("ANMF", anmf)
+	}
+	data = append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(data, body...), width, height
+}
+
+func TestDecodeAnimatedWebP(t *testing.T) {
+	still := readTestFile(t, "../../test.webp")
+	data, width, height := buildAnimatedWebP(t, still, 2)
+
+	anim, err := DecodeAnimation(data, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim == nil || len(anim.Frames) != 2 || anim.Width != width || anim.Height != height {
+		t.Fatalf("got %v, want 2 frames of %dx%d", anim, width, height)
+	}
+	want, _, _, err := DecodeImageToBGRA(still, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(anim.Frames[1], want) {
+		t.Error("animated frame differs from the decoded still")
+	}
+	if anim.Delays[0] != 40*time.Millisecond {
+		t.Errorf("delay = %v, want 40ms", anim.Delays[0])
+	}
+}
+
+func TestRingBufferUpdateStopsAtNewerFrame(t *testing.T) {
+	rb := NewRingBuffer()
+	rb.WriteSeq(FrameInfo{Seq: 1}, getBuffer(4), 1, 1)
+	if !rb.Update(1, []byte{1, 2, 3, 4}, 1, 1) {
+		t.Fatal("Update rejected while its frame is the newest")
+	}
+	rb.WriteSeq(FrameInfo{Seq: 2}, getBuffer(4), 1, 1)
+	if rb.Update(1, []byte{1, 2, 3, 4}, 1, 1) {
+		t.Error("Update accepted after a newer frame")
+	}
+}
+
+func TestServerLoopsAnimationUntilNewerFrame(t *testing.T) {
+	pal := color.Palette{color.Black, color.White}
+	frames := []*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 2, 2), pal),
+		image.NewPaletted(image.Rect(0, 0, 2, 2), pal),
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &gif.GIF{Image: frames, Delay: []int{2, 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(config.DefaultConfig())
+	writes := func() uint64 {
+		s.ringBuffer.mu.RLock()
+		defer s.ringBuffer.mu.RUnlock()
+		return s.ringBuffer.writeIdx
+	}
+
+	if err := s.processFrame(decodeJob{conn: newPublisherConn(1, nil, s.limits),
+		info: FrameInfo{Seq: 1, Stream: defaultStream, Received: time.Now()}, data: buf.Bytes()}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for writes() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("animation advanced to %d writes, want 4", writes())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := s.processFrame(decodeJob{conn: newPublisherConn(1, nil, s.limits),
+		info: FrameInfo{Seq: 2, Stream: defaultStream, Received: time.Now()}, data: encodePNG(t, 3, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	stopped := writes()
+	time.Sleep(100 * time.Millisecond)
+	if n := writes(); n != stopped {
+		t.Errorf("animation kept playing after a newer frame: %d writes, want %d", n, stopped)
+	}
+	frame, _ := s.ringBuffer.Acquire()
+	if frame.Width != 3 {
+		t.Errorf("latest frame width = %d, want 3", frame.Width)
+	}
+	frame.Release()
+
+	close(s.stopCh)
+	s.wg.Wait()
+}
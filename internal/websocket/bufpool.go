@@ -0,0 +1,54 @@
+package websocket
+
+import "math/bits"
+
+// Buffers for payloads and decoded frames are recycled through free lists
+// of power-of-two size classes. A free list is a buffered channel rather
+// than a sync.Pool so that returning a slice never allocates.
+const (
+	minBufferClass  = 12 // 4 KiB
+	maxBufferClass  = 26 // 64 MiB
+	buffersPerClass = 8
+)
+
+var bufferClasses [maxBufferClass - minBufferClass + 1]chan []byte
+
+func init() {
+	for i := range bufferClasses {
+		bufferClasses[i] = make(chan []byte, buffersPerClass)
+	}
+}
+
+// getBuffer returns a slice of length n, reusing a released buffer of
+// the same size class when one is available.
+func getBuffer(n int) []byte {
+	if n <= 0 {
+		return nil
+	}
+	class := max(bits.Len(uint(n-1)), minBufferClass)
+	if class > maxBufferClass {
+		return make([]byte, n)
+	}
+	select {
+	case b := <-bufferClasses[class-minBufferClass]:
+		return b[:n]
+	default:
+		return make([]byte, n, 1<<class)
+	}
+}
+
+// putBuffer hands b back for reuse. The caller must not touch b
+// afterwards.
+func putBuffer(b []byte) {
+	if cap(b) < 1<<minBufferClass {
+		return
+	}
+	class := bits.Len(uint(cap(b))) - 1
+	if class > maxBufferClass {
+		return
+	}
+	select {
+	case bufferClasses[class-minBufferClass] <- b[:0]:
+	default:
+	}
+}
@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestBufferPoolReuse(t *testing.T) {
+	b := getBuffer(5000)
+	if len(b) != 5000 || cap(b) != 8192 {
+		t.Fatalf("getBuffer(5000): len %d cap %d, want 5000 8192", len(b), cap(b))
+	}
+	b[0] = 42
+	putBuffer(b)
+
+	c := getBuffer(8192)
+	if &c[0] != &b[0] {
+		t.Error("getBuffer did not reuse a released buffer of the same class")
+	}
+	putBuffer(c)
+
+	// Buffers smaller than the minimum class are dropped, not pooled.
+	putBuffer(make([]byte, 100))
+	if d := getBuffer(10); cap(d) != 1<<minBufferClass {
+		t.Errorf("getBuffer(10) cap = %d, want %d", cap(d), 1<<minBufferClass)
+	}
+}
+
+func TestPacketReader(t *testing.T) {
+	var wire bytes.Buffer
+	for _, payload := range []string{"first", "second"} {
+		binary.Write(&wire, binary.LittleEndian, uint32(len(payload)))
+		wire.WriteString(payload)
+	}
+	binary.Write(&wire, binary.LittleEndian, uint32(FrameVP8)<<24|3)
+	wire.WriteString("vp8")
+	binary.Write(&wire, binary.LittleEndian, uint32(FrameKeyframeRequest)<<24)
+	binary.Write(&wire, binary.LittleEndian, uint32(MaxPacketSize+1))
+
+	pr := NewPacketReader(&wire)
+	for _, want := range []struct {
+		typ  byte
+		data string
+	}{{FrameImage, "first"}, {FrameImage, "second"}, {FrameVP8, "vp8"}, {FrameKeyframeRequest, ""}} {
+		typ, got, err := pr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != want.typ || string(got) != want.data {
+			t.Errorf("Next() = %d %q, want %d %q", typ, got, want.typ, want.data)
+		}
+		putBuffer(got)
+	}
+	if _, _, err := pr.Next(); err == nil {
+		t.Error("oversized frame accepted")
+	}
+}
+
+// repeatReader replays the same bytes forever.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+// BenchmarkReceivePath covers the steady state of a connection: reading a
+// packet, converting a decoded image into a pooled frame and publishing it
+// to the ring buffer. It should report 0 allocs/op.
+func BenchmarkReceivePath(b *testing.B) {
+	const width, height = 1280, 720
+	payload := make([]byte, 200*1024)
+	wire := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+	wire = append(wire, payload...)
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	pr := NewPacketReader(&repeatReader{data: wire})
+	rb := NewRingBuffer()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, data, err := pr.Next()
+		if err != nil {
+			b.Fatal(err)
+		}
+		bgra := getBuffer(width * height * 4)
+		convertToBGRA(bgra, img)
+		putBuffer(data)
+		rb.WriteSeq(FrameInfo{Seq: uint64(i + 1)}, bgra, width, height)
+	}
+}
@@ -0,0 +1,381 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/example/bidirect/internal/qoi"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// Codec decodes one image format. Payloads are matched to a codec by the
+// magic bytes they start with, never by trying decoders in turn.
+type Codec struct {
+	// Name identifies the format in errors and in stream allow-lists.
+	Name string
+	// Magic is the prefix that identifies the format. A '?' matches any
+	// byte.
+	Magic        string
+	DecodeConfig func(io.Reader) (image.Config, error)
+	Decode       func(io.Reader) (image.Image, error)
+	// DecodeAnimation, if set, decodes payloads holding an animation and
+	// returns nil without an error for still images.
+	DecodeAnimation func(data []byte, limits Limits) (*Animation, error)
+	// AnimationCost, if set, returns the bytes the frames DecodeAnimation
+	// returns for data take, read from its headers so that they can be
+	// reserved before decoding.
+	AnimationCost func(data []byte, limits Limits) (int64, error)
+}
+
+// ErrUnknownFormat is returned for payloads no registered codec matches.
+var ErrUnknownFormat = errors.New("unknown image format")
+
+// FormatError is a failure to decode a payload of a detected format.
+type FormatError struct {
+	Format string
+	Err    error
+}
+
+func (e *FormatError) Error() string {
+	return "decoding " + e.Format + ": " + e.Err.Error()
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   []*Codec
+)
+
+// RegisterCodec makes a format decodable. Several codecs may share a name
+// when a format has more than one magic prefix.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs = append(codecs, &c)
+}
+
+// SniffCodec returns the codec whose magic bytes data starts with.
+func SniffCodec(data []byte) (*Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, c := range codecs {
+		if matchMagic(c.Magic, data) {
+			return c, nil
+		}
+	}
+	return nil, ErrUnknownFormat
+}
+
+func matchMagic(magic string, data []byte) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CodecNames returns the names of the registered formats, sorted.
+func CodecNames() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	var names []string
+	for _, c := range codecs {
+		if !slices.Contains(names, c.Name) {
+			names = append(names, c.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ParseFormats parses a comma-separated list of registered format names.
+func ParseFormats(list string) ([]string, error) {
+	known := CodecNames()
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("unknown format %q (known: %s)", name, strings.Join(known, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// checkSize reads the size data claims from its header and checks it
+// against limits before anything is decoded.
+func (c *Codec) checkSize(data []byte, limits Limits) (image.Config, error) {
+	cfg, err := c.DecodeConfig(bytes.NewReader(data))
+	if err == nil {
+		err = limits.CheckSize(cfg.Width, cfg.Height)
+	}
+	if err != nil {
+		return cfg, &FormatError{Format: c.Name, Err: err}
+	}
+	return cfg, nil
+}
+
+// decodeBGRA decodes data into premultiplied BGRA from the buffer pool.
+func (c *Codec) decodeBGRA(data []byte) ([]byte, int, int, error) {
+	img, err := c.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, &FormatError{Format: c.Name, Err: err}
+	}
+
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+
+	bgra := getBuffer(width * height * 4)
+	convertToBGRA(bgra, img)
+	return bgra, width, height, nil
+}
+
+// animationCost returns the bytes to reserve before decodeAnimation, at
+// least one frame of cfg's size.
+func (c *Codec) animationCost(data []byte, cfg image.Config, limits Limits) (int64, error) {
+	cost := frameBytes(cfg.Width, cfg.Height)
+	if c.AnimationCost == nil {
+		return cost, nil
+	}
+	n, err := c.AnimationCost(data, limits)
+	if err != nil {
+		return 0, &FormatError{Format: c.Name, Err: err}
+	}
+	return max(cost, n), nil
+}
+
+func (c *Codec) decodeAnimation(data []byte, limits Limits) (*Animation, error) {
+	if c.DecodeAnimation == nil {
+		return nil, nil
+	}
+	anim, err := c.DecodeAnimation(data, limits)
+	if err != nil {
+		return nil, &FormatError{Format: c.Name, Err: err}
+	}
+	return anim, nil
+}
+
+func init() {
+	RegisterCodec(Codec{
+		Name:            "webp",
+		Magic:           "RIFF????WEBP",
+		DecodeConfig:    webp.DecodeConfig,
+		Decode:          webp.Decode,
+		DecodeAnimation: decodeAnimatedWebP,
+		AnimationCost:   webpCost,
+	})
+	RegisterCodec(Codec{
+		Name:            "png",
+		Magic:           string(pngSignature),
+		DecodeConfig:    png.DecodeConfig,
+		Decode:          png.Decode,
+		DecodeAnimation: decodeAPNG,
+		AnimationCost:   apngCost,
+	})
+	RegisterCodec(Codec{
+		Name:         "jpeg",
+		Magic:        "\xff\xd8",
+		DecodeConfig: jpeg.DecodeConfig,
+		Decode:       jpeg.Decode,
+	})
+	for _, magic := range []string{"GIF87a", "GIF89a"} {
+		RegisterCodec(Codec{
+			Name:            "gif",
+			Magic:           magic,
+			DecodeConfig:    gif.DecodeConfig,
+			Decode:          gif.Decode,
+			DecodeAnimation: decodeGIF,
+			AnimationCost:   gifCost,
+		})
+	}
+	RegisterCodec(Codec{
+		Name:         "bmp",
+		Magic:        "BM",
+		DecodeConfig: bmp.DecodeConfig,
+		Decode:       bmp.Decode,
+	})
+	for _, magic := range []string{"II*\x00", "MM\x00*"} {
+		RegisterCodec(Codec{
+			Name:         "tiff",
+			Magic:        magic,
+			DecodeConfig: tiff.DecodeConfig,
+			Decode:       tiff.Decode,
+		})
+	}
+	RegisterCodec(Codec{
+		Name:         "qoi",
+		Magic:        qoi.Magic,
+		DecodeConfig: qoi.DecodeConfig,
+		Decode:       qoi.Decode,
+	})
+	RegisterCodec(Codec{
+		Name:         "raw",
+		Magic:        RawMagic,
+		DecodeConfig: decodeRawConfig,
+		Decode:       decodeRaw,
+	})
+}
+
+// Raw frames are uncompressed pixels behind a 16-byte header: RawMagic,
+// the little-endian uint32 width and height, a RawLayout byte and three
+// reserved zero bytes.
+const RawMagic = "BDRW"
+
+// RawLayout is the pixel layout of a raw frame.
+type RawLayout byte
+
+const (
+	// RawBGRA is premultiplied BGRA, the receiver's own layout.
+	RawBGRA RawLayout = iota
+	// RawRGBA is straight-alpha RGBA.
+	RawRGBA
+	// RawGray is 8-bit grayscale.
+	RawGray
+)
+
+const rawHeaderSize = 16
+
+func (l RawLayout) bytesPerPixel() int {
+	switch l {
+	case RawBGRA, RawRGBA:
+		return 4
+	case RawGray:
+		return 1
+	}
+	return 0
+}
+
+// EncodeRaw prepends a raw frame header to pix.
+func EncodeRaw(pix []byte, width, height int, layout RawLayout) []byte {
+	out := make([]byte, rawHeaderSize, rawHeaderSize+len(pix))
+	copy(out, RawMagic)
+	binary.LittleEndian.PutUint32(out[4:], uint32(width))
+	binary.LittleEndian.PutUint32(out[8:], uint32(height))
+	out[12] = byte(layout)
+	return append(out, pix...)
+}
+
+// readRawHeader reads a raw frame's header. Readers that know how much
+// they hold, like the bytes.Reader payloads are decoded from, must hold
+// exactly the pixels the header claims.
+func readRawHeader(r io.Reader) (image.Config, RawLayout, error) {
+	var header [rawHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return image.Config{}, 0, errors.New("short header")
+	}
+	width := int(binary.LittleEndian.Uint32(header[4:]))
+	height := int(binary.LittleEndian.Uint32(header[8:]))
+	layout := RawLayout(header[12])
+	if layout.bytesPerPixel() == 0 {
+		return image.Config{}, 0, fmt.Errorf("unknown pixel layout %d", layout)
+	}
+	if width <= 0 || height <= 0 {
+		return image.Config{}, 0, fmt.Errorf("invalid size %dx%d", width, height)
+	}
+	size, err := rawPixelBytes(width, height, layout)
+	if err != nil {
+		return image.Config{}, 0, err
+	}
+	if l, ok := r.(interface{ Len() int }); ok && l.Len() != size {
+		return image.Config{}, 0, fmt.Errorf("%dx%d frame needs %d bytes of pixels, got %d", width, height, size, l.Len())
+	}
+	model := color.RGBAModel
+	switch layout {
+	case RawRGBA:
+		model = color.NRGBAModel
+	case RawGray:
+		model = color.GrayModel
+	}
+	return image.Config{ColorModel: model, Width: width, Height: height}, layout, nil
+}
+
+// rawPixelBytes returns the size of a frame's pixels, failing where it
+// would overflow.
+func rawPixelBytes(width, height int, layout RawLayout) (int, error) {
+	bpp := layout.bytesPerPixel()
+	if width > math.MaxInt/bpp/height {
+		return 0, fmt.Errorf("size %dx%d too large", width, height)
+	}
+	return width * height * bpp, nil
+}
+
+func decodeRawConfig(r io.Reader) (image.Config, error) {
+	cfg, _, err := readRawHeader(r)
+	return cfg, err
+}
+
+func decodeRaw(r io.Reader) (image.Image, error) {
+	cfg, layout, err := readRawHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	rect := image.Rect(0, 0, cfg.Width, cfg.Height)
+	stride := cfg.Width * layout.bytesPerPixel()
+	size := stride * cfg.Height
+	var pix []byte
+	if _, ok := r.(interface{ Len() int }); ok {
+		// readRawHeader checked that r holds exactly the pixels.
+		pix = make([]byte, size)
+		_, err = io.ReadFull(r, pix)
+	} else {
+		// Readers of unknown length are read one byte past the pixels
+		// rather than trusted with the allocation.
+		pix, err = io.ReadAll(io.LimitReader(r, int64(size)+1))
+		if err == nil && len(pix) != size {
+			err = fmt.Errorf("want %d bytes of pixels, got %d", size, len(pix))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch layout {
+	case RawRGBA:
+		return &image.NRGBA{Pix: pix, Stride: stride, Rect: rect}, nil
+	case RawGray:
+		return &image.Gray{Pix: pix, Stride: stride, Rect: rect}, nil
+	}
+	return &bgraImage{Pix: pix, Rect: rect}, nil
+}
+
+// bgraImage is premultiplied BGRA pixels, converted by a plain copy.
+type bgraImage struct {
+	Pix  []byte
+	Rect image.Rectangle
+}
+
+func (b *bgraImage) ColorModel() color.Model { return color.RGBAModel }
+
+func (b *bgraImage) Bounds() image.Rectangle { return b.Rect }
+
+func (b *bgraImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(b.Rect) {
+		return color.RGBA{}
+	}
+	i := ((y-b.Rect.Min.Y)*b.Rect.Dx() + x - b.Rect.Min.X) * 4
+	return color.RGBA{R: b.Pix[i+2], G: b.Pix[i+1], B: b.Pix[i], A: b.Pix[i+3]}
+}
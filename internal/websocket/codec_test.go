@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// testImage is a 2x1 image with an opaque red and a half-transparent
+// white pixel.
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 128})
+	return img
+}
+
+func TestDecodeFormats(t *testing.T) {
+	var bmpData, tiffData, jpegData bytes.Buffer
+	if err := bmp.Encode(&bmpData, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tiff.Encode(&tiffData, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	qoiData := []byte("qoif\x00\x00\x00\x02\x00\x00\x00\x01\x04\x00" +
+		"\xff\xff\x00\x00\xff\xff\xff\xff\xff\x80" +
+		"\x00\x00\x00\x00\x00\x00\x00\x01")
+	want := []byte{0, 0, 255, 255, 128, 128, 128, 128}
+
+	tests := []struct {
+		format string
+		data   []byte
+		want   []byte
+	}{
+		{"png", encodePNG(t, 2, 1), make([]byte, 8)},
+		{"jpeg", jpegData.Bytes(), nil},
+		// BMP keeps no alpha.
+		{"bmp", bmpData.Bytes(), []byte{0, 0, 255, 255, 255, 255, 255, 255}},
+		{"tiff", tiffData.Bytes(), want},
+		{"qoi", qoiData, want},
+		{"raw", EncodeRaw(want, 2, 1, RawBGRA), want},
+		{"raw", EncodeRaw(testImage().Pix, 2, 1, RawRGBA), want},
+		{"raw", EncodeRaw([]byte{0, 255}, 2, 1, RawGray), []byte{0, 0, 0, 255, 255, 255, 255, 255}},
+	}
+	for _, tt := range tests {
+		codec, err := SniffCodec(tt.data)
+		if err != nil || codec.Name != tt.format {
+			t.Errorf("SniffCodec(%s) = %v, %v", tt.format, codec, err)
+			continue
+		}
+		bgra, width, height, err := DecodeImageToBGRA(tt.data, Limits{})
+		if err != nil {
+			t.Errorf("%s: %v", tt.format, err)
+			continue
+		}
+		if width != 2 || height != 1 {
+			t.Errorf("%s: size = %dx%d, want 2x1", tt.format, width, height)
+		}
+		if tt.want != nil && !bytes.Equal(bgra, tt.want) {
+			t.Errorf("%s: pixels = %v, want %v", tt.format, bgra, tt.want)
+		}
+	}
+}
+
+func TestSniffCodec(t *testing.T) {
+	for data, want := range map[string]string{
+		"RIFF\x00\x00\x00\x00WEBPVP8 ": "webp",
+		"GIF87a":                       "gif",
+		"GIF89a":                       "gif",
+		"\xff\xd8\xff\xe0":             "jpeg",
+		"MM\x00*\x00\x00\x00\x08":      "tiff",
+		"II*\x00\x08\x00\x00\x00":      "tiff",
+	} {
+		if codec, err := SniffCodec([]byte(data)); err != nil || codec.Name != want {
+			t.Errorf("SniffCodec(%q) = %v, %v; want %s", data, codec, err, want)
+		}
+	}
+	for _, data := range []string{"", "GIF", "RIFF\x00\x00\x00\x00WAVE", "<svg"} {
+		if _, err := SniffCodec([]byte(data)); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("SniffCodec(%q) = %v, want ErrUnknownFormat", data, err)
+		}
+	}
+}
+
+func TestDecodeErrorNamesFormat(t *testing.T) {
+	truncated := encodePNG(t, 8, 8)[:40]
+	_, _, _, err := DecodeImageToBGRA(truncated, Limits{})
+	var fe *FormatError
+	if !errors.As(err, &fe) || fe.Format != "png" || !strings.HasPrefix(err.Error(), "decoding png: ") {
+		t.Errorf("truncated PNG: err = %v, want a png FormatError", err)
+	}
+
+	_, _, _, err = DecodeImageToBGRA(EncodeRaw(make([]byte, 7), 2, 1, RawBGRA), Limits{})
+	if !errors.As(err, &fe) || fe.Format != "raw" {
+		t.Errorf("short raw frame: err = %v, want a raw FormatError", err)
+	}
+}
+
+func TestRawFrameMustHoldItsPixels(t *testing.T) {
+	// A bare header claiming 4096x4096 BGRA is rejected without the 64 MB
+	// it claims being allocated.
+	header := EncodeRaw(nil, 4096, 4096, RawBGRA)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, _, err := DecodeImageToBGRA(header, Limits{})
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Fatal("decoded a raw header without pixels")
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes for a truncated raw frame", n)
+	}
+
+	// Without limits, a size whose pixels overflow is an error, not a panic.
+	huge := EncodeRaw(nil, 0xffffffff, 0xffffffff, RawBGRA)
+	if _, _, _, err := DecodeImageToBGRA(huge, Limits{}); err == nil {
+		t.Error("decoded a raw frame too large to address")
+	}
+
+	long := EncodeRaw(make([]byte, 9), 2, 1, RawBGRA)
+	if _, _, _, err := DecodeImageToBGRA(long, Limits{}); err == nil {
+		t.Error("decoded a raw frame with trailing bytes")
+	}
+
+	// Readers of unknown length are checked once read.
+	if _, err := decodeRaw(io.MultiReader(bytes.NewReader(EncodeRaw(make([]byte, 7), 2, 1, RawBGRA)))); err == nil {
+		t.Error("decoded a short raw frame from a plain reader")
+	}
+	if _, err := decodeRaw(io.MultiReader(bytes.NewReader(EncodeRaw(make([]byte, 8), 2, 1, RawBGRA)))); err != nil {
+		t.Errorf("raw frame from a plain reader: %v", err)
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	names, err := ParseFormats(" PNG, qoi,,raw")
+	if err != nil || strings.Join(names, ",") != "png,qoi,raw" {
+		t.Errorf("ParseFormats = %v, %v", names, err)
+	}
+	if _, err := ParseFormats("png,svg"); err == nil || !strings.Contains(err.Error(), "svg") {
+		t.Errorf("ParseFormats(svg) = %v, want an error naming svg", err)
+	}
+}
+
+func TestStreamFormatAllowList(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Formats = map[string][]string{"": {"png"}, "cam": {"raw"}}
+	s := NewServer(cfg)
+
+	process := func(stream string, data []byte) error {
+		return s.processFrame(decodeJob{conn: newPublisherConn(1, nil, s.limits),
+			info: FrameInfo{Seq: s.nextSeq(), Stream: stream, Received: time.Now()}, data: data})
+	}
+	raw := EncodeRaw(make([]byte, 4), 1, 1, RawBGRA)
+	if err := process(defaultStream, encodePNG(t, 1, 1)); err != nil {
+		t.Errorf("png on default stream: %v", err)
+	}
+	if err := process(defaultStream, raw); err == nil || !strings.Contains(err.Error(), "raw") {
+		t.Errorf("raw on default stream = %v, want a rejection naming raw", err)
+	}
+	if err := process("cam", raw); err != nil {
+		t.Errorf("raw on cam: %v", err)
+	}
+	if err := process("cam", encodePNG(t, 1, 1)); err == nil {
+		t.Error("png accepted on cam")
+	}
+	if n := s.Metrics().FormatsRejected.Load(); n != 2 {
+		t.Errorf("FormatsRejected = %d, want 2", n)
+	}
+}
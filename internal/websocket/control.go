@@ -0,0 +1,237 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/example/bidirect/internal/chroma"
+	"github.com/example/bidirect/internal/filter"
+	"github.com/example/bidirect/internal/logging"
+	"github.com/example/bidirect/internal/mask"
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/transition"
+	"golang.org/x/net/websocket"
+)
+
+// Control is the JSON payload of a FrameControl packet. It changes how the
+// receiver presents the stream; fields left out keep their current value.
+// The chroma key is merged field by field, so {"chroma_key":{"spill":0.2}}
+// only changes the spill; a null chroma key disables keying. Mask selects
+// a built-in shape; image masks can only be set on the receiver. Filters
+// replaces the filter pipeline of the stream the message arrives on. HUD
+// shows or hides the performance overlay. Transition and TransitionMS
+// change how the frames that follow on the stream the message arrives on
+// replace the one on screen; a FrameTransition packet overrides them for
+// a single frame. TransitionMS may not exceed transition.MaxDuration.
+type Control struct {
+	Fit          *scale.Mode        `json:"fit,omitempty"`
+	Filter       *scale.Filter      `json:"filter,omitempty"`
+	ChromaKey    *chroma.Key        `json:"chroma_key,omitempty"`
+	Mask         *mask.Shape        `json:"mask,omitempty"`
+	Filters      *filter.Pipeline   `json:"filters,omitempty"`
+	HUD          *bool              `json:"hud,omitempty"`
+	Transition   *transition.Effect `json:"transition,omitempty"`
+	TransitionMS *int               `json:"transition_ms,omitempty"`
+}
+
+// WriteControl sends c as a FrameControl packet.
+func WriteControl(ws *websocket.Conn, c Control) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return WriteTypedPacket(ws, FrameControl, data)
+}
+
+// Scaling returns how frames are scaled to the window.
+func (s *Server) Scaling() (scale.Mode, scale.Filter) {
+	s.controlMu.RLock()
+	defer s.controlMu.RUnlock()
+	return s.fit, s.filter
+}
+
+// SetScaling changes how frames are scaled to the window and has the
+// current frame presented again.
+func (s *Server) SetScaling(mode scale.Mode, filter scale.Filter) {
+	s.controlMu.Lock()
+	s.fit, s.filter = mode, filter
+	s.controlMu.Unlock()
+	s.ringBuffer.Notify()
+}
+
+// ChromaKey returns the chroma key applied to decoded frames.
+func (s *Server) ChromaKey() chroma.Key {
+	s.controlMu.RLock()
+	defer s.controlMu.RUnlock()
+	return s.chromaKey
+}
+
+// SetChromaKey changes the chroma key applied to frames decoded from now
+// on.
+func (s *Server) SetChromaKey(key chroma.Key) {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+	s.chromaKey = key
+}
+
+// Mask returns the mask applied to decoded frames, or nil.
+func (s *Server) Mask() *mask.Mask {
+	s.controlMu.RLock()
+	defer s.controlMu.RUnlock()
+	return s.shapeMask
+}
+
+// SetMask changes the mask applied to frames decoded from now on; nil
+// removes it.
+func (s *Server) SetMask(m *mask.Mask) {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+	s.shapeMask = m
+}
+
+// HUD reports whether the performance overlay is drawn over presented
+// frames.
+func (s *Server) HUD() bool {
+	s.controlMu.RLock()
+	defer s.controlMu.RUnlock()
+	return s.hud
+}
+
+// SetHUD shows or hides the performance overlay.
+func (s *Server) SetHUD(on bool) {
+	s.controlMu.Lock()
+	s.hud = on
+	s.controlMu.Unlock()
+	s.ringBuffer.Notify()
+}
+
+// Transition returns how frames received from now on replace the one on
+// screen, on streams without a transition of their own.
+func (s *Server) Transition() transition.Spec {
+	s.controlMu.RLock()
+	defer s.controlMu.RUnlock()
+	return s.transition
+}
+
+// SetTransition changes how frames received from now on replace the one
+// on screen, on streams without a transition of their own.
+func (s *Server) SetTransition(spec transition.Spec) {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+	s.transition = spec
+}
+
+// StreamTransition returns how frames received on stream from now on
+// replace the one on screen.
+func (s *Server) StreamTransition(stream string) transition.Spec {
+	s.controlMu.RLock()
+	defer s.controlMu.RUnlock()
+	return s.streamTransition(stream)
+}
+
+// streamTransition is StreamTransition with s.controlMu held.
+func (s *Server) streamTransition(stream string) transition.Spec {
+	if spec, ok := s.transitions[stream]; ok {
+		return spec
+	}
+	return s.transition
+}
+
+// LoadMask sets the mask from a built-in shape name or the path of a
+// grayscale image.
+func (s *Server) LoadMask(spec string) error {
+	m, err := mask.Parse(spec)
+	if err != nil {
+		return err
+	}
+	s.SetMask(m)
+	logging.Infof("Masking frames with %v", m)
+	return nil
+}
+
+// handleControl applies a FrameControl packet, or forwards it to the
+// stream's subscribers when relaying.
+func (s *Server) handleControl(stream string, data []byte) {
+	if s.relay != nil {
+		s.relay.PublishControl(stream, data)
+		return
+	}
+	defer putBuffer(data)
+	if err := s.applyControl(stream, data); err != nil {
+		logging.Errorf("Ignoring control message: %v", err)
+		return
+	}
+	// The fit, filter and HUD apply to the frame already on screen.
+	s.ringBuffer.Notify()
+}
+
+func (s *Server) applyControl(stream string, data []byte) error {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	// Decoding over a copy of the current key merges partial updates.
+	key := s.chromaKey
+	c := Control{ChromaKey: &key}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("decoding control message: %w", err)
+	}
+	if c.TransitionMS != nil {
+		ms := *c.TransitionMS
+		if ms < 0 || ms > int(transition.MaxDuration/time.Millisecond) {
+			return fmt.Errorf("transition_ms %d is not between 0 and %d", ms, transition.MaxDuration/time.Millisecond)
+		}
+	}
+
+	if c.Fit != nil || c.Filter != nil {
+		if c.Fit != nil {
+			s.fit = *c.Fit
+		}
+		if c.Filter != nil {
+			s.filter = *c.Filter
+		}
+		logging.Infof("Scaling frames with %v/%v", s.fit, s.filter)
+	}
+	if c.Filters != nil {
+		s.setFilters(stream, c.Filters)
+	}
+	if c.Mask != nil && *c.Mask != s.shapeMask.Shape() {
+		s.shapeMask = mask.New(*c.Mask)
+		logging.Infof("Masking frames with %v", s.shapeMask)
+	}
+	if c.HUD != nil && *c.HUD != s.hud {
+		s.hud = *c.HUD
+		logging.Infof("Performance HUD %s", onOff(s.hud))
+	}
+	if c.Transition != nil || c.TransitionMS != nil {
+		spec := s.streamTransition(stream)
+		if c.Transition != nil {
+			spec.Effect = *c.Transition
+		}
+		if c.TransitionMS != nil {
+			spec.Duration = time.Duration(*c.TransitionMS) * time.Millisecond
+		}
+		s.transitions[stream] = spec
+		logging.Infof("Transition for stream %q: %v", stream, spec)
+	}
+	if c.ChromaKey == nil {
+		key.Enabled = false
+	}
+	if key != s.chromaKey {
+		s.chromaKey = key
+		if key.Enabled {
+			logging.Infof("Chroma key %v (similarity %.2f, smoothness %.2f, spill %.2f)",
+				key.Color, key.Similarity, key.Smoothness, key.Spill)
+		} else {
+			logging.Infof("Chroma key disabled")
+		}
+	}
+	return nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,261 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/chroma"
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/mask"
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/transition"
+	"golang.org/x/net/websocket"
+)
+
+func TestApplyControl(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	if mode, filter := s.Scaling(); mode != scale.Fit || filter != scale.Bilinear {
+		t.Fatalf("Scaling = %v/%v, want fit/bilinear", mode, filter)
+	}
+
+	if err := s.applyControl(defaultStream, []byte(`{"fit":"fill"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if mode, filter := s.Scaling(); mode != scale.Fill || filter != scale.Bilinear {
+		t.Errorf("after fit=fill, Scaling = %v/%v, want fill/bilinear", mode, filter)
+	}
+
+	if err := s.applyControl(defaultStream, []byte(`{"filter":"lanczos"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if mode, filter := s.Scaling(); mode != scale.Fill || filter != scale.Lanczos {
+		t.Errorf("after filter=lanczos, Scaling = %v/%v, want fill/lanczos", mode, filter)
+	}
+
+	for _, bad := range []string{`{"fit":"zoom"}`, `not json`} {
+		if err := s.applyControl(defaultStream, []byte(bad)); err == nil {
+			t.Errorf("applyControl(%s) succeeded", bad)
+		}
+	}
+	if mode, _ := s.Scaling(); mode != scale.Fill {
+		t.Errorf("rejected control changed mode to %v", mode)
+	}
+}
+
+func TestApplyControlHUD(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	if s.HUD() {
+		t.Fatal("HUD enabled by default")
+	}
+	if err := s.applyControl(defaultStream, []byte(`{"hud":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.HUD() {
+		t.Error("hud=true did not show the HUD")
+	}
+	if err := s.applyControl(defaultStream, []byte(`{"fit":"fill"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.HUD() {
+		t.Error("control without hud hid the HUD")
+	}
+	if err := s.applyControl(defaultStream, []byte(`{"hud":false}`)); err != nil {
+		t.Fatal(err)
+	}
+	if s.HUD() {
+		t.Error("hud=false did not hide the HUD")
+	}
+}
+
+func TestApplyControlTransition(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	if s.StreamTransition(defaultStream).Enabled() {
+		t.Fatal("transitions enabled by default")
+	}
+	if err := s.applyControl(defaultStream, []byte(`{"transition":"slide"}`)); err != nil {
+		t.Fatal(err)
+	}
+	want := transition.Spec{Effect: transition.Slide, Duration: 400 * time.Millisecond}
+	if got := s.StreamTransition(defaultStream); got != want {
+		t.Errorf("after transition=slide: %v, want %v", got, want)
+	}
+	if err := s.applyControl(defaultStream, []byte(`{"transition_ms":250}`)); err != nil {
+		t.Fatal(err)
+	}
+	want.Duration = 250 * time.Millisecond
+	if got := s.StreamTransition(defaultStream); got != want {
+		t.Errorf("after transition_ms=250: %v, want %v", got, want)
+	}
+	// Other streams and the receiver's own setting are left alone.
+	if s.StreamTransition("other").Enabled() || s.Transition().Enabled() {
+		t.Errorf("transition leaked: other stream %v, receiver %v", s.StreamTransition("other"), s.Transition())
+	}
+	if err := s.applyControl(defaultStream, []byte(`{"transition":"fade"}`)); err == nil {
+		t.Error("unknown effect accepted")
+	}
+	for _, bad := range []string{`{"transition_ms":-1}`, `{"transition_ms":10001}`, `{"transition_ms":9223372036854775807}`} {
+		if err := s.applyControl(defaultStream, []byte(bad)); err == nil {
+			t.Errorf("applyControl(%s) succeeded", bad)
+		}
+	}
+	if got := s.StreamTransition(defaultStream); got != want {
+		t.Errorf("rejected durations changed the transition to %v", got)
+	}
+	if _, err := parseTransition([]byte{byte(transition.Slide), 0xff, 0xff, 0xff, 0xff}); err == nil {
+		t.Error("FrameTransition lasting 49 days accepted")
+	}
+}
+
+func TestFrameTransitionScope(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DecodeWorkers = 1
+	s := NewServer(cfg)
+	s.startDecoders()
+	defer func() {
+		close(s.stopCh)
+		s.wg.Wait()
+	}()
+	ts := httptest.NewServer(websocket.Handler(s.handleWebSocket))
+	defer ts.Close()
+	dial := func(stream string) *websocket.Conn {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?stream="+stream, "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ws.Close() })
+		return ws
+	}
+	// send writes a frame of a width unique to the test and returns the
+	// transition it reached the ring buffer with.
+	width := 0
+	send := func(ws *websocket.Conn) transition.Spec {
+		t.Helper()
+		width++
+		if err := WritePacket(ws, encodePNG(t, width, 1)); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if frame, ok := s.ringBuffer.AcquireLatest(); ok {
+				spec, w := frame.Transition, frame.Width
+				frame.Release()
+				if w == width {
+					return spec
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("frame %d never reached the ring buffer", width)
+		return transition.Spec{}
+	}
+	slide := transition.Spec{Effect: transition.Slide, Duration: 400 * time.Millisecond}
+	wipe := transition.Spec{Effect: transition.Wipe, Duration: time.Second}
+
+	a, b := dial("a"), dial("b")
+	if err := WriteControl(a, Control{Transition: &slide.Effect}); err != nil {
+		t.Fatal(err)
+	}
+	if got := send(a); got != slide {
+		t.Errorf("stream a after its control message: %v, want %v", got, slide)
+	}
+	if got := send(b); got.Enabled() {
+		t.Errorf("stream b picked up stream a's transition %v", got)
+	}
+
+	// A FrameTransition packet applies to the next frame only.
+	if err := WriteTransition(b, wipe); err != nil {
+		t.Fatal(err)
+	}
+	if got := send(b); got != wipe {
+		t.Errorf("frame after FrameTransition: %v, want %v", got, wipe)
+	}
+	if got := send(b); got.Enabled() {
+		t.Errorf("FrameTransition leaked into the following frame: %v", got)
+	}
+	if got := send(a); got != slide {
+		t.Errorf("stream a after stream b's frame transition: %v, want %v", got, slide)
+	}
+}
+
+func TestApplyControlChromaKey(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+
+	if err := s.applyControl(defaultStream, []byte(`{"chroma_key":{"enabled":true,"color":"#0000ff"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	want := chroma.DefaultKey()
+	want.Enabled = true
+	want.Color = chroma.Color{B: 255}
+	if got := s.ChromaKey(); got != want {
+		t.Errorf("ChromaKey = %+v, want %+v", got, want)
+	}
+
+	// Fields left out keep their value.
+	if err := s.applyControl(defaultStream, []byte(`{"chroma_key":{"spill":0.3}}`)); err != nil {
+		t.Fatal(err)
+	}
+	want.Spill = 0.3
+	if got := s.ChromaKey(); got != want {
+		t.Errorf("ChromaKey = %+v, want %+v", got, want)
+	}
+
+	frame := []byte{255, 0, 0, 255}
+	s.finishFrame(defaultStream, frame, 1, 1)
+	if frame[3] != 0 {
+		t.Errorf("blue pixel alpha = %d after keying, want 0", frame[3])
+	}
+
+	if err := s.applyControl(defaultStream, []byte(`{"chroma_key":null}`)); err != nil {
+		t.Fatal(err)
+	}
+	if s.ChromaKey().Enabled {
+		t.Error("null chroma_key did not disable keying")
+	}
+}
+
+func TestFinishFrameMasks(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	if err := s.applyControl(defaultStream, []byte(`{"mask":"circle"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Mask().Shape(); got != mask.Circle {
+		t.Fatalf("mask = %v, want circle", got)
+	}
+
+	frame := make([]byte, 10*10*4)
+	for i := range frame {
+		frame[i] = 0xff
+	}
+	s.finishFrame(defaultStream, frame, 10, 10)
+	if frame[3] != 0 || frame[(5*10+5)*4+3] != 0xff {
+		t.Errorf("corner alpha %d, center alpha %d; want 0 and 255", frame[3], frame[(5*10+5)*4+3])
+	}
+
+	if err := s.applyControl(defaultStream, []byte(`{"mask":"none"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if s.Mask() != nil {
+		t.Error("mask none did not remove the mask")
+	}
+}
+
+func TestRelayReplaysControlToLateSubscribers(t *testing.T) {
+	r := NewRelay()
+	r.PublishControl("a", []byte(`{"fit":"fill"}`))
+	r.Publish("a", FrameImage, time.Time{}, nil, []byte("frame"))
+
+	sub := r.subscribe("a")
+	select {
+	case data := <-sub.control:
+		if string(data) != `{"fit":"fill"}` {
+			t.Errorf("control = %s", data)
+		}
+	default:
+		t.Error("late subscriber did not get the control message")
+	}
+	if p := <-sub.latest; string(p.data) != "frame" {
+		t.Errorf("latest = %s, want frame", p.data)
+	}
+}
@@ -0,0 +1,201 @@
+package websocket
+
+import (
+	"image"
+)
+
+// convertToBGRA writes img into dst as premultiplied BGRA. The common
+// decoder output types are converted directly from their pixel buffers;
+// the results are bit-identical to convertGeneric, which goes through
+// color.Color for every pixel.
+func convertToBGRA(dst []byte, img image.Image) {
+	switch src := img.(type) {
+	case *image.NRGBA:
+		convertNRGBA(dst, src)
+	case *image.RGBA:
+		convertRGBA(dst, src)
+	case *image.YCbCr:
+		convertYCbCr(dst, src)
+	case *image.NYCbCrA:
+		convertNYCbCrA(dst, src)
+	case *image.Paletted:
+		convertPaletted(dst, src)
+	case *image.Gray:
+		convertGray(dst, src)
+	case *bgraImage:
+		copy(dst, src.Pix)
+	default:
+		convertGeneric(dst, img)
+	}
+}
+
+func convertGeneric(dst []byte, img image.Image) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			idx := (y*width + x) * 4
+			dst[idx+0] = uint8(b >> 8)
+			dst[idx+1] = uint8(g >> 8)
+			dst[idx+2] = uint8(r >> 8)
+			dst[idx+3] = uint8(a >> 8)
+		}
+	}
+}
+
+func convertNRGBA(dst []byte, src *image.NRGBA) {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	for y := 0; y < height; y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+width*4]
+		d := dst[y*width*4 : (y+1)*width*4]
+		for i := 0; i < len(s); i += 4 {
+			r, g, b, a := s[i+0], s[i+1], s[i+2], s[i+3]
+			switch a {
+			case 0xff:
+				d[i+0], d[i+1], d[i+2], d[i+3] = b, g, r, 0xff
+			case 0:
+				d[i+0], d[i+1], d[i+2], d[i+3] = 0, 0, 0, 0
+			default:
+				d[i+0] = premultiply(b, a)
+				d[i+1] = premultiply(g, a)
+				d[i+2] = premultiply(r, a)
+				d[i+3] = a
+			}
+		}
+	}
+}
+
+// premultiply matches color.NRGBA.RGBA followed by a shift to 8 bits.
+func premultiply(c, a uint8) uint8 {
+	return uint8(uint32(c) * 0x101 * (uint32(a) * 0x101) / 0xffff >> 8)
+}
+
+func convertRGBA(dst []byte, src *image.RGBA) {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	for y := 0; y < height; y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+width*4]
+		d := dst[y*width*4 : (y+1)*width*4]
+		for i := 0; i < len(s); i += 4 {
+			d[i+0], d[i+1], d[i+2], d[i+3] = s[i+2], s[i+1], s[i+0], s[i+3]
+		}
+	}
+}
+
+func convertYCbCr(dst []byte, src *image.YCbCr) {
+	convertYCbCrA(dst, src, nil, 0)
+}
+
+func convertNYCbCrA(dst []byte, src *image.NYCbCrA) {
+	convertYCbCrA(dst, &src.YCbCr, src.A, src.AStride)
+}
+
+// convertYCbCrA inlines color.YCbCr.RGBA (and, with an alpha plane,
+// color.NYCbCrA.RGBA) over whole rows.
+func convertYCbCrA(dst []byte, src *image.YCbCr, alpha []byte, aStride int) {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	minX, minY := src.Rect.Min.X, src.Rect.Min.Y
+	if minX < 0 {
+		// The shift below only matches COffset's division for x >= 0.
+		if alpha != nil {
+			convertGeneric(dst, &image.NYCbCrA{YCbCr: *src, A: alpha, AStride: aStride})
+		} else {
+			convertGeneric(dst, src)
+		}
+		return
+	}
+
+	var shift uint
+	switch src.SubsampleRatio {
+	case image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420:
+		shift = 1
+	case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+		shift = 2
+	}
+
+	for y := 0; y < height; y++ {
+		yrow := src.Y[src.YOffset(minX, minY+y):][:width]
+		c0 := src.COffset(minX, minY+y) - minX>>shift
+		var arow []byte
+		if alpha != nil {
+			arow = alpha[y*aStride:][:width]
+		}
+		d := dst[y*width*4:][:width*4]
+
+		for x, yv := range yrow {
+			ci := c0 + (minX+x)>>shift
+			yy1 := int32(yv) * 0x10101
+			cb1 := int32(src.Cb[ci]) - 128
+			cr1 := int32(src.Cr[ci]) - 128
+
+			r := yy1 + 91881*cr1
+			if uint32(r)&0xff000000 == 0 {
+				r >>= 8
+			} else {
+				r = ^(r >> 31) & 0xffff
+			}
+
+			g := yy1 - 22554*cb1 - 46802*cr1
+			if uint32(g)&0xff000000 == 0 {
+				g >>= 8
+			} else {
+				g = ^(g >> 31) & 0xffff
+			}
+
+			b := yy1 + 116130*cb1
+			if uint32(b)&0xff000000 == 0 {
+				b >>= 8
+			} else {
+				b = ^(b >> 31) & 0xffff
+			}
+
+			i := x * 4
+			if arow == nil {
+				d[i+0] = uint8(b >> 8)
+				d[i+1] = uint8(g >> 8)
+				d[i+2] = uint8(r >> 8)
+				d[i+3] = 0xff
+				continue
+			}
+			a := uint32(arow[x]) * 0x101
+			d[i+0] = uint8(uint32(b) * a / 0xffff >> 8)
+			d[i+1] = uint8(uint32(g) * a / 0xffff >> 8)
+			d[i+2] = uint8(uint32(r) * a / 0xffff >> 8)
+			d[i+3] = uint8(a >> 8)
+		}
+	}
+}
+
+func convertPaletted(dst []byte, src *image.Paletted) {
+	var table [256][4]uint8
+	for i, c := range src.Palette {
+		if i >= len(table) {
+			break
+		}
+		r, g, b, a := c.RGBA()
+		table[i] = [4]uint8{uint8(b >> 8), uint8(g >> 8), uint8(r >> 8), uint8(a >> 8)}
+	}
+
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	for y := 0; y < height; y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+width]
+		d := dst[y*width*4 : (y+1)*width*4]
+		for x, idx := range s {
+			copy(d[x*4:x*4+4], table[idx][:])
+		}
+	}
+}
+
+func convertGray(dst []byte, src *image.Gray) {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	for y := 0; y < height; y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+width]
+		d := dst[y*width*4 : (y+1)*width*4]
+		for x, v := range s {
+			i := x * 4
+			d[i+0], d[i+1], d[i+2], d[i+3] = v, v, v, 0xff
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func randomImages(w, h int) map[string]image.Image {
+	rng := rand.New(rand.NewSource(1))
+	fill := func(b []byte) {
+		rng.Read(b)
+	}
+	rect := image.Rect(3, 5, 3+w, 5+h)
+
+	nrgba := image.NewNRGBA(rect)
+	fill(nrgba.Pix)
+	// Exercise the fully opaque and fully transparent shortcuts.
+	for i := 3; i < len(nrgba.Pix); i += 16 {
+		nrgba.Pix[i] = 0xff
+	}
+	for i := 7; i < len(nrgba.Pix); i += 16 {
+		nrgba.Pix[i] = 0
+	}
+
+	rgba := image.NewRGBA(rect)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		a := uint8(rng.Intn(256))
+		rgba.Pix[i+0] = uint8(rng.Intn(int(a) + 1))
+		rgba.Pix[i+1] = uint8(rng.Intn(int(a) + 1))
+		rgba.Pix[i+2] = uint8(rng.Intn(int(a) + 1))
+		rgba.Pix[i+3] = a
+	}
+
+	gray := image.NewGray(rect)
+	fill(gray.Pix)
+
+	paletted := image.NewPaletted(rect, append(color.Palette{color.NRGBA{10, 20, 30, 40}}, palette.Plan9[:200]...))
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(rng.Intn(len(paletted.Palette)))
+	}
+
+	images := map[string]image.Image{
+		"NRGBA":        nrgba,
+		"RGBA":         rgba,
+		"Gray":         gray,
+		"Paletted":     paletted,
+		"NRGBA/subimg": nrgba.SubImage(image.Rect(4, 6, 3+w-1, 5+h-2)),
+	}
+
+	ratios := map[string]image.YCbCrSubsampleRatio{
+		"444": image.YCbCrSubsampleRatio444,
+		"422": image.YCbCrSubsampleRatio422,
+		"420": image.YCbCrSubsampleRatio420,
+		"440": image.YCbCrSubsampleRatio440,
+		"411": image.YCbCrSubsampleRatio411,
+		"410": image.YCbCrSubsampleRatio410,
+	}
+	for name, ratio := range ratios {
+		ycc := image.NewYCbCr(rect, ratio)
+		fill(ycc.Y)
+		fill(ycc.Cb)
+		fill(ycc.Cr)
+		images["YCbCr"+name] = ycc
+
+		nycca := image.NewNYCbCrA(rect, ratio)
+		fill(nycca.Y)
+		fill(nycca.Cb)
+		fill(nycca.Cr)
+		fill(nycca.A)
+		images["NYCbCrA"+name] = nycca
+	}
+	images["YCbCr420/subimg"] = images["YCbCr420"].(*image.YCbCr).SubImage(image.Rect(4, 6, 3+w-1, 5+h-2))
+	return images
+}
+
+func TestConvertMatchesGeneric(t *testing.T) {
+	for name, img := range randomImages(37, 23) {
+		b := img.Bounds()
+		want := make([]byte, b.Dx()*b.Dy()*4)
+		got := make([]byte, len(want))
+		convertGeneric(want, img)
+		convertToBGRA(got, img)
+		if !bytes.Equal(got, want) {
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("%s: byte %d (pixel %d) = %d, want %d", name, i, i/4, got[i], want[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeImageToBGRA(t *testing.T) {
+	data, err := os.ReadFile("../../test.webp")
+	if err != nil {
+		t.Skip(err)
+	}
+	bgra, width, height, err := DecodeImageToBGRA(data, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bgra) != width*height*4 {
+		t.Errorf("len = %d, want %d", len(bgra), width*height*4)
+	}
+}
+
+func BenchmarkConvert720p(b *testing.B) {
+	for name, img := range randomImages(1280, 720) {
+		if strings.HasSuffix(name, "/subimg") {
+			continue
+		}
+		dst := make([]byte, 1280*720*4)
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(dst)))
+			for i := 0; i < b.N; i++ {
+				convertToBGRA(dst, img)
+			}
+		})
+		b.Run(name+"/generic", func(b *testing.B) {
+			b.SetBytes(int64(len(dst)))
+			for i := 0; i < b.N; i++ {
+				convertGeneric(dst, img)
+			}
+		})
+	}
+}
+
+// BenchmarkConvertWebP converts real camera content, where the YCbCr
+// clamping branches are far more predictable than with random pixels.
+func BenchmarkConvertWebP(b *testing.B) {
+	data, err := os.ReadFile("../../test.webp")
+	if err != nil {
+		b.Skip(err)
+	}
+	img, err := webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		b.Fatal(err)
+	}
+	dst := make([]byte, img.Bounds().Dx()*img.Bounds().Dy()*4)
+	b.Run("fast", func(b *testing.B) {
+		b.SetBytes(int64(len(dst)))
+		for i := 0; i < b.N; i++ {
+			convertToBGRA(dst, img)
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		b.SetBytes(int64(len(dst)))
+		for i := 0; i < b.N; i++ {
+			convertGeneric(dst, img)
+		}
+	})
+}
+
+func BenchmarkDecodeWebP(b *testing.B) {
+	data, err := os.ReadFile("../../test.webp")
+	if err != nil {
+		b.Skip(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := DecodeImageToBGRA(data, Limits{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
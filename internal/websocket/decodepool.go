@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"errors"
+
+	"github.com/example/bidirect/internal/logging"
+)
+
+// decodeJob is a received payload, the connection it arrived on and what
+// is known about the frame before decoding it, including its arrival order
+// across all connections.
+type decodeJob struct {
+	conn *publisherConn
+	info FrameInfo
+	data []byte
+}
+
+// startDecoders starts the worker pool shared by every connection, so a
+// slow decode never holds up reading the next frame off the wire.
+func (s *Server) startDecoders() {
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				select {
+				case <-s.stopCh:
+					return
+				case job := <-s.jobs:
+					s.decodeJob(job)
+				}
+			}
+		}()
+	}
+}
+
+// nextSeq assigns the next arrival sequence number and records it as the
+// newest one.
+func (s *Server) nextSeq() uint64 {
+	seq := s.seq.Add(1)
+	for {
+		latest := s.latestSeq.Load()
+		if seq <= latest || s.latestSeq.CompareAndSwap(latest, seq) {
+			return seq
+		}
+	}
+}
+
+// submitFrame tags data with the next sequence number and queues it for
+// decoding, blocking while every worker is busy.
+func (s *Server) submitFrame(conn *publisherConn, info FrameInfo, data []byte) {
+	info.Seq = s.nextSeq()
+	select {
+	case s.jobs <- decodeJob{conn: conn, info: info, data: data}:
+	case <-s.stopCh:
+		putBuffer(data)
+	}
+}
+
+func (s *Server) decodeJob(job decodeJob) {
+	if s.latestOnly && job.info.Seq < s.latestSeq.Load() {
+		s.metrics.FramesSkipped.Add(1)
+		putBuffer(job.data)
+		return
+	}
+	if err := s.processFrame(job); err != nil {
+		if errors.Is(err, ErrLimitExceeded) {
+			job.conn.reject(err)
+			return
+		}
+		logging.Errorf("Error processing frame: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/transition"
+	"golang.org/x/net/websocket"
+)
+
+func encodePNG(t testing.TB, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRingBufferWriteSeqDropsOlderFrames(t *testing.T) {
+	rb := NewRingBuffer()
+	if !rb.WriteSeq(FrameInfo{Seq: 5}, make([]byte, 4), 1, 1) {
+		t.Fatal("WriteSeq(5) rejected on empty buffer")
+	}
+	if rb.WriteSeq(FrameInfo{Seq: 3}, make([]byte, 8), 2, 1) {
+		t.Error("WriteSeq(3) accepted after seq 5")
+	}
+	frame, _ := rb.Acquire()
+	if frame.Width != 1 {
+		t.Errorf("latest frame width = %d, want 1", frame.Width)
+	}
+	frame.Release()
+
+	rb.Write(make([]byte, 12), 3, 1)
+	if rb.WriteSeq(FrameInfo{Seq: 6}, make([]byte, 8), 2, 1) {
+		t.Error("WriteSeq(6) accepted after a later Write")
+	}
+}
+
+func TestDecodePoolPublishesNewestFrame(t *testing.T) {
+	for _, latestOnly := range []bool{false, true} {
+		cfg := config.DefaultConfig()
+		cfg.DecodeWorkers = 4
+		cfg.LatestOnly = latestOnly
+		s := NewServer(cfg)
+		s.startDecoders()
+
+		const frames = 40
+		for i := 1; i <= frames; i++ {
+			s.submitFrame(newPublisherConn(1, nil, s.limits), FrameInfo{Stream: defaultStream, Received: time.Now()}, encodePNG(t, i*7, 3))
+		}
+
+		m := s.Metrics()
+		deadline := time.Now().Add(5 * time.Second)
+		for m.FramesDecoded.Load()+m.FramesSkipped.Load() < frames {
+			if time.Now().After(deadline) {
+				t.Fatalf("latestOnly=%v: only %d of %d frames processed", latestOnly,
+					m.FramesDecoded.Load()+m.FramesSkipped.Load(), frames)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		close(s.stopCh)
+		s.wg.Wait()
+
+		frame, ok := s.GetRingBuffer().Acquire()
+		if !ok {
+			t.Fatalf("latestOnly=%v: no frame published", latestOnly)
+		}
+		if frame.Width != frames*7 {
+			t.Errorf("latestOnly=%v: latest frame width = %d, want %d", latestOnly, frame.Width, frames*7)
+		}
+		frame.Release()
+		if !latestOnly && m.FramesSkipped.Load() != 0 {
+			t.Errorf("skipped %d frames without latest-only", m.FramesSkipped.Load())
+		}
+	}
+}
+
+func TestFrameInfoReachesRingBuffer(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DecodeWorkers = 1
+	s := NewServer(cfg)
+	s.startDecoders()
+	defer func() {
+		close(s.stopCh)
+		s.wg.Wait()
+	}()
+	fade := transition.Spec{Effect: transition.Crossfade, Duration: time.Second}
+	s.SetTransition(fade)
+	ts := httptest.NewServer(websocket.Handler(s.handleWebSocket))
+	defer ts.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?stream=cam", "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	sent := time.Now().Add(-time.Second).Round(0)
+	data := encodePNG(t, 3, 2)
+	if err := WriteTimestamp(ws, sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePacket(ws, data); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	frame, ok := s.ringBuffer.Acquire()
+	for ; !ok; frame, ok = s.ringBuffer.Acquire() {
+		if time.Now().After(deadline) {
+			t.Fatal("frame never reached the ring buffer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer frame.Release()
+
+	info := frame.FrameInfo
+	if info.Seq != 1 || info.Stream != "cam" || info.ConnID != 1 {
+		t.Errorf("seq %d, stream %q, connection %d; want 1, cam, 1", info.Seq, info.Stream, info.ConnID)
+	}
+	if !info.Sent.Equal(sent) || info.Received.Before(sent) {
+		t.Errorf("sent %v, received %v; want sent %v", info.Sent, info.Received, sent)
+	}
+	if info.Format != "png" || info.EncodedSize != len(data) || info.PixelFormat != PixelBGRAPremultiplied {
+		t.Errorf("format %s, %d bytes, %v; want png, %d bytes, %v",
+			info.Format, info.EncodedSize, info.PixelFormat, len(data), PixelBGRAPremultiplied)
+	}
+	if info.Transition != fade {
+		t.Errorf("transition = %v, want %v", info.Transition, fade)
+	}
+	if info.Decode <= 0 {
+		t.Errorf("decode time = %v", info.Decode)
+	}
+	if n := s.metrics.FramesTimestamped.Load(); n != 1 {
+		t.Errorf("FramesTimestamped = %d, want 1", n)
+	}
+}
+
+func TestRingBufferUpdateKeepsFrameInfo(t *testing.T) {
+	rb := NewRingBuffer()
+	rb.WriteSeq(FrameInfo{Seq: 1, Stream: "cam", Format: "gif"}, getBuffer(4), 1, 1)
+	rb.Update(1, make([]byte, 4), 1, 1)
+	frame, _ := rb.Acquire()
+	defer frame.Release()
+	if frame.Seq != 1 || frame.Stream != "cam" || frame.Format != "gif" {
+		t.Errorf("updated frame info = %+v", frame.FrameInfo)
+	}
+}
@@ -1,48 +1,25 @@
 package websocket
 
 import (
-	"bytes"
-	"image"
 	"image/color"
 	"math"
-
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
-
-	"golang.org/x/image/webp"
 )
 
-func DecodeImageToBGRA(data []byte) ([]byte, int, int, error) {
-	var img image.Image
-	var err error
-
-	img, err = webp.Decode(bytes.NewReader(data))
+// DecodeImageToBGRA decodes a still image into premultiplied BGRA. The
+// format is detected from the payload's magic bytes and the size in its
+// header is checked against limits first, so an image that is too large
+// fails with a LimitError before it is decoded. The returned slice comes
+// from the buffer pool and can be handed to RingBuffer.WriteSeq without
+// copying.
+func DecodeImageToBGRA(data []byte, limits Limits) ([]byte, int, int, error) {
+	codec, err := SniffCodec(data)
 	if err != nil {
-		img, _, err = image.Decode(bytes.NewReader(data))
-		if err != nil {
-			return nil, 0, 0, err
-		}
+		return nil, 0, 0, err
 	}
-
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
-
-	bgra := make([]byte, width*height*4)
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-			idx := (y*width + x) * 4
-			bgra[idx+0] = uint8(b >> 8)
-			bgra[idx+1] = uint8(g >> 8)
-			bgra[idx+2] = uint8(r >> 8)
-			bgra[idx+3] = uint8(a >> 8)
-		}
+	if _, err := codec.checkSize(data, limits); err != nil {
+		return nil, 0, 0, err
 	}
-
-	return bgra, width, height, nil
+	return codec.decodeBGRA(data)
 }
 
 func CreateBlankFrame(width, height int, col color.NRGBA) []byte {
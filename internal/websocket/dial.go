@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/example/bidirect/internal/logging"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	dialMinBackoff = 500 * time.Millisecond
+	dialMaxBackoff = 30 * time.Second
+)
+
+// Dial connects out to a publisher instead of waiting for it to connect in.
+// Frames received over the connection go through the same processing as
+// inbound ones. The connection is re-established with exponential backoff
+// until Stop is called.
+func (s *Server) Dial(url, token string) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		backoff := dialMinBackoff
+		for {
+			ws, err := dialPublisher(url, token)
+			if err != nil {
+				logging.Errorf("Dial %s failed: %v (retrying in %v)", url, err, backoff)
+			} else {
+				logging.Infof("Connected to publisher %s", url)
+				backoff = dialMinBackoff
+				s.receiveDialed(ws)
+				logging.Infof("Disconnected from publisher %s", url)
+			}
+
+			select {
+			case <-s.stopCh:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > dialMaxBackoff {
+				backoff = dialMaxBackoff
+			}
+		}
+	}()
+}
+
+func dialPublisher(url, token string) (*websocket.Conn, error) {
+	cfg, err := websocket.NewConfig(url, "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		cfg.Header.Set("Authorization", "Bearer "+token)
+	}
+	return websocket.DialConfig(cfg)
+}
+
+func (s *Server) receiveDialed(ws *websocket.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.stopCh:
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	defer ws.Close()
+	s.receiveFrames(ws)
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/example/bidirect/internal/filter"
+	"github.com/example/bidirect/internal/logging"
+)
+
+// Filters returns the filter pipeline applied to frames of stream. An
+// empty stream name selects the pipeline used by streams without one of
+// their own.
+func (s *Server) Filters(stream string) *filter.Pipeline {
+	s.controlMu.RLock()
+	defer s.controlMu.RUnlock()
+	return s.pipelineFor(stream)
+}
+
+// SetFilters replaces the filter pipeline of stream, taking effect with
+// the next frame decoded. An empty stream name sets the pipeline used by
+// streams without one of their own; an empty pipeline on a named stream
+// makes it fall back to that one.
+func (s *Server) SetFilters(stream string, p *filter.Pipeline) {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+	s.setFilters(stream, p)
+}
+
+// setFilters is SetFilters with s.controlMu held.
+func (s *Server) setFilters(stream string, p *filter.Pipeline) {
+	name := stream
+	if name == "" {
+		name = "all streams"
+	}
+	if p.Len() == 0 {
+		delete(s.filters, stream)
+		logging.Infof("Filters for %s cleared", name)
+		return
+	}
+	s.filters[stream] = p
+	logging.Infof("Filters for %s: %v", name, p)
+}
+
+func (s *Server) pipelineFor(stream string) *filter.Pipeline {
+	if p, ok := s.filters[stream]; ok {
+		return p
+	}
+	return s.filters[""]
+}
+
+// finishFrame runs a decoded frame through the chroma key, the stream's
+// filter pipeline and the mask, in that order, before it is published to
+// the ring buffer. Filters may replace the buffer and change the frame's
+// size, so the caller must continue with the returned frame.
+func (s *Server) finishFrame(stream string, data []byte, width, height int) ([]byte, int, int) {
+	s.controlMu.RLock()
+	key, pipeline, m := s.chromaKey, s.pipelineFor(stream), s.shapeMask
+	s.controlMu.RUnlock()
+
+	key.Apply(data)
+	f := filter.Frame{Pix: data, Width: width, Height: height, Get: getBuffer, Put: putBuffer}
+	pipeline.Apply(&f)
+	m.Apply(f.Pix, f.Width, f.Height)
+	return f.Pix, f.Width, f.Height
+}
+
+// serveFilters reports the filter pipeline of the stream named by the
+// "stream" query parameter, or of all streams without one, and replaces
+// it with the request body on PUT or POST.
+func (s *Server) serveFilters(w http.ResponseWriter, r *http.Request) {
+	stream := r.URL.Query().Get("stream")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := filter.Parse(string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.SetFilters(stream, p)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, s.Filters(stream).String()+"\n")
+}
@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/filter"
+)
+
+func TestFinishFrameRunsStreamPipeline(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	all, _ := filter.Parse("rotate=90")
+	mirror, _ := filter.Parse("fliph")
+	s.SetFilters("", all)
+	s.SetFilters("cam", mirror)
+
+	frame := []byte{1, 0, 0, 255, 2, 0, 0, 255}
+	data, w, h := s.finishFrame("other", append([]byte(nil), frame...), 2, 1)
+	if w != 1 || h != 2 || data[0] != 1 || data[4] != 2 {
+		t.Errorf("default pipeline gave %dx%d %v, want 1x2 rotated", w, h, data)
+	}
+
+	data, w, h = s.finishFrame("cam", append([]byte(nil), frame...), 2, 1)
+	if w != 2 || h != 1 || data[0] != 2 || data[4] != 1 {
+		t.Errorf("cam pipeline gave %dx%d %v, want 2x1 mirrored", w, h, data)
+	}
+
+	// Clearing the stream's own pipeline falls back to the default.
+	if err := s.applyControl("cam", []byte(`{"filters":""}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Filters("cam").String(); got != "rotate=90" {
+		t.Errorf("cam filters = %q after clearing, want rotate=90", got)
+	}
+}
+
+func TestServeFilters(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	do := func(method, target, body string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.serveFilters(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		out, _ := io.ReadAll(rec.Body)
+		return rec.Code, strings.TrimSpace(string(out))
+	}
+
+	if code, body := do(http.MethodPut, "/filters?stream=cam", "mirror, grayscale"); code != http.StatusOK || body != "fliph,grayscale" {
+		t.Errorf("PUT = %d %q", code, body)
+	}
+	if code, body := do(http.MethodGet, "/filters?stream=cam", ""); code != http.StatusOK || body != "fliph,grayscale" {
+		t.Errorf("GET = %d %q", code, body)
+	}
+	if code, body := do(http.MethodGet, "/filters", ""); code != http.StatusOK || body != "" {
+		t.Errorf("GET default = %d %q, want empty", code, body)
+	}
+	if code, _ := do(http.MethodPost, "/filters?stream=cam", "rotate=45"); code != http.StatusBadRequest {
+		t.Errorf("invalid POST = %d, want 400", code)
+	}
+	if got := s.Filters("cam").String(); got != "fliph,grayscale" {
+		t.Errorf("invalid POST changed filters to %q", got)
+	}
+	if code, _ := do(http.MethodDelete, "/filters", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE = %d, want 405", code)
+	}
+}
@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/logging"
+	"golang.org/x/net/websocket"
+)
+
+// ErrLimitExceeded matches every LimitError with errors.Is.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError reports a payload that would make the receiver allocate more
+// than its Limits allow.
+type LimitError struct {
+	// Limit names the limit: "width", "height", "pixels" or "memory".
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// Limits bounds what a sender can make the receiver allocate. Zero fields
+// are unlimited.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+	// MaxMemory bounds the decoded frames held on behalf of one
+	// connection: frames being decoded and animations being played.
+	MaxMemory int64
+}
+
+// LimitsFromConfig returns the limits set in cfg.
+func LimitsFromConfig(cfg config.Config) Limits {
+	return Limits{
+		MaxWidth:  cfg.MaxFrameWidth,
+		MaxHeight: cfg.MaxFrameHeight,
+		MaxPixels: cfg.MaxFramePixels,
+		MaxMemory: cfg.MaxConnMemory,
+	}
+}
+
+// CheckSize reports whether a width x height frame is within the limits.
+func (l Limits) CheckSize(width, height int) error {
+	switch {
+	case l.MaxWidth > 0 && width > l.MaxWidth:
+		return &LimitError{Limit: "width", Value: int64(width), Max: int64(l.MaxWidth)}
+	case l.MaxHeight > 0 && height > l.MaxHeight:
+		return &LimitError{Limit: "height", Value: int64(height), Max: int64(l.MaxHeight)}
+	case l.MaxPixels > 0 && int64(width)*int64(height) > int64(l.MaxPixels):
+		return &LimitError{Limit: "pixels", Value: int64(width) * int64(height), Max: int64(l.MaxPixels)}
+	}
+	return nil
+}
+
+// frameBytes is the size of a decoded width x height BGRA frame.
+func frameBytes(width, height int) int64 {
+	return int64(width) * int64(height) * 4
+}
+
+// memoryBudget tracks the decoded bytes held on behalf of one connection.
+type memoryBudget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// reserve accounts for n more bytes, failing when that would exceed the
+// budget.
+func (b *memoryBudget) reserve(n int64) error {
+	for {
+		used := b.used.Load()
+		if b.max > 0 && used+n > b.max {
+			return &LimitError{Limit: "memory", Value: used + n, Max: b.max}
+		}
+		if b.used.CompareAndSwap(used, used+n) {
+			return nil
+		}
+	}
+}
+
+func (b *memoryBudget) release(n int64) {
+	b.used.Add(-n)
+}
+
+// publisherConn is a connection frames are received on.
+type publisherConn struct {
+	id       uint64
+	ws       *websocket.Conn
+	budget   memoryBudget
+	rejected sync.Once
+}
+
+func newPublisherConn(id uint64, ws *websocket.Conn, limits Limits) *publisherConn {
+	return &publisherConn{id: id, ws: ws, budget: memoryBudget{max: limits.MaxMemory}}
+}
+
+// reject tells the sender why the receiver is giving up on it and closes
+// the connection. Only the first reason is sent.
+func (c *publisherConn) reject(reason error) {
+	c.rejected.Do(func() {
+		logging.Errorf("Rejecting %s: %v", remoteAddr(c.ws), reason)
+		if err := WriteTypedPacket(c.ws, FrameReject, []byte(reason.Error())); err != nil {
+			logging.Errorf("Error sending rejection: %v", err)
+		}
+		c.ws.Close()
+	})
+}
@@ -0,0 +1,247 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"golang.org/x/net/websocket"
+)
+
+// pngBomb returns a valid PNG header claiming a width x height RGBA
+// image, without any pixel data.
+func pngBomb(width, height int) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9] = 8, 6
+	writePNGChunk(&buf, "IHDR", ihdr)
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+func TestLimitsCheckSize(t *testing.T) {
+	l := Limits{MaxWidth: 100, MaxHeight: 50, MaxPixels: 2000}
+	tests := []struct {
+		w, h  int
+		limit string
+	}{
+		{100, 20, ""},
+		{101, 1, "width"},
+		{1, 51, "height"},
+		{50, 41, "pixels"},
+	}
+	for _, tt := range tests {
+		err := l.CheckSize(tt.w, tt.h)
+		var le *LimitError
+		switch {
+		case tt.limit == "" && err != nil:
+			t.Errorf("CheckSize(%d, %d) = %v, want nil", tt.w, tt.h, err)
+		case tt.limit != "" && (!errors.As(err, &le) || le.Limit != tt.limit):
+			t.Errorf("CheckSize(%d, %d) = %v, want a %s LimitError", tt.w, tt.h, err, tt.limit)
+		case tt.limit != "" && !errors.Is(err, ErrLimitExceeded):
+			t.Errorf("CheckSize(%d, %d) does not match ErrLimitExceeded", tt.w, tt.h)
+		}
+	}
+	if err := (Limits{}).CheckSize(1<<20, 1<<20); err != nil {
+		t.Errorf("zero Limits rejected a frame: %v", err)
+	}
+}
+
+func TestDecodeRejectsBombBeforeDecoding(t *testing.T) {
+	limits := LimitsFromConfig(config.DefaultConfig())
+	bomb := pngBomb(30000, 30000)
+
+	if _, _, _, err := DecodeImageToBGRA(bomb, limits); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("DecodeImageToBGRA = %v, want a LimitError", err)
+	}
+	if _, err := DecodeAnimation(bomb, limits); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("DecodeAnimation = %v, want a LimitError", err)
+	}
+}
+
+func TestDecodeAnimationMemoryLimit(t *testing.T) {
+	pal := color.Palette{color.Black, color.White}
+	var frames []*image.Paletted
+	for range 4 {
+		frames = append(frames, image.NewPaletted(image.Rect(0, 0, 10, 10), pal))
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &gif.GIF{Image: frames, Delay: make([]int, 4)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeAnimation(buf.Bytes(), Limits{MaxMemory: 4 * 400}); err != nil {
+		t.Errorf("animation within budget: %v", err)
+	}
+	if _, err := DecodeAnimation(buf.Bytes(), Limits{MaxMemory: 3 * 400}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("animation over budget = %v, want a LimitError", err)
+	}
+	if _, err := DecodeAnimation(buf.Bytes(), Limits{MaxWidth: 9}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("animation wider than allowed = %v, want a LimitError", err)
+	}
+}
+
+// gifBomb returns a small GIF holding frames blank width x height frames.
+func gifBomb(t *testing.T, frames, width, height int) []byte {
+	frame := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.Black, color.White})
+	g := &gif.GIF{}
+	for range frames {
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 0)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeGIFChecksFramesBeforeDecoding(t *testing.T) {
+	data := gifBomb(t, 40, 1024, 1024)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := DecodeAnimation(data, Limits{MaxMemory: 16 << 20})
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("GIF of 40 1024x1024 frames under a 16 MB limit = %v, want a LimitError", err)
+	}
+	// A single decoded frame would take 1 MB.
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes before rejecting the GIF", n)
+	}
+
+	if _, err := DecodeAnimation(data, Limits{MaxWidth: 1000}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("GIF with frames wider than allowed = %v, want a LimitError", err)
+	}
+
+	// The connection's budget is reserved before decoding as well.
+	s := NewServer(config.DefaultConfig())
+	s.limits.MaxMemory = 0
+	conn := newPublisherConn(1, nil, Limits{MaxMemory: 64 << 20})
+	err = s.processFrame(decodeJob{conn: conn, info: FrameInfo{Stream: defaultStream}, data: data})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("GIF over the connection's budget = %v, want a LimitError", err)
+	}
+	if n := conn.budget.used.Load(); n != 0 {
+		t.Errorf("%d bytes still reserved after rejecting the GIF", n)
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	b := memoryBudget{max: 100}
+	if err := b.reserve(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.reserve(50); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("reserve over budget = %v, want a LimitError", err)
+	}
+	b.release(60)
+	if err := b.reserve(100); err != nil {
+		t.Errorf("reserve after release: %v", err)
+	}
+}
+
+func TestOversizedFrameRejectsConnection(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DecodeWorkers = 1
+	s := NewServer(cfg)
+	s.startDecoders()
+	defer func() {
+		close(s.stopCh)
+		s.wg.Wait()
+	}()
+	ts := httptest.NewServer(websocket.Handler(s.handleWebSocket))
+	defer ts.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err := WritePacket(ws, pngBomb(30000, 30000)); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	packets := NewPacketReader(ws)
+	typ, reason, err := packets.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != FrameReject || !strings.Contains(string(reason), "width 30000") {
+		t.Fatalf("got packet type %d %q, want a rejection naming the width", typ, reason)
+	}
+	if _, _, err := packets.Next(); err != io.EOF {
+		t.Errorf("after rejection, Next = %v, want io.EOF", err)
+	}
+	if n := s.Metrics().LimitRejections.Load(); n != 1 {
+		t.Errorf("LimitRejections = %d, want 1", n)
+	}
+	if _, ok := s.ringBuffer.Acquire(); ok {
+		t.Error("oversized frame reached the ring buffer")
+	}
+}
+
+func TestDecodeAPNGAndWebPCheckFramesBeforeDecoding(t *testing.T) {
+	const frames = 64
+	blank := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	images := make([]image.Image, frames)
+	for i := range images {
+		images[i] = blank
+	}
+	apng := buildAPNG(t, images, make([]bool, frames))
+	webp, width, height := buildAnimatedWebP(t, readTestFile(t, "../../test.webp"), frames)
+
+	for _, tt := range []struct {
+		name          string
+		data          []byte
+		width, height int
+	}{
+		{"APNG", apng, 256, 256},
+		{"WebP", webp, width, height},
+	} {
+		frame := frameBytes(tt.width, tt.height)
+		limits := Limits{MaxMemory: 16 * frame}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := DecodeAnimation(tt.data, limits)
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s of %d frames under a %d-frame limit = %v, want a LimitError", tt.name, frames, 16, err)
+			continue
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > uint64(frame) {
+			t.Errorf("%s: allocated %d bytes before rejecting the animation", tt.name, n)
+		}
+
+		s := NewServer(config.DefaultConfig())
+		s.limits.MaxMemory = 0
+		conn := newPublisherConn(1, nil, limits)
+		err = s.processFrame(decodeJob{conn: conn, info: FrameInfo{Stream: defaultStream}, data: tt.data})
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s over the connection's budget = %v, want a LimitError", tt.name, err)
+		}
+		if n := conn.budget.used.Load(); n != 0 {
+			t.Errorf("%s: %d bytes still reserved after rejecting the animation", tt.name, n)
+		}
+	}
+
+	if _, err := DecodeAnimation(apng, Limits{MaxWidth: 255}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("APNG with frames wider than allowed = %v, want a LimitError", err)
+	}
+}
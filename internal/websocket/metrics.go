@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Metrics holds the server's running counters. All fields are safe for
+// concurrent use.
+type Metrics struct {
+	Connections        atomic.Int64
+	FramesReceived     atomic.Uint64
+	FramesDecoded      atomic.Uint64
+	DecodeErrors       atomic.Uint64
+	LimitRejections    atomic.Uint64
+	FormatsRejected    atomic.Uint64
+	FramesSkipped      atomic.Uint64
+	FramesLate         atomic.Uint64
+	InterframesDropped atomic.Uint64
+	KeyframeRequests   atomic.Uint64
+	BytesReceived      atomic.Uint64
+	DecodeNanos        atomic.Uint64
+	// FramesTimestamped counts published frames that carried a sender
+	// timestamp, and TransitNanos the time from their capture to their
+	// arrival.
+	FramesTimestamped atomic.Uint64
+	TransitNanos      atomic.Uint64
+}
+
+// WriteTo writes the counters in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w,
+		"bidirect_connections %d\n"+
+			"bidirect_frames_received_total %d\n"+
+			"bidirect_frames_decoded_total %d\n"+
+			"bidirect_decode_errors_total %d\n"+
+			"bidirect_limit_rejections_total %d\n"+
+			"bidirect_formats_rejected_total %d\n"+
+			"bidirect_frames_skipped_total %d\n"+
+			"bidirect_frames_late_total %d\n"+
+			"bidirect_vp8_interframes_dropped_total %d\n"+
+			"bidirect_vp8_keyframe_requests_total %d\n"+
+			"bidirect_bytes_received_total %d\n"+
+			"bidirect_decode_seconds_total %.6f\n"+
+			"bidirect_frames_timestamped_total %d\n"+
+			"bidirect_transit_seconds_total %.6f\n",
+		m.Connections.Load(),
+		m.FramesReceived.Load(),
+		m.FramesDecoded.Load(),
+		m.DecodeErrors.Load(),
+		m.LimitRejections.Load(),
+		m.FormatsRejected.Load(),
+		m.FramesSkipped.Load(),
+		m.FramesLate.Load(),
+		m.InterframesDropped.Load(),
+		m.KeyframeRequests.Load(),
+		m.BytesReceived.Load(),
+		float64(m.DecodeNanos.Load())/1e9,
+		m.FramesTimestamped.Load(),
+		float64(m.TransitNanos.Load())/1e9,
+	)
+	return int64(n), err
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.WriteTo(w)
+	if s.ringBuffer != nil {
+		fmt.Fprintf(w, "bidirect_frames_overwritten_total %d\n", s.ringBuffer.Overwritten())
+	}
+}
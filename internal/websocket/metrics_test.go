@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/example/bidirect/internal/config"
+)
+
+func TestServeMetrics(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	s.metrics.FramesReceived.Add(3)
+	s.metrics.DecodeNanos.Add(1500000)
+	s.metrics.Connections.Add(1)
+	s.ringBuffer.Write(make([]byte, 4), 1, 1)
+
+	rec := httptest.NewRecorder()
+	s.serveMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	values := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		name, value, ok := strings.Cut(line, " ")
+		if !ok {
+			t.Fatalf("malformed line %q", line)
+		}
+		values[name] = value
+	}
+	for name, want := range map[string]string{
+		"bidirect_connections":              "1",
+		"bidirect_frames_received_total":    "3",
+		"bidirect_frames_decoded_total":     "0",
+		"bidirect_decode_seconds_total":     "0.001500",
+		"bidirect_frames_overwritten_total": strconv.FormatUint(s.ringBuffer.Overwritten(), 10),
+	} {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
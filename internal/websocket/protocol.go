@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/example/bidirect/internal/transition"
+	"golang.org/x/net/websocket"
+)
+
+// MaxPacketSize is the largest payload accepted on the wire.
+const MaxPacketSize = 10 * 1024 * 1024
+
+// Packet types, carried in the top byte of the size header. Payloads never
+// reach 16MB, so senders that predate typed packets always write
+// FrameImage.
+const (
+	// FrameImage is an encoded still or animated image.
+	FrameImage byte = 0
+	// FrameVP8 is a 4-byte little-endian length, a VP8 frame for the
+	// color planes and, optionally, a VP8 frame whose luma is the alpha
+	// plane. Frames of a connection form one stream and must arrive in
+	// order; interframes the receiver cannot decode, because it has not
+	// seen the keyframe they depend on, are dropped and answered with
+	// FrameKeyframeRequest.
+	FrameVP8 byte = 1
+	// FrameKeyframeRequest is sent by a receiver, without payload, when it
+	// needs the sender's next VP8 frame to be a keyframe. Senders that
+	// cannot encode one may send their last keyframe again.
+	FrameKeyframeRequest byte = 2
+	// FrameControl is a JSON-encoded Control message that changes how the
+	// receiver presents the stream.
+	FrameControl byte = 3
+	// FrameReject is sent by a receiver, with a UTF-8 reason, right before
+	// it closes a connection whose payloads exceed its limits.
+	FrameReject byte = 4
+	// FrameTimestamp is an 8-byte little-endian Unix time in nanoseconds
+	// at which the sender captured the next FrameImage or FrameVP8 packet
+	// on the connection.
+	FrameTimestamp byte = 5
+	// FrameTransition is a transition.Effect byte and a 4-byte
+	// little-endian duration in milliseconds, setting how the next
+	// FrameImage or FrameVP8 packet on the connection replaces the frame on
+	// screen.
+	FrameTransition byte = 6
+)
+
+const (
+	// timestampSize is the payload size of a FrameTimestamp packet.
+	timestampSize = 8
+	// transitionSize is the payload size of a FrameTransition packet.
+	transitionSize = 5
+)
+
+// PacketReader reads length-prefixed payloads (4-byte little-endian size
+// followed by the data) from a stream.
+type PacketReader struct {
+	r    io.Reader
+	size [4]byte
+}
+
+func NewPacketReader(r io.Reader) *PacketReader {
+	return &PacketReader{r: r}
+}
+
+// Next reads the next packet into a buffer from the package's buffer
+// pool. Ownership passes to the caller; the server releases it once the
+// payload has been decoded.
+func (pr *PacketReader) Next() (byte, []byte, error) {
+	if _, err := io.ReadFull(pr.r, pr.size[:]); err != nil {
+		return 0, nil, err
+	}
+
+	header := binary.LittleEndian.Uint32(pr.size[:])
+	typ, size := byte(header>>24), header&0xffffff
+	if size > MaxPacketSize || (size == 0 && typ != FrameKeyframeRequest) {
+		return 0, nil, fmt.Errorf("invalid frame size: %d", size)
+	}
+	if size == 0 {
+		return typ, nil, nil
+	}
+
+	data := getBuffer(int(size))
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		putBuffer(data)
+		return 0, nil, fmt.Errorf("reading frame data: %w", err)
+	}
+	return typ, data, nil
+}
+
+// WritePacket sends data as a single length-prefixed FrameImage message.
+func WritePacket(ws *websocket.Conn, data []byte) error {
+	return WriteTypedPacket(ws, FrameImage, data)
+}
+
+// WriteTimestamp sends a FrameTimestamp packet stamping the next frame
+// written to ws with t.
+func WriteTimestamp(ws *websocket.Conn, t time.Time) error {
+	var data [timestampSize]byte
+	binary.LittleEndian.PutUint64(data[:], uint64(t.UnixNano()))
+	return WriteTypedPacket(ws, FrameTimestamp, data[:])
+}
+
+// parseTimestamp decodes a FrameTimestamp payload.
+func parseTimestamp(data []byte) (time.Time, error) {
+	if len(data) != timestampSize {
+		return time.Time{}, fmt.Errorf("invalid timestamp size: %d", len(data))
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(data))), nil
+}
+
+// WriteTransition sends a FrameTransition packet setting how the next
+// frame written to ws replaces the one on screen.
+func WriteTransition(ws *websocket.Conn, spec transition.Spec) error {
+	var data [transitionSize]byte
+	data[0] = byte(spec.Effect)
+	binary.LittleEndian.PutUint32(data[1:], uint32(spec.Duration/time.Millisecond))
+	return WriteTypedPacket(ws, FrameTransition, data[:])
+}
+
+// parseTransition decodes a FrameTransition payload.
+func parseTransition(data []byte) (transition.Spec, error) {
+	if len(data) != transitionSize {
+		return transition.Spec{}, fmt.Errorf("invalid transition size: %d", len(data))
+	}
+	effect := transition.Effect(data[0])
+	if !effect.Valid() {
+		return transition.Spec{}, fmt.Errorf("unknown transition effect %d", data[0])
+	}
+	d := time.Duration(binary.LittleEndian.Uint32(data[1:])) * time.Millisecond
+	if err := transition.CheckDuration(d); err != nil {
+		return transition.Spec{}, err
+	}
+	return transition.Spec{Effect: effect, Duration: d}, nil
+}
+
+// WriteTypedPacket sends data as a single length-prefixed message of the
+// given type.
+func WriteTypedPacket(ws *websocket.Conn, typ byte, data []byte) error {
+	packet := getBuffer(4 + len(data))
+	defer putBuffer(packet)
+	binary.LittleEndian.PutUint32(packet[0:4], uint32(typ)<<24|uint32(len(data)))
+	copy(packet[4:], data)
+	return websocket.Message.Send(ws, packet)
+}
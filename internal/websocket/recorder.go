@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Recorder appends every received payload to a file. Each record is the
+// time since recording started (8-byte little-endian nanoseconds) followed
+// by the packet exactly as it came over the wire, so a recording can be
+// replayed with its original timing (send-websocket -replay).
+type Recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	start time.Time
+	// closed is set by Close. Connections still being read when the
+	// server stops may try to write afterwards.
+	closed bool
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		file:  f,
+		w:     bufio.NewWriter(f),
+		start: time.Now(),
+	}, nil
+}
+
+func (r *Recorder) Write(typ byte, data []byte) error {
+	var header [12]byte
+	binary.LittleEndian.PutUint64(header[0:8], uint64(time.Since(r.start)))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(typ)<<24|uint32(len(data)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	if _, err := r.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := r.w.Write(data)
+	return err
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	r.closed = true
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// RecordReader reads back the records of a recording.
+type RecordReader struct {
+	r *bufio.Reader
+}
+
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record: when it was received, relative to the
+// start of the recording, and the packet's type and payload. It returns
+// io.EOF after the last record.
+func (rr *RecordReader) Next() (time.Duration, byte, []byte, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(rr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("truncated record header")
+		}
+		return 0, 0, nil, err
+	}
+	at := time.Duration(binary.LittleEndian.Uint64(header[0:8]))
+	size := binary.LittleEndian.Uint32(header[8:12])
+	typ, n := byte(size>>24), size&0xffffff
+	if n > MaxPacketSize {
+		return 0, 0, nil, fmt.Errorf("invalid record size: %d", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, data); err != nil {
+		return 0, 0, nil, fmt.Errorf("reading record data: %w", err)
+	}
+	return at, typ, data, nil
+}
@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"golang.org/x/net/websocket"
+)
+
+func TestRecordingPlaysBack(t *testing.T) {
+	// dial connects a publisher to a server started with a single decoder.
+	dial := func(s *Server) *websocket.Conn {
+		t.Helper()
+		ts := httptest.NewServer(websocket.Handler(s.handleWebSocket))
+		t.Cleanup(ts.Close)
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ws.Close() })
+		return ws
+	}
+	// waitFor waits for the server to show a 3x2 frame and the HUD, which
+	// the packets sent below ask for.
+	waitFor := func(s *Server) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if frame, ok := s.ringBuffer.AcquireLatest(); ok {
+				w, h := frame.Width, frame.Height
+				frame.Release()
+				if w == 3 && h == 2 && s.HUD() {
+					return
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("the frame and control message never took effect")
+	}
+	newServer := func() *Server {
+		cfg := config.DefaultConfig()
+		cfg.DecodeWorkers = 1
+		s := NewServer(cfg)
+		s.startDecoders()
+		return s
+	}
+
+	path := filepath.Join(t.TempDir(), "session.rec")
+	s := newServer()
+	if err := s.Record(path); err != nil {
+		t.Fatal(err)
+	}
+	ws := dial(s)
+	if err := WriteTimestamp(ws, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePacket(ws, encodePNG(t, 3, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteControl(ws, Control{HUD: boolPtr(true)}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(s)
+	s.Stop()
+	if err := s.recorder.Write(FrameImage, []byte("late")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write after Stop = %v, want os.ErrClosed", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	type record struct {
+		typ  byte
+		data []byte
+	}
+	var records []record
+	var last time.Duration
+	rr := NewRecordReader(f)
+	for {
+		at, typ, data, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if at < last {
+			t.Errorf("record %d at %v, before the previous one at %v", len(records), at, last)
+		}
+		last = at
+		records = append(records, record{typ, data})
+	}
+	var types []byte
+	for _, r := range records {
+		types = append(types, r.typ)
+	}
+	if want := []byte{FrameTimestamp, FrameImage, FrameControl}; string(types) != string(want) {
+		t.Fatalf("recorded packet types %v, want %v", types, want)
+	}
+
+	// Sending the recorded packets to a fresh server reproduces the session.
+	replay := newServer()
+	defer replay.Stop()
+	ws = dial(replay)
+	for _, r := range records {
+		if err := WriteTypedPacket(ws, r.typ, r.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(replay)
+}
+
+func boolPtr(b bool) *bool { return &b }
@@ -0,0 +1,327 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/example/bidirect/internal/logging"
+	"github.com/example/bidirect/internal/transition"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	defaultStream = "default"
+	// maxRelayControls bounds the control messages a stream remembers for
+	// late subscribers and a subscriber may have queued.
+	maxRelayControls = 16
+)
+
+// Relay fans published frames out to the subscribers of each stream.
+// Frames are forwarded still encoded; every subscriber has a one-slot
+// queue, so a slow subscriber skips to the newest frame instead of
+// holding back the publisher or the other subscribers. VP8 interframes
+// cannot be skipped, as the frames after them are predicted from them,
+// so a subscriber that falls behind on a VP8 stream, or joins one late,
+// waits for the next keyframe instead. Control messages are never
+// skipped; they are queued separately and replayed to late subscribers
+// while the stream has a publisher. Keyframe requests from subscribers
+// travel the other way, to the stream's publishers.
+type Relay struct {
+	mu         sync.Mutex
+	streams    map[string]map[*subscriber]struct{}
+	publishers map[string]map[*publisher]struct{}
+	last       map[string]relayPacket
+	// keyframes holds the last VP8 keyframe of each stream, which late
+	// subscribers get in place of a last packet they could not decode.
+	keyframes map[string]relayPacket
+	controls  map[string][][]byte
+}
+
+type relayPacket struct {
+	typ        byte
+	sent       time.Time
+	transition *transition.Spec
+	data       []byte
+}
+
+type subscriber struct {
+	latest  chan relayPacket
+	control chan []byte
+	// waitKey is set, under the relay's lock, while VP8 interframes are
+	// withheld from the subscriber until the next keyframe.
+	waitKey bool
+}
+
+type publisher struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func NewRelay() *Relay {
+	return &Relay{
+		streams:    make(map[string]map[*subscriber]struct{}),
+		publishers: make(map[string]map[*publisher]struct{}),
+		last:       make(map[string]relayPacket),
+		keyframes:  make(map[string]relayPacket),
+		controls:   make(map[string][][]byte),
+	}
+}
+
+// Publish forwards a packet of the given type to every subscriber of
+// stream, preceded by a FrameTimestamp packet unless sent is zero and a
+// FrameTransition packet unless spec is nil. data must not be modified
+// afterwards.
+func (r *Relay) Publish(stream string, typ byte, sent time.Time, spec *transition.Spec, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := relayPacket{typ: typ, sent: sent, transition: spec, data: data}
+	r.last[stream] = p
+	if typ != FrameVP8 {
+		for sub := range r.streams[stream] {
+			sub.offer(p)
+		}
+		return
+	}
+	key := isVP8Keyframe(data)
+	if key {
+		r.keyframes[stream] = p
+	}
+	for sub := range r.streams[stream] {
+		sub.offerVP8(p, key)
+	}
+}
+
+// PublishControl forwards a FrameControl payload to every subscriber of
+// stream and remembers it for subscribers that join later. data must not
+// be modified afterwards.
+func (r *Relay) PublishControl(stream string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	controls := append(r.controls[stream], data)
+	if len(controls) > maxRelayControls {
+		controls = controls[len(controls)-maxRelayControls:]
+	}
+	r.controls[stream] = controls
+	for sub := range r.streams[stream] {
+		sub.offerControl(data)
+	}
+}
+
+// RequestKeyframe passes a subscriber's keyframe request on to every
+// publisher of stream.
+func (r *Relay) RequestKeyframe(stream string) {
+	r.mu.Lock()
+	pubs := make([]*publisher, 0, len(r.publishers[stream]))
+	for pub := range r.publishers[stream] {
+		pubs = append(pubs, pub)
+	}
+	r.mu.Unlock()
+
+	for _, pub := range pubs {
+		pub.mu.Lock()
+		err := WriteTypedPacket(pub.ws, FrameKeyframeRequest, nil)
+		pub.mu.Unlock()
+		if err != nil {
+			logging.Errorf("Error forwarding keyframe request: %v", err)
+		}
+	}
+}
+
+func (r *Relay) addPublisher(stream string, ws *websocket.Conn) *publisher {
+	pub := &publisher{ws: ws}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pubs := r.publishers[stream]
+	if pubs == nil {
+		pubs = make(map[*publisher]struct{})
+		r.publishers[stream] = pubs
+	}
+	pubs[pub] = struct{}{}
+	return pub
+}
+
+func (r *Relay) removePublisher(stream string, pub *publisher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.publishers[stream], pub)
+	if len(r.publishers[stream]) == 0 {
+		// Nothing is left to replay to late subscribers; forget the
+		// stream so that names used once do not pile up.
+		delete(r.publishers, stream)
+		delete(r.last, stream)
+		delete(r.keyframes, stream)
+		delete(r.controls, stream)
+	}
+}
+
+// subscribe adds a subscriber to stream and offers it the stream's
+// control messages and last frame. Joining a VP8 stream after an
+// interframe, it gets the last keyframe and the publishers are asked for
+// a new one.
+func (r *Relay) subscribe(stream string) *subscriber {
+	sub := &subscriber{
+		latest:  make(chan relayPacket, 1),
+		control: make(chan []byte, maxRelayControls),
+	}
+
+	r.mu.Lock()
+	subs := r.streams[stream]
+	if subs == nil {
+		subs = make(map[*subscriber]struct{})
+		r.streams[stream] = subs
+	}
+	subs[sub] = struct{}{}
+	for _, data := range r.controls[stream] {
+		sub.offerControl(data)
+	}
+	p, ok := r.last[stream]
+	if ok && p.typ == FrameVP8 && !isVP8Keyframe(p.data) {
+		sub.waitKey = true
+		p, ok = r.keyframes[stream]
+	}
+	if ok {
+		sub.offer(p)
+	}
+	waitKey := sub.waitKey
+	r.mu.Unlock()
+
+	if waitKey {
+		r.RequestKeyframe(stream)
+	}
+	return sub
+}
+
+func (r *Relay) unsubscribe(stream string, sub *subscriber) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.streams[stream], sub)
+	if len(r.streams[stream]) == 0 {
+		delete(r.streams, stream)
+	}
+}
+
+// offer replaces any frame the subscriber has not picked up yet.
+func (sub *subscriber) offer(p relayPacket) {
+	for {
+		select {
+		case sub.latest <- p:
+			return
+		default:
+		}
+		select {
+		case <-sub.latest:
+		default:
+		}
+	}
+}
+
+// offerVP8 offers a VP8 frame. If the subscriber has not picked up the
+// frame before it, the interframe is withheld rather than put in its
+// place, and so are the ones after it until the next keyframe.
+func (sub *subscriber) offerVP8(p relayPacket, key bool) {
+	if key {
+		sub.waitKey = false
+		sub.offer(p)
+		return
+	}
+	if sub.waitKey {
+		return
+	}
+	select {
+	case sub.latest <- p:
+	default:
+		sub.waitKey = true
+	}
+}
+
+// offerControl queues a control message, dropping it if the subscriber
+// has fallen too far behind to take it.
+func (sub *subscriber) offerControl(data []byte) {
+	select {
+	case sub.control <- data:
+	default:
+		logging.Errorf("Subscriber control queue full; dropping control message")
+	}
+}
+
+func (s *Server) handleSubscribe(ws *websocket.Conn) {
+	defer ws.Close()
+	stream := streamName(ws)
+	remote := ws.Request().RemoteAddr
+	logging.Infof("Subscriber connected: %s (stream %q)", remote, stream)
+
+	sub := s.relay.subscribe(stream)
+	defer s.relay.unsubscribe(stream, sub)
+
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		packets := NewPacketReader(ws)
+		for {
+			typ, data, err := packets.Next()
+			if err != nil {
+				return
+			}
+			putBuffer(data)
+			if typ == FrameKeyframeRequest {
+				s.relay.RequestKeyframe(stream)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-gone:
+			logging.Infof("Subscriber disconnected: %s (stream %q)", remote, stream)
+			return
+		case data := <-sub.control:
+			if err := WriteTypedPacket(ws, FrameControl, data); err != nil {
+				logging.Errorf("Error sending to subscriber %s: %v", remote, err)
+				return
+			}
+		case p := <-sub.latest:
+			if !p.sent.IsZero() {
+				if err := WriteTimestamp(ws, p.sent); err != nil {
+					logging.Errorf("Error sending to subscriber %s: %v", remote, err)
+					return
+				}
+			}
+			if p.transition != nil {
+				if err := WriteTransition(ws, *p.transition); err != nil {
+					logging.Errorf("Error sending to subscriber %s: %v", remote, err)
+					return
+				}
+			}
+			if err := WriteTypedPacket(ws, p.typ, p.data); err != nil {
+				logging.Errorf("Error sending to subscriber %s: %v", remote, err)
+				return
+			}
+		}
+	}
+}
+
+func streamName(ws *websocket.Conn) string {
+	if req := ws.Request(); req != nil {
+		if name := req.URL.Query().Get("stream"); name != "" {
+			return name
+		}
+	}
+	return defaultStream
+}
+
+// remoteAddr returns the address of the other end of ws. Connections the
+// receiver dialed have no request, so their location is used instead.
+func remoteAddr(ws *websocket.Conn) string {
+	if req := ws.Request(); req != nil && req.RemoteAddr != "" {
+		return req.RemoteAddr
+	}
+	return ws.RemoteAddr().String()
+}
@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// relayFrames is enough large frames to fill the socket buffers of a
+// subscriber that does not read.
+const (
+	relayFrames    = 128
+	relayFrameSize = 256 << 10
+)
+
+func TestRelayFansOutAndSkipsForSlowSubscribers(t *testing.T) {
+	s := NewRelayServer(0)
+	defer close(s.stopCh)
+	mux := http.NewServeMux()
+	mux.Handle("/stream", websocket.Handler(s.handleWebSocket))
+	mux.Handle("/subscribe", websocket.Handler(s.handleSubscribe))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dial := func(path string) *websocket.Conn {
+		t.Helper()
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+path, "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ws.Close() })
+		return ws
+	}
+	// waitUntil polls the relay's state, under its lock, until done
+	// reports true.
+	waitUntil := func(what string, done func(r *Relay) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			s.relay.mu.Lock()
+			ok := done(s.relay)
+			s.relay.mu.Unlock()
+			if ok {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	// receive reads frames from ws until the one numbered last and returns
+	// the numbers of the frames it got.
+	receive := func(ws *websocket.Conn, last uint32) ([]uint32, error) {
+		ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+		packets := NewPacketReader(ws)
+		var got []uint32
+		for {
+			typ, data, err := packets.Next()
+			if err != nil {
+				return got, err
+			}
+			if typ != FrameImage {
+				continue
+			}
+			n := binary.LittleEndian.Uint32(data)
+			putBuffer(data)
+			got = append(got, n)
+			if n == last {
+				return got, nil
+			}
+		}
+	}
+
+	fast := []*websocket.Conn{dial("/subscribe?stream=a"), dial("/subscribe?stream=a")}
+	slow := dial("/subscribe?stream=a")
+	other := dial("/subscribe?stream=b")
+	waitUntil("the subscribers", func(r *Relay) bool {
+		return len(r.streams["a"]) == 3 && len(r.streams["b"]) == 1
+	})
+
+	type result struct {
+		got []uint32
+		err error
+	}
+	results := make(chan result, len(fast))
+	for _, ws := range fast {
+		go func() {
+			got, err := receive(ws, relayFrames-1)
+			results <- result{got, err}
+		}()
+	}
+
+	pub := dial("/stream?stream=a")
+	frame := make([]byte, relayFrameSize)
+	for i := range relayFrames {
+		binary.LittleEndian.PutUint32(frame, uint32(i))
+		if err := WritePacket(pub, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The fast subscribers get frames up to the last, in order, even
+	// though the slow one has not read anything.
+	for range fast {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("fast subscriber: %v after frames %v", r.err, r.got)
+		}
+		for i := 1; i < len(r.got); i++ {
+			if r.got[i] <= r.got[i-1] {
+				t.Fatalf("fast subscriber got frame %d after %d", r.got[i], r.got[i-1])
+			}
+		}
+	}
+
+	// The slow subscriber skips to the newest frame once it catches up.
+	got, err := receive(slow, relayFrames-1)
+	if err != nil {
+		t.Fatalf("slow subscriber: %v after frames %v", err, got)
+	}
+	if len(got) >= relayFrames {
+		t.Errorf("slow subscriber got all %d frames; none were skipped", len(got))
+	}
+
+	// Frames published on stream a do not reach stream b.
+	other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if typ, _, err := NewPacketReader(other).Next(); err == nil {
+		t.Errorf("stream b subscriber got a packet of type %d from stream a", typ)
+	}
+
+	// Once its publisher leaves, the relay forgets the stream's last frame.
+	pub.Close()
+	waitUntil("the stream to be forgotten", func(r *Relay) bool {
+		_, ok := r.last["a"]
+		return !ok && len(r.publishers) == 0
+	})
+}
+
+func TestRelayWaitsForVP8Keyframes(t *testing.T) {
+	requests := make(chan byte, 1)
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		if typ, _, err := NewPacketReader(ws).Next(); err == nil {
+			requests <- typ
+		}
+	}))
+	defer ts.Close()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	r := NewRelay()
+	pub := r.addPublisher("v", ws)
+	defer r.removePublisher("v", pub)
+	// frame returns a FrameVP8 payload numbered n; only its frame tag
+	// byte matters to the relay.
+	frame := func(key bool, n byte) []byte {
+		tag := byte(1)
+		if key {
+			tag = 0
+		}
+		return vp8Payload([]byte{tag, n}, nil)
+	}
+	publish := func(key bool, n byte) {
+		r.Publish("v", FrameVP8, time.Time{}, nil, frame(key, n))
+	}
+	expect := func(sub *subscriber, want []byte) {
+		t.Helper()
+		var got []byte
+		select {
+		case p := <-sub.latest:
+			got = p.data
+		default:
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("subscriber got %v, want %v", got, want)
+		}
+	}
+
+	publish(true, 1)
+	publish(false, 2)
+
+	// A late subscriber gets the keyframe rather than the interframe
+	// after it, and the publisher is asked for a new keyframe.
+	sub := r.subscribe("v")
+	expect(sub, frame(true, 1))
+	select {
+	case typ := <-requests:
+		if typ != FrameKeyframeRequest {
+			t.Errorf("publisher got packet type %d, want a keyframe request", typ)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("publisher was not asked for a keyframe")
+	}
+
+	// Interframes are withheld until that keyframe arrives.
+	publish(false, 3)
+	expect(sub, nil)
+	publish(true, 4)
+	expect(sub, frame(true, 4))
+	publish(false, 5)
+	expect(sub, frame(false, 5))
+
+	// A subscriber that has not picked up a frame is not given the
+	// interframe after it in its place.
+	publish(false, 6)
+	publish(false, 7)
+	expect(sub, frame(false, 6))
+	publish(false, 8)
+	expect(sub, nil)
+	publish(true, 9)
+	expect(sub, frame(true, 9))
+}
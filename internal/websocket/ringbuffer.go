@@ -1,70 +1,346 @@
 package websocket
 
 import (
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/transition"
+)
+
+// PixelFormat is the memory layout of a decoded frame's pixels.
+type PixelFormat uint8
+
+const (
+	// PixelBGRAPremultiplied is 8-bit blue, green, red and alpha, with
+	// the colors premultiplied by alpha. Every decoded frame uses it.
+	PixelBGRAPremultiplied PixelFormat = iota
 )
 
+func (f PixelFormat) String() string {
+	switch f {
+	case PixelBGRAPremultiplied:
+		return "bgra-premultiplied"
+	}
+	return "unknown"
+}
+
+// FrameInfo describes where a frame came from and how it was decoded.
+// Fields the server could not know, such as the sender's timestamp on a
+// stream that sends none, are left zero.
+type FrameInfo struct {
+	// Seq is the frame's arrival order across all connections.
+	Seq    uint64
+	Stream string
+	// ConnID identifies the connection the frame was received on. IDs
+	// are assigned from 1 in the order connections are made.
+	ConnID uint64
+	// Sent is when the sender captured the frame, from the FrameTimestamp
+	// packet preceding it.
+	Sent     time.Time
+	Received time.Time
+	// Decode is the time spent decoding the frame and applying the
+	// stream's filters and mask.
+	Decode time.Duration
+	// Format is the name of the codec the payload was encoded with, and
+	// EncodedSize its size in bytes.
+	Format      string
+	EncodedSize int
+	PixelFormat PixelFormat
+	// Transition is how the frame replaces the one before it on screen,
+	// as set for the stream when the frame arrived.
+	Transition transition.Spec
+}
+
+// Frame is a decoded premultiplied BGRA frame. Frames handed out by
+// RingBuffer.Acquire are leased: their fields do not change and their
+// pixels are not reused until the lease is released.
 type Frame struct {
 	Data   []byte
 	Width  int
 	Height int
+	FrameInfo
+	// refs counts the ring slot holding the frame and every lease on it.
+	refs atomic.Int32
 }
 
+// Release ends a lease taken with RingBuffer.Acquire. The frame must not
+// be used afterwards.
+func (f *Frame) Release() {
+	if f.refs.Add(-1) == 0 {
+		putBuffer(f.Data)
+		f.Data = nil
+	}
+}
+
+// DefaultRingDepth is the number of frames a RingBuffer holds unless
+// configured otherwise.
+const DefaultRingDepth = 3
+
+// RingOptions configures a RingBuffer.
+type RingOptions struct {
+	// Depth is the number of frames held; 0 means DefaultRingDepth.
+	Depth  int
+	Policy config.RingPolicy
+}
+
+// RingOptionsFromConfig returns the ring buffer options cfg sets.
+func RingOptionsFromConfig(cfg config.Config) RingOptions {
+	return RingOptions{Depth: cfg.RingDepth, Policy: cfg.RingPolicy}
+}
+
+// RingBuffer holds the most recent decoded frames. Writers never touch a
+// frame that is leased to a reader: a leased slot is given a fresh frame
+// and the old one's buffer is recycled when its last lease is released.
+// Slots start empty and take the buffers the decoders fill, which come
+// from the buffer pool, so there is nothing to allocate up front.
+//
+// Frames are read by Acquire according to the buffer's policy: the newest
+// frame, or the oldest one not yet read. Frames that are written over
+// before they are read are counted as overwritten.
 type RingBuffer struct {
-	frames    [3]*Frame
-	writeIdx  uint64
-	readIdx   uint64
-	mu        sync.RWMutex
-	hasFrames atomic.Bool
+	frames []*Frame
+	policy config.RingPolicy
+	// writeIdx counts the frames written and readIdx those read or
+	// overwritten, so writeIdx-readIdx frames are pending.
+	writeIdx    uint64
+	readIdx     uint64
+	lastSeq     uint64
+	mu          sync.RWMutex
+	hasFrames   atomic.Bool
+	overwritten atomic.Uint64
+	// subscribers are signalled, under mu, whenever a frame is written.
+	subscribers []chan struct{}
 }
 
+// NewRingBuffer returns a latest-wins buffer of DefaultRingDepth frames.
 func NewRingBuffer() *RingBuffer {
-	rb := &RingBuffer{}
+	return NewRingBufferWith(RingOptions{})
+}
+
+func NewRingBufferWith(opts RingOptions) *RingBuffer {
+	depth := opts.Depth
+	if depth <= 0 {
+		depth = DefaultRingDepth
+	}
+	rb := &RingBuffer{frames: make([]*Frame, depth), policy: opts.Policy}
 	for i := range rb.frames {
-		rb.frames[i] = &Frame{
-			Data: make([]byte, 0, 4*1024*1024),
-		}
+		rb.frames[i] = newFrame(nil)
 	}
 	return rb
 }
 
+// newFrame returns a frame holding data with the ring's reference on it.
+func newFrame(data []byte) *Frame {
+	f := &Frame{Data: data}
+	f.refs.Store(1)
+	return f
+}
+
+// Write copies in a frame that carries no metadata beyond the next
+// sequence number.
 func (rb *RingBuffer) Write(data []byte, width, height int) {
 	rb.mu.Lock()
-	idx := rb.writeIdx % 3
-	frame := rb.frames[idx]
-	if cap(frame.Data) < len(data) {
-		frame.Data = make([]byte, len(data))
-	} else {
-		frame.Data = frame.Data[:len(data)]
+	rb.write(FrameInfo{Seq: rb.lastSeq + 1}, data, width, height)
+	rb.mu.Unlock()
+}
+
+// WriteSeq stores a frame only if info.Seq is newer than the last frame
+// written, so frames decoded out of order never replace newer ones. It
+// reports whether the frame was stored.
+//
+// Unlike Write, WriteSeq takes ownership of data: the slot adopts the
+// slice and its previous buffer goes back to the buffer pool once no
+// reader holds it, as does data itself if the frame is rejected.
+func (rb *RingBuffer) WriteSeq(info FrameInfo, data []byte, width, height int) bool {
+	rb.mu.Lock()
+	if info.Seq <= rb.lastSeq {
+		rb.mu.Unlock()
+		putBuffer(data)
+		return false
+	}
+	frame, old := rb.slot()
+	frame.Data = data
+	frame.Width = width
+	frame.Height = height
+	frame.FrameInfo = info
+	rb.advance(info.Seq)
+	rb.mu.Unlock()
+
+	putBuffer(old)
+	return true
+}
+
+// Update copies in a frame only while seq is still the newest sequence
+// number written, letting a looping animation replace its own frames
+// until a newer frame arrives. The frame keeps the metadata of the one it
+// replaces. It reports whether the frame was stored.
+func (rb *RingBuffer) Update(seq uint64, data []byte, width, height int) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if seq != rb.lastSeq || rb.writeIdx == 0 {
+		return false
 	}
+	rb.write(rb.latest().FrameInfo, data, width, height)
+	return true
+}
+
+func (rb *RingBuffer) write(info FrameInfo, data []byte, width, height int) {
+	frame, old := rb.slot()
+	if cap(old) < len(data) {
+		putBuffer(old)
+		old = getBuffer(len(data))
+	}
+	frame.Data = old[:len(data)]
 	copy(frame.Data, data)
 	frame.Width = width
 	frame.Height = height
+	frame.FrameInfo = info
+	rb.advance(info.Seq)
+}
+
+// slot returns the frame to write next and the buffer it held, which the
+// caller reuses or releases. A frame still leased to a reader is left to
+// that reader and replaced with a new one. rb.mu must be held.
+func (rb *RingBuffer) slot() (*Frame, []byte) {
+	idx := rb.writeIdx % uint64(len(rb.frames))
+	frame := rb.frames[idx]
+	// Leases are only taken with rb.mu held, so a frame referenced by its
+	// slot alone stays unleased while the write lock is held.
+	if frame.refs.Load() == 1 {
+		return frame, frame.Data
+	}
+	frame.Release()
+	frame = newFrame(nil)
+	rb.frames[idx] = frame
+	return frame, nil
+}
+
+func (rb *RingBuffer) advance(seq uint64) {
+	// A latest-wins buffer has at most the newest frame pending; a FIFO
+	// one as many as it holds.
+	pending := uint64(1)
+	if rb.policy == config.FIFO {
+		pending = uint64(len(rb.frames))
+	}
+	if rb.writeIdx-rb.readIdx >= pending {
+		rb.readIdx++
+		rb.overwritten.Add(1)
+	}
 	rb.writeIdx++
+	rb.lastSeq = seq
 	rb.hasFrames.Store(true)
+	rb.notify()
+}
+
+// Subscribe returns a channel that is signalled whenever a frame is
+// written, until cancel is called. Signals coalesce: a subscriber that
+// falls behind finds one pending signal however many frames arrived, and
+// should Acquire frames until none is Pending when it wakes.
+func (rb *RingBuffer) Subscribe() (frames <-chan struct{}, cancel func()) {
+	ch := make(chan struct{}, 1)
+	rb.mu.Lock()
+	rb.subscribers = append(rb.subscribers, ch)
 	rb.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			rb.mu.Lock()
+			defer rb.mu.Unlock()
+			rb.subscribers = slices.DeleteFunc(rb.subscribers, func(c chan struct{}) bool { return c == ch })
+		})
+	}
+}
+
+// Notify signals subscribers without writing a frame, for when the newest
+// frame should be presented again, e.g. because the window was resized.
+func (rb *RingBuffer) Notify() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.notify()
+}
+
+func (rb *RingBuffer) notify() {
+	for _, ch := range rb.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Acquire reads the next frame and leases it to the caller, who must
+// Release it when done. The frame's pixels are shared, not copied, and
+// stay untouched by writers until then.
+//
+// The next frame is the newest one, or with the FIFO policy the oldest
+// one pending. With no frame pending, the frame read last is returned
+// again.
+func (rb *RingBuffer) Acquire() (*Frame, bool) {
+	if !rb.hasFrames.Load() {
+		return nil, false
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.writeIdx == 0 {
+		return nil, false
+	}
+	frame := rb.latest()
+	if rb.policy == config.FIFO && rb.readIdx < rb.writeIdx {
+		frame = rb.frames[rb.readIdx%uint64(len(rb.frames))]
+		rb.readIdx++
+	} else {
+		rb.readIdx = rb.writeIdx
+	}
+	return lease(frame)
 }
 
-func (rb *RingBuffer) ReadLatest() (*Frame, bool) {
+// AcquireLatest leases the newest frame like Acquire, without reading it:
+// it stays pending for Acquire.
+func (rb *RingBuffer) AcquireLatest() (*Frame, bool) {
 	if !rb.hasFrames.Load() {
 		return nil, false
 	}
 	rb.mu.RLock()
+	defer rb.mu.RUnlock()
 	if rb.writeIdx == 0 {
-		rb.mu.RUnlock()
 		return nil, false
 	}
-	idx := (rb.writeIdx - 1) % 3
-	frame := rb.frames[idx]
+	return lease(rb.latest())
+}
+
+// lease takes a reference on frame for a reader. The ring's mutex must be
+// held.
+func lease(frame *Frame) (*Frame, bool) {
 	if len(frame.Data) == 0 {
-		rb.mu.RUnlock()
 		return nil, false
 	}
-	rb.mu.RUnlock()
+	frame.refs.Add(1)
 	return frame, true
 }
 
+// Pending returns the number of frames written but not yet read.
+func (rb *RingBuffer) Pending() int {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return int(rb.writeIdx - rb.readIdx)
+}
+
+// Overwritten returns the number of frames that were written over before
+// they were read, and so never presented.
+func (rb *RingBuffer) Overwritten() uint64 {
+	return rb.overwritten.Load()
+}
+
+// latest returns the newest frame. rb.mu must be held and a frame must
+// have been written.
+func (rb *RingBuffer) latest() *Frame {
+	return rb.frames[(rb.writeIdx-1)%uint64(len(rb.frames))]
+}
+
 func (rb *RingBuffer) HasFrames() bool {
 	return rb.hasFrames.Load()
 }
@@ -0,0 +1,277 @@
+package websocket
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+)
+
+// filledFrame returns a width x 1 frame from the buffer pool with every
+// byte set to v.
+func filledFrame(v byte, width int) []byte {
+	b := getBuffer(width * 4)
+	for i := range b {
+		b[i] = v
+	}
+	return b
+}
+
+func uniform(b []byte, v byte) bool {
+	for _, c := range b {
+		if c != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRingBufferLeasedFrameIsNotReused(t *testing.T) {
+	rb := NewRingBuffer()
+	rb.Write(bytes.Repeat([]byte{1}, 16), 4, 1)
+
+	leased, ok := rb.Acquire()
+	if !ok {
+		t.Fatal("no frame")
+	}
+	// Enough writes to come back around to the leased slot several times.
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			rb.Write(bytes.Repeat([]byte{2}, 32), 8, 1)
+		} else {
+			rb.WriteSeq(FrameInfo{Seq: rb.lastSeq + 1}, filledFrame(3, 8), 8, 1)
+		}
+	}
+	if leased.Width != 4 || !bytes.Equal(leased.Data, bytes.Repeat([]byte{1}, 16)) {
+		t.Errorf("leased frame changed to %dx1 %v", leased.Width, leased.Data)
+	}
+	leased.Release()
+
+	latest, _ := rb.Acquire()
+	defer latest.Release()
+	if latest.Width != 8 || latest.Data[0] != 3 {
+		t.Errorf("latest frame = %dx1 starting %d, want 8x1 starting 3", latest.Width, latest.Data[0])
+	}
+}
+
+func TestRingBufferReusesUnleasedFrames(t *testing.T) {
+	rb := NewRingBuffer()
+	data := make([]byte, 64*64*4)
+	for range rb.frames {
+		rb.Write(data, 64, 64)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		rb.Write(data, 64, 64)
+		frame, _ := rb.Acquire()
+		frame.Release()
+	})
+	if allocs != 0 {
+		t.Errorf("Write and Acquire allocated %.1f times per frame, want 0", allocs)
+	}
+}
+
+// TestRingBufferConcurrentLeases checks, best run with -race, that readers
+// never see a frame change under them while writers replace frames of
+// varying sizes as fast as they can.
+func TestRingBufferConcurrentLeases(t *testing.T) {
+	duration := 300 * time.Millisecond
+	if testing.Short() {
+		duration = 50 * time.Millisecond
+	}
+	rb := NewRingBuffer()
+	stop := make(chan struct{})
+	var writers, readers sync.WaitGroup
+
+	var seqMu sync.Mutex
+	var seq uint64
+	nextSeq := func() uint64 {
+		seqMu.Lock()
+		defer seqMu.Unlock()
+		seq++
+		return seq
+	}
+	for w := 0; w < 3; w++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				v := byte(i)
+				width := 256 + int(v)
+				switch i % 3 {
+				case 0:
+					rb.WriteSeq(FrameInfo{Seq: nextSeq()}, filledFrame(v, width), width, 1)
+				case 1:
+					rb.Write(bytes.Repeat([]byte{v}, width*4), width, 1)
+				default:
+					data := bytes.Repeat([]byte{v}, width*4)
+					rb.mu.RLock()
+					last := rb.lastSeq
+					rb.mu.RUnlock()
+					rb.Update(last, data, width, 1)
+				}
+			}
+		}()
+	}
+
+	var torn sync.Once
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				frame, ok := rb.Acquire()
+				if !ok {
+					continue
+				}
+				// Checking twice gives writers time to scribble on the frame.
+				width, v := frame.Width, frame.Data[0]
+				for i := 0; i < 2; i++ {
+					if len(frame.Data) != width*4 || width != 256+int(v) || !uniform(frame.Data, v) {
+						torn.Do(func() { t.Errorf("torn frame: width %d, %d bytes, first byte %d", width, len(frame.Data), v) })
+						break
+					}
+				}
+				frame.Release()
+			}
+		}()
+	}
+
+	time.Sleep(duration)
+	close(stop)
+	writers.Wait()
+	readers.Wait()
+}
+
+func TestRingBufferSubscribe(t *testing.T) {
+	rb := NewRingBuffer()
+	a, cancelA := rb.Subscribe()
+	b, cancelB := rb.Subscribe()
+	defer cancelB()
+
+	signalled := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+	if signalled(a) || signalled(b) {
+		t.Fatal("signalled before any frame was written")
+	}
+
+	// Several writes leave a single pending signal for each subscriber.
+	for i := 0; i < 3; i++ {
+		rb.Write(make([]byte, 4), 1, 1)
+	}
+	if !signalled(a) || !signalled(b) {
+		t.Error("subscribers were not signalled of new frames")
+	}
+	if signalled(a) || signalled(b) {
+		t.Error("signals did not coalesce")
+	}
+
+	cancelA()
+	cancelA()
+	rb.WriteSeq(FrameInfo{Seq: rb.lastSeq + 1}, getBuffer(4), 1, 1)
+	if signalled(a) {
+		t.Error("cancelled subscriber was signalled")
+	}
+	if !signalled(b) {
+		t.Error("WriteSeq did not signal")
+	}
+	rb.Notify()
+	if !signalled(b) {
+		t.Error("Notify did not signal")
+	}
+}
+
+// readWidths acquires frames until none is pending and returns their
+// widths.
+func readWidths(rb *RingBuffer) []int {
+	var widths []int
+	for rb.Pending() > 0 {
+		frame, _ := rb.Acquire()
+		widths = append(widths, frame.Width)
+		frame.Release()
+	}
+	return widths
+}
+
+func TestRingBufferLatestWins(t *testing.T) {
+	rb := NewRingBuffer()
+	for width := 1; width <= 4; width++ {
+		rb.Write(make([]byte, width*4), width, 1)
+	}
+	if got := readWidths(rb); len(got) != 1 || got[0] != 4 {
+		t.Errorf("read widths %v, want [4]", got)
+	}
+	if n := rb.Overwritten(); n != 3 {
+		t.Errorf("Overwritten = %d, want 3", n)
+	}
+
+	// Reading again with nothing pending shows the same frame again.
+	frame, _ := rb.Acquire()
+	defer frame.Release()
+	if frame.Width != 4 || rb.Overwritten() != 3 {
+		t.Errorf("re-read width %d, %d overwritten", frame.Width, rb.Overwritten())
+	}
+}
+
+func TestRingBufferFIFO(t *testing.T) {
+	rb := NewRingBufferWith(RingOptions{Depth: 4, Policy: config.FIFO})
+	for width := 1; width <= 6; width++ {
+		rb.Write(make([]byte, width*4), width, 1)
+	}
+
+	// A snapshot of the newest frame does not take it out of the queue.
+	latest, _ := rb.AcquireLatest()
+	if latest.Width != 6 {
+		t.Errorf("AcquireLatest width = %d, want 6", latest.Width)
+	}
+	latest.Release()
+
+	got := readWidths(rb)
+	if want := []int{3, 4, 5, 6}; !slices.Equal(got, want) {
+		t.Errorf("read widths %v, want %v", got, want)
+	}
+	if n := rb.Overwritten(); n != 2 {
+		t.Errorf("Overwritten = %d, want 2", n)
+	}
+
+	rb.Write(make([]byte, 28), 7, 1)
+	frame, _ := rb.Acquire()
+	frame.Release()
+	frame, _ = rb.Acquire()
+	defer frame.Release()
+	if frame.Width != 7 {
+		t.Errorf("re-read width %d, want 7", frame.Width)
+	}
+}
+
+func TestRingOptionsFromConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RingDepth = 5
+	cfg.RingPolicy = config.FIFO
+	if opts := RingOptionsFromConfig(cfg); opts.Depth != 5 || opts.Policy != config.FIFO {
+		t.Errorf("options = %+v", opts)
+	}
+
+	rb := NewRingBufferWith(RingOptionsFromConfig(cfg))
+	if len(rb.frames) != 5 {
+		t.Fatalf("depth = %d, want 5", len(rb.frames))
+	}
+}
@@ -1,29 +1,97 @@
 package websocket
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/example/bidirect/internal/chroma"
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/filter"
 	"github.com/example/bidirect/internal/logging"
+	"github.com/example/bidirect/internal/mask"
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/transition"
+	"github.com/example/bidirect/internal/vp8"
 	"golang.org/x/net/websocket"
 )
 
 type Server struct {
 	port       int
 	ringBuffer *RingBuffer
+	relay      *Relay
+	recorder   *Recorder
+	metrics    Metrics
+	workers    int
+	latestOnly bool
+	limits     Limits
+	jobs       chan decodeJob
+	seq        atomic.Uint64
+	latestSeq  atomic.Uint64
+	controlMu  sync.RWMutex
+	fit        scale.Mode
+	filter     scale.Filter
+	chromaKey  chroma.Key
+	shapeMask  *mask.Mask
+	formats    map[string][]string
+	filters    map[string]*filter.Pipeline
+	hud        bool
+	// transition applies to streams without one of their own in
+	// transitions.
+	transition  transition.Spec
+	transitions map[string]transition.Spec
+	connIDs     atomic.Uint64
+	// publisher is the address of the connection that last sent frames.
+	publisher atomic.Value
+	// publishers counts the connections frames are decoded from, and
+	// pubChanged is when that count last changed.
+	pubMu      sync.Mutex
+	publishers int
+	pubChanged time.Time
 	httpServer *http.Server
 	wg         sync.WaitGroup
 	stopCh     chan struct{}
 }
 
-func NewServer(port int) *Server {
+func NewServer(cfg config.Config) *Server {
+	workers := cfg.DecodeWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Server{
+		port:        cfg.WSPort,
+		ringBuffer:  NewRingBufferWith(RingOptionsFromConfig(cfg)),
+		workers:     workers,
+		latestOnly:  cfg.LatestOnly,
+		limits:      LimitsFromConfig(cfg),
+		fit:         cfg.Fit,
+		filter:      cfg.ScaleFilter,
+		chromaKey:   cfg.ChromaKey,
+		filters:     make(map[string]*filter.Pipeline),
+		hud:         cfg.HUD,
+		transition:  transition.Spec{Effect: cfg.Transition, Duration: cfg.TransitionDuration},
+		transitions: make(map[string]transition.Spec),
+		formats:     maps.Clone(cfg.Formats),
+		pubChanged:  time.Now(),
+		jobs:        make(chan decodeJob, workers),
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// NewRelayServer creates a server that forwards frames published on
+// /stream to the receivers subscribed on /subscribe, without decoding them.
+func NewRelayServer(port int) *Server {
 	return &Server{
-		port:       port,
-		ringBuffer: NewRingBuffer(),
-		stopCh:     make(chan struct{}),
+		port:   port,
+		relay:  NewRelay(),
+		stopCh: make(chan struct{}),
 	}
 }
 
@@ -32,12 +100,23 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/", s.serveHTML)
 	mux.HandleFunc("/client.js", s.serveJS)
 	mux.Handle("/stream", websocket.Handler(s.handleWebSocket))
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	if s.relay != nil {
+		mux.Handle("/subscribe", websocket.Handler(s.handleSubscribe))
+	} else {
+		mux.HandleFunc("/snapshot", s.serveSnapshot)
+		mux.HandleFunc("/filters", s.serveFilters)
+	}
 
 	s.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
 		Handler: mux,
 	}
 
+	if s.relay == nil {
+		s.startDecoders()
+	}
+
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
@@ -56,16 +135,101 @@ func (s *Server) Stop() {
 		s.httpServer.Close()
 	}
 	s.wg.Wait()
+	if s.recorder != nil {
+		if err := s.recorder.Close(); err != nil {
+			logging.Errorf("Error closing recording: %v", err)
+		}
+	}
+}
+
+// Record appends every payload received from now on to the file at path.
+// The file is closed by Stop.
+func (s *Server) Record(path string) error {
+	rec, err := NewRecorder(path)
+	if err != nil {
+		return err
+	}
+	s.recorder = rec
+	logging.Infof("Recording stream to %s", path)
+	return nil
 }
 
 func (s *Server) GetRingBuffer() *RingBuffer {
 	return s.ringBuffer
 }
 
+func (s *Server) Metrics() *Metrics {
+	return &s.metrics
+}
+
+// Publisher returns the address of the connection that last sent frames.
+func (s *Server) Publisher() string {
+	addr, _ := s.publisher.Load().(string)
+	return addr
+}
+
+// Publishers returns the number of connections frames are received on and
+// when that number last changed. Subscribers to the ring buffer are
+// signalled whenever it changes.
+func (s *Server) Publishers() (int, time.Time) {
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+	return s.publishers, s.pubChanged
+}
+
+func (s *Server) addPublishers(n int) {
+	s.pubMu.Lock()
+	s.publishers += n
+	s.pubChanged = time.Now()
+	s.pubMu.Unlock()
+	s.ringBuffer.Notify()
+}
+
+// publish writes a decoded frame to the ring buffer unless a newer one
+// got there first.
+func (s *Server) publish(info FrameInfo, data []byte, width, height int) bool {
+	if !s.ringBuffer.WriteSeq(info, data, width, height) {
+		s.metrics.FramesLate.Add(1)
+		return false
+	}
+	// Transit times made negative by clock skew between the two ends are
+	// left out rather than counted as zero.
+	if transit := info.Received.Sub(info.Sent); !info.Sent.IsZero() && transit >= 0 {
+		s.metrics.FramesTimestamped.Add(1)
+		s.metrics.TransitNanos.Add(uint64(transit))
+	}
+	return true
+}
+
 func (s *Server) handleWebSocket(ws *websocket.Conn) {
 	defer ws.Close()
 	logging.Infof("WebSocket client connected: %s", ws.Request().RemoteAddr)
+	s.receiveFrames(ws)
+}
+
+func (s *Server) receiveFrames(ws *websocket.Conn) {
+	s.metrics.Connections.Add(1)
+	defer s.metrics.Connections.Add(-1)
+
+	stream := streamName(ws)
+	if s.relay != nil {
+		pub := s.relay.addPublisher(stream, ws)
+		defer s.relay.removePublisher(stream, pub)
+	} else {
+		s.publisher.Store(remoteAddr(ws))
+		s.addPublishers(1)
+		defer s.addPublishers(-1)
+	}
 
+	conn := newPublisherConn(s.connIDs.Add(1), ws, s.limits)
+	video := vp8Stream{limits: s.limits}
+	packets := NewPacketReader(ws)
+	// sent and next are the sender's timestamp and transition for the
+	// next frame, if it sent them.
+	var (
+		sent time.Time
+		next *transition.Spec
+	)
 	for {
 		select {
 		case <-s.stopCh:
@@ -73,43 +237,253 @@ func (s *Server) handleWebSocket(ws *websocket.Conn) {
 		default:
 		}
 
-		var sizeBytes [4]byte
-		if _, err := io.ReadFull(ws, sizeBytes[:]); err != nil {
+		typ, data, err := packets.Next()
+		if err != nil {
 			if err != io.EOF {
-				logging.Errorf("Error reading size: %v", err)
+				logging.Errorf("Error reading frame: %v", err)
 			}
 			return
 		}
-
-		size := binary.LittleEndian.Uint32(sizeBytes[:])
-		if size == 0 || size > 10*1024*1024 {
-			logging.Errorf("Invalid frame size: %d", size)
-			return
+		if typ == FrameKeyframeRequest {
+			continue
 		}
 
-		webpData := make([]byte, size)
-		if _, err := io.ReadFull(ws, webpData); err != nil {
-			logging.Errorf("Error reading frame data: %v", err)
-			return
+		if s.recorder != nil {
+			if err := s.recorder.Write(typ, data); err != nil {
+				logging.Errorf("Error recording frame: %v", err)
+			}
 		}
+		if typ == FrameControl {
+			s.handleControl(stream, data)
+			continue
+		}
+		if typ == FrameTimestamp {
+			if sent, err = parseTimestamp(data); err != nil {
+				logging.Errorf("Error reading frame timestamp: %v", err)
+			}
+			putBuffer(data)
+			continue
+		}
+		if typ == FrameTransition {
+			spec, err := parseTransition(data)
+			if err != nil {
+				logging.Errorf("Error reading frame transition: %v", err)
+			} else {
+				next = &spec
+			}
+			putBuffer(data)
+			continue
+		}
+		info := FrameInfo{
+			Stream:      stream,
+			ConnID:      conn.id,
+			Sent:        sent,
+			Received:    time.Now(),
+			EncodedSize: len(data),
+			Transition:  s.StreamTransition(stream),
+		}
+		spec := next
+		if spec != nil {
+			info.Transition = *spec
+		}
+		sent, next = time.Time{}, nil
+		s.metrics.FramesReceived.Add(1)
+		s.metrics.BytesReceived.Add(uint64(len(data)))
 
-		if err := s.processFrame(webpData); err != nil {
-			logging.Errorf("Error processing frame: %v", err)
+		if s.relay != nil {
+			// The relay keeps payloads alive for its subscribers, so they
+			// are left to the garbage collector instead of the pool.
+			s.relay.Publish(stream, typ, info.Sent, spec, data)
 			continue
 		}
+
+		switch typ {
+		case FrameImage:
+			s.submitFrame(conn, info, data)
+		case FrameVP8:
+			s.processVP8(conn, &video, info, data)
+		default:
+			putBuffer(data)
+			logging.Errorf("Ignoring packet of unknown type %d", typ)
+		}
+	}
+}
+
+// processVP8 decodes a VP8 frame on the connection's goroutine, asking the
+// sender for a keyframe when the frame cannot be decoded. Interframes that
+// cannot be decoded until the next keyframe are dropped.
+func (s *Server) processVP8(conn *publisherConn, video *vp8Stream, info FrameInfo, data []byte) {
+	info.Seq = s.nextSeq()
+	info.Format = "vp8"
+	start := time.Now()
+	bgraData, width, height, err := video.decode(data)
+	putBuffer(data)
+	if err != nil {
+		switch {
+		case errors.Is(err, vp8.ErrNoKeyFrame):
+			s.metrics.InterframesDropped.Add(1)
+		case errors.Is(err, ErrLimitExceeded):
+			s.metrics.LimitRejections.Add(1)
+			conn.reject(err)
+			return
+		default:
+			s.metrics.DecodeErrors.Add(1)
+			logging.Errorf("Error decoding VP8 frame: %v", err)
+		}
+		if video.shouldRequestKeyframe() {
+			s.metrics.KeyframeRequests.Add(1)
+			if err := WriteTypedPacket(conn.ws, FrameKeyframeRequest, nil); err != nil {
+				logging.Errorf("Error requesting keyframe: %v", err)
+			}
+		}
+		return
+	}
+	if bgraData == nil {
+		return
 	}
+	bgraData, width, height = s.finishFrame(info.Stream, bgraData, width, height)
+	info.Decode = time.Since(start)
+	s.metrics.FramesDecoded.Add(1)
+	s.metrics.DecodeNanos.Add(uint64(info.Decode))
+	s.publish(info, bgraData, width, height)
 }
 
-func (s *Server) processFrame(webpData []byte) error {
-	bgraData, width, height, err := DecodeImageToBGRA(webpData)
+// processFrame decodes a queued still or animated image and publishes it.
+// The decoded size is reserved on the connection's memory budget for as
+// long as the frames are held; a LimitError means the connection should
+// be rejected.
+func (s *Server) processFrame(job decodeJob) error {
+	defer putBuffer(job.data)
+	start := time.Now()
+	codec, err := SniffCodec(job.data)
 	if err != nil {
-		return fmt.Errorf("decode failed: %w", err)
+		return s.decodeFailed(err)
+	}
+	info := job.info
+	info.Format = codec.Name
+	if !s.acceptsFormat(info.Stream, codec.Name) {
+		s.metrics.FormatsRejected.Add(1)
+		return fmt.Errorf("%s frames are not accepted on stream %q", codec.Name, info.Stream)
+	}
+	cfg, err := codec.checkSize(job.data, s.limits)
+	if err != nil {
+		return s.decodeFailed(err)
+	}
+	// Animations decoded all at once are reserved in full before decoding.
+	reserved, err := codec.animationCost(job.data, cfg, s.limits)
+	if err != nil {
+		return s.decodeFailed(err)
+	}
+	if err := job.conn.budget.reserve(reserved); err != nil {
+		return s.decodeFailed(err)
+	}
+	defer func() { job.conn.budget.release(reserved) }()
+
+	anim, err := codec.decodeAnimation(job.data, s.limits)
+	if err != nil {
+		return s.decodeFailed(err)
+	}
+	if anim != nil {
+		// The reservation is handed over to the animation and resized to
+		// the frames it holds.
+		held := frameBytes(anim.Width, anim.Height) * int64(len(anim.Frames))
+		if held > reserved {
+			if err := job.conn.budget.reserve(held - reserved); err != nil {
+				return s.decodeFailed(err)
+			}
+		} else {
+			job.conn.budget.release(reserved - held)
+		}
+		reserved = 0
+		release := func() { job.conn.budget.release(held) }
+		width, height := anim.Width, anim.Height
+		for i, frame := range anim.Frames {
+			anim.Frames[i], anim.Width, anim.Height = s.finishFrame(info.Stream, frame, width, height)
+		}
+		info.Decode = time.Since(start)
+		s.metrics.FramesDecoded.Add(1)
+		s.metrics.DecodeNanos.Add(uint64(info.Decode))
+		s.startAnimation(info, anim, release)
+		return nil
 	}
 
-	s.ringBuffer.Write(bgraData, width, height)
+	bgraData, width, height, err := codec.decodeBGRA(job.data)
+	if err != nil {
+		return s.decodeFailed(err)
+	}
+	bgraData, width, height = s.finishFrame(info.Stream, bgraData, width, height)
+	info.Decode = time.Since(start)
+	s.metrics.FramesDecoded.Add(1)
+	s.metrics.DecodeNanos.Add(uint64(info.Decode))
+	s.publish(info, bgraData, width, height)
 	return nil
 }
 
+// acceptsFormat reports whether frames of the named format may be decoded
+// on stream. Streams without an allow-list of their own use the one set
+// for all streams, if any.
+func (s *Server) acceptsFormat(stream, format string) bool {
+	s.controlMu.RLock()
+	defer s.controlMu.RUnlock()
+	allowed, ok := s.formats[stream]
+	if !ok {
+		allowed, ok = s.formats[""]
+	}
+	return !ok || slices.Contains(allowed, format)
+}
+
+// decodeFailed counts a frame that could not be decoded.
+func (s *Server) decodeFailed(err error) error {
+	if errors.Is(err, ErrLimitExceeded) {
+		s.metrics.LimitRejections.Add(1)
+	} else {
+		s.metrics.DecodeErrors.Add(1)
+	}
+	return err
+}
+
+// startAnimation shows the first frame of anim and keeps looping it on the
+// receiver until it finishes or a newer frame replaces it. done is called
+// once the animation's frames are no longer needed.
+func (s *Server) startAnimation(info FrameInfo, anim *Animation, done func()) {
+	first := getBuffer(len(anim.Frames[0]))
+	copy(first, anim.Frames[0])
+	if !s.publish(info, first, anim.Width, anim.Height) || len(anim.Frames) == 1 {
+		done()
+		return
+	}
+	logging.Infof("Playing animation: %d frames, %dx%d", len(anim.Frames), anim.Width, anim.Height)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer done()
+		timer := time.NewTimer(anim.Delays[0])
+		defer timer.Stop()
+
+		i, loop := 0, 0
+		for {
+			select {
+			case <-s.stopCh:
+				return
+			case <-timer.C:
+			}
+			i++
+			if i == len(anim.Frames) {
+				loop++
+				if anim.LoopCount > 0 && loop >= anim.LoopCount {
+					return
+				}
+				i = 0
+			}
+			if !s.ringBuffer.Update(info.Seq, anim.Frames[i], anim.Width, anim.Height) {
+				return
+			}
+			timer.Reset(anim.Delays[i])
+		}
+	}()
+}
+
 func (s *Server) serveHTML(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write([]byte(htmlPage))
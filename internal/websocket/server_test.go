@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"golang.org/x/net/websocket"
+)
+
+func TestServerCountsPublishers(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	frames, cancel := s.ringBuffer.Subscribe()
+	defer cancel()
+	ts := httptest.NewServer(websocket.Handler(s.handleWebSocket))
+	defer ts.Close()
+
+	if n, _ := s.Publishers(); n != 0 {
+		t.Fatalf("Publishers = %d before any connection", n)
+	}
+	_, started := s.Publishers()
+
+	// waitFor waits for the ring buffer to be signalled and the count of
+	// publishers to reach want, and returns when it last changed.
+	waitFor := func(want int) time.Time {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case <-frames:
+			case <-deadline:
+				t.Fatalf("publishers never reached %d", want)
+			}
+			if n, changed := s.Publishers(); n == want {
+				return changed
+			}
+		}
+	}
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := waitFor(1)
+	if connected.Before(started) {
+		t.Errorf("connected at %v, before the server started at %v", connected, started)
+	}
+
+	ws.Close()
+	if disconnected := waitFor(0); disconnected.Before(connected) {
+		t.Errorf("disconnected at %v, before connecting at %v", disconnected, connected)
+	}
+}
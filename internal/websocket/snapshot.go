@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+)
+
+// FrameToRGBA converts a premultiplied BGRA frame into an image.RGBA.
+func FrameToRGBA(data []byte, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i+3 < len(img.Pix) && i+3 < len(data); i += 4 {
+		img.Pix[i+0] = data[i+2]
+		img.Pix[i+1] = data[i+1]
+		img.Pix[i+2] = data[i+0]
+		img.Pix[i+3] = data[i+3]
+	}
+	return img
+}
+
+func (s *Server) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	frame, ok := s.ringBuffer.AcquireLatest()
+	if !ok {
+		http.Error(w, "no frame received yet", http.StatusNotFound)
+		return
+	}
+
+	img := FrameToRGBA(frame.Data, frame.Width, frame.Height)
+	frame.Release()
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	png.Encode(w, img)
+}
@@ -0,0 +1,43 @@
+package websocket
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/example/bidirect/internal/config"
+)
+
+func TestServeSnapshot(t *testing.T) {
+	s := NewServer(config.DefaultConfig())
+	rec := httptest.NewRecorder()
+	s.serveSnapshot(rec, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("before any frame: %d, want 404", rec.Code)
+	}
+
+	// Two premultiplied BGRA pixels: opaque blue and half-transparent red.
+	s.ringBuffer.Write([]byte{255, 0, 0, 255, 0, 0, 128, 128}, 2, 1)
+	rec = httptest.NewRecorder()
+	s.serveSnapshot(rec, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("snapshot is %v, want 2x1", b)
+	}
+	for x, want := range [][4]uint32{{0, 0, 0xffff, 0xffff}, {0x8080, 0, 0, 0x8080}} {
+		r, g, b, a := img.At(x, 0).RGBA()
+		if got := [4]uint32{r, g, b, a}; got != want {
+			t.Errorf("pixel %d = %04x, want %04x", x, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"time"
+
+	"github.com/example/bidirect/internal/vp8"
+)
+
+// keyframeRequestInterval limits how often a connection asks its sender
+// for a keyframe.
+const keyframeRequestInterval = time.Second
+
+// vp8Stream is the VP8 decoding state of one connection. Frames of a VP8
+// stream depend on each other, so a connection decodes them in order on
+// its own goroutine rather than on the shared worker pool. Interframes
+// that arrive before the first keyframe, or after a frame that failed to
+// decode, fail with vp8.ErrNoKeyFrame until the sender sends a keyframe.
+type vp8Stream struct {
+	limits      Limits
+	color       vp8Plane
+	alpha       vp8Plane
+	lastRequest time.Time
+}
+
+type vp8Plane struct {
+	dec *vp8.Decoder
+	r   bytes.Reader
+}
+
+// decode decodes the next frame of the plane's stream. It returns a nil
+// image for hidden frames, which only update the reference frames. The
+// size is checked on keyframes, as interframes keep the size of the
+// keyframe before them.
+func (p *vp8Plane) decode(frame []byte, limits Limits) (*image.YCbCr, error) {
+	if p.dec == nil {
+		p.dec = vp8.NewDecoder()
+	}
+	p.r.Reset(frame)
+	p.dec.Init(&p.r, len(frame))
+	fh, err := p.dec.DecodeFrameHeader()
+	if err != nil {
+		return nil, err
+	}
+	if fh.KeyFrame {
+		if err := limits.CheckSize(fh.Width, fh.Height); err != nil {
+			return nil, err
+		}
+	}
+	img, err := p.dec.DecodeFrame()
+	if err != nil || !fh.ShowFrame {
+		return nil, err
+	}
+	return img, nil
+}
+
+// decode turns a FrameVP8 payload into premultiplied BGRA from the buffer
+// pool. Hidden frames return a nil buffer and no error.
+func (st *vp8Stream) decode(data []byte) ([]byte, int, int, error) {
+	if len(data) < 4 {
+		return nil, 0, 0, errors.New("vp8: short packet")
+	}
+	colorLen := int(binary.LittleEndian.Uint32(data))
+	if colorLen <= 0 || colorLen > len(data)-4 {
+		return nil, 0, 0, errors.New("vp8: invalid color frame length")
+	}
+	colorFrame, alphaFrame := data[4:4+colorLen], data[4+colorLen:]
+
+	ycbcr, err := st.color.decode(colorFrame, st.limits)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var img image.Image = ycbcr
+	if len(alphaFrame) > 0 {
+		alpha, err := st.alpha.decode(alphaFrame, st.limits)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if (ycbcr == nil) != (alpha == nil) {
+			return nil, 0, 0, errors.New("vp8: alpha and color frames differ in visibility")
+		}
+		if ycbcr == nil {
+			return nil, 0, 0, nil
+		}
+		if alpha.Rect != ycbcr.Rect {
+			return nil, 0, 0, errors.New("vp8: alpha and color frames differ in size")
+		}
+		img = &image.NYCbCrA{YCbCr: *ycbcr, A: alpha.Y, AStride: alpha.YStride}
+	} else if ycbcr == nil {
+		return nil, 0, 0, nil
+	}
+
+	width, height := ycbcr.Rect.Dx(), ycbcr.Rect.Dy()
+	bgra := getBuffer(width * height * 4)
+	convertToBGRA(bgra, img)
+	return bgra, width, height, nil
+}
+
+// isVP8Keyframe reports whether a FrameVP8 payload holds a keyframe. The
+// low bit of a VP8 frame tag is clear on keyframes.
+func isVP8Keyframe(data []byte) bool {
+	return len(data) > 4 && data[4]&1 == 0
+}
+
+// shouldRequestKeyframe reports whether enough time has passed since the
+// last keyframe request, and if so restarts the interval.
+func (st *vp8Stream) shouldRequestKeyframe() bool {
+	now := time.Now()
+	if now.Sub(st.lastRequest) < keyframeRequestInterval {
+		return false
+	}
+	st.lastRequest = now
+	return true
+}
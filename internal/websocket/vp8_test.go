@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/vp8"
+	"github.com/example/bidirect/internal/webm"
+	"golang.org/x/net/websocket"
+)
+
+// testVP8Keyframe extracts the VP8 bitstream from test.webp.
+func testVP8Keyframe(t *testing.T) []byte {
+	still := readTestFile(t, "../../test.webp")
+	chunks, err := riffChunks(still[12:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range chunks {
+		if ch.id == "VP8 " {
+			return ch.data
+		}
+	}
+	t.Fatal("test.webp has no VP8 chunk")
+	return nil
+}
+
+func vp8Payload(color, alpha []byte) []byte {
+	p := binary.LittleEndian.AppendUint32(nil, uint32(len(color)))
+	p = append(p, color...)
+	return append(p, alpha...)
+}
+
+func TestVP8StreamDecode(t *testing.T) {
+	key := testVP8Keyframe(t)
+	var st vp8Stream
+
+	opaque, width, height, err := st.decode(vp8Payload(key, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 1200 || height != 1200 {
+		t.Fatalf("size = %dx%d, want 1200x1200", width, height)
+	}
+	for i := 3; i < len(opaque); i += 4 {
+		if opaque[i] != 0xff {
+			t.Fatalf("pixel %d alpha = %d without an alpha frame", i/4, opaque[i])
+		}
+	}
+
+	// Using the color frame as the alpha frame makes alpha equal luma.
+	withAlpha, _, _, err := st.decode(vp8Payload(key, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(withAlpha, opaque) {
+		t.Error("alpha frame had no effect")
+	}
+
+	inter := bytes.Clone(key)
+	inter[0] |= 1
+	var fresh vp8Stream
+	if _, _, _, err := fresh.decode(vp8Payload(inter, nil)); !errors.Is(err, vp8.ErrNoKeyFrame) {
+		t.Errorf("interframe error = %v, want vp8.ErrNoKeyFrame", err)
+	}
+}
+
+func TestVP8StreamDecodesInterframes(t *testing.T) {
+	f, err := os.Open("../../video.webm")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+	r, err := webm.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var st vp8Stream
+	var prev []byte
+	for i := 0; i < 8; i++ {
+		frame, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Keyframe != (i == 0) {
+			t.Fatalf("frame %d: Keyframe = %v", i, frame.Keyframe)
+		}
+		bgra, width, height, err := st.decode(vp8Payload(frame.Data, frame.Alpha))
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if width != 1920 || height != 1080 {
+			t.Fatalf("frame %d: size = %dx%d, want 1920x1080", i, width, height)
+		}
+		if prev != nil && bytes.Equal(bgra, prev) {
+			t.Errorf("frame %d is identical to the frame before it", i)
+		}
+		prev = bgra
+	}
+}
+
+func TestVP8InterframeRequestsKeyframe(t *testing.T) {
+	key := testVP8Keyframe(t)
+	inter := bytes.Clone(key)
+	inter[0] |= 1
+
+	s := NewServer(config.DefaultConfig())
+	ts := httptest.NewServer(websocket.Handler(s.handleWebSocket))
+	defer ts.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err := WriteTypedPacket(ws, FrameVP8, vp8Payload(inter, nil)); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, _, err := NewPacketReader(ws).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != FrameKeyframeRequest {
+		t.Fatalf("got packet type %d, want a keyframe request", typ)
+	}
+
+	if err := WriteTypedPacket(ws, FrameVP8, vp8Payload(key, nil)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if frame, ok := s.ringBuffer.Acquire(); ok {
+			width := frame.Width
+			frame.Release()
+			if width == 1200 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("keyframe never reached the ring buffer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := s.metrics.InterframesDropped.Load(); n != 1 {
+		t.Errorf("InterframesDropped = %d, want 1", n)
+	}
+}
@@ -0,0 +1,103 @@
+package window
+
+import "github.com/example/bidirect/internal/config"
+
+// Hit is the platform-neutral result of hit testing a point in the window.
+type Hit int
+
+const (
+	HitTransparent Hit = iota
+	HitCaption
+	HitLeft
+	HitRight
+	HitTop
+	HitTopLeft
+	HitTopRight
+	HitBottom
+	HitBottomLeft
+	HitBottomRight
+)
+
+func (h Hit) String() string {
+	switch h {
+	case HitTransparent:
+		return "transparent"
+	case HitCaption:
+		return "caption"
+	case HitLeft:
+		return "left"
+	case HitRight:
+		return "right"
+	case HitTop:
+		return "top"
+	case HitTopLeft:
+		return "top-left"
+	case HitTopRight:
+		return "top-right"
+	case HitBottom:
+		return "bottom"
+	case HitBottomLeft:
+		return "bottom-left"
+	case HitBottomRight:
+		return "bottom-right"
+	}
+	return "unknown"
+}
+
+// hitTest classifies the client point (cx, cy) of a BGRA framebuffer.
+// Pixels below the alpha threshold are click-through; opaque pixels near
+// the edges are resize handles and everything else drags the window.
+func hitTest(cfg config.Config, pixels []byte, stride, width, height, cx, cy int) Hit {
+	if cx < 0 || cy < 0 || cx >= width || cy >= height {
+		return HitTransparent
+	}
+
+	if !clickable(cfg, pixels, stride, cx, cy) {
+		return HitTransparent
+	}
+
+	grab := cfg.BorderGrabSize
+
+	onLeft := cx < grab
+	onRight := cx >= width-grab
+	onTop := cy < grab
+	onBottom := cy >= height-grab
+
+	if onTop && onLeft {
+		return HitTopLeft
+	}
+	if onTop && onRight {
+		return HitTopRight
+	}
+	if onBottom && onLeft {
+		return HitBottomLeft
+	}
+	if onBottom && onRight {
+		return HitBottomRight
+	}
+	if onLeft {
+		return HitLeft
+	}
+	if onRight {
+		return HitRight
+	}
+	if onTop {
+		return HitTop
+	}
+	if onBottom {
+		return HitBottom
+	}
+
+	return HitCaption
+}
+
+// clickable reports whether the pixel at (x, y) is opaque enough to
+// receive input.
+func clickable(cfg config.Config, pixels []byte, stride, x, y int) bool {
+	idx := (y*stride + x*4) + 3
+	alpha := uint8(0)
+	if idx < len(pixels) {
+		alpha = pixels[idx]
+	}
+	return alpha >= cfg.AlphaThreshold
+}
@@ -0,0 +1,201 @@
+package window
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/websocket"
+)
+
+const (
+	// fadeOutTime is how long a lost signal's last frame takes to fade out.
+	fadeOutTime = time.Second
+	// pulsePeriod is the length of one pulse of the waiting logo.
+	pulsePeriod = 2 * time.Second
+	// dimWeight is the brightness, out of 256, of a dimmed frame.
+	dimWeight = 96
+)
+
+// placeholderKind is what the window shows instead of live frames.
+type placeholderKind int
+
+const (
+	// placeholderKeep leaves the last frame as it is.
+	placeholderKeep placeholderKind = iota
+	// placeholderDim darkens the last frame.
+	placeholderDim
+	// placeholderFade fades the last frame out to nothing.
+	placeholderFade
+	// The remaining kinds replace the last frame, if any.
+	placeholderLogo
+	placeholderPulse
+	placeholderImage
+)
+
+// placeholder is a placeholderKind and, for placeholderImage, the image
+// as a premultiplied BGRA frame.
+type placeholder struct {
+	kind          placeholderKind
+	image         []byte
+	width, height int
+}
+
+// replaces reports whether p is shown instead of the last frame.
+func (p placeholder) replaces() bool {
+	return p.kind >= placeholderLogo
+}
+
+// parsePlaceholder parses "keep", "dim", "fade", "logo", "pulse" or the
+// path of an image file.
+func parsePlaceholder(spec string) (placeholder, error) {
+	for kind, name := range map[placeholderKind]string{
+		placeholderKeep:  "keep",
+		placeholderDim:   "dim",
+		placeholderFade:  "fade",
+		placeholderLogo:  "logo",
+		placeholderPulse: "pulse",
+	} {
+		if spec == name {
+			return placeholder{kind: kind}, nil
+		}
+	}
+	data, err := os.ReadFile(spec)
+	if err != nil {
+		return placeholder{}, fmt.Errorf("placeholder %q is neither keep, dim, fade, logo, pulse nor an image: %w", spec, err)
+	}
+	pix, width, height, err := websocket.DecodeImageToBGRA(data, websocket.Limits{})
+	if err != nil {
+		return placeholder{}, fmt.Errorf("placeholder %s: %w", spec, err)
+	}
+	return placeholder{kind: placeholderImage, image: pix, width: width, height: height}, nil
+}
+
+// idleConfig is how the window behaves without live frames.
+type idleConfig struct {
+	waiting   placeholder
+	lost      placeholder
+	lostAfter time.Duration
+	hide      bool
+}
+
+// defaultIdle shows the logo while waiting and keeps the last frame.
+var defaultIdle = idleConfig{waiting: placeholder{kind: placeholderLogo}}
+
+func newIdleConfig(cfg config.Config) (idleConfig, error) {
+	waiting, err := parsePlaceholder(cfg.Waiting)
+	if err != nil {
+		return idleConfig{}, err
+	}
+	if !waiting.replaces() {
+		return idleConfig{}, fmt.Errorf("waiting screen %q must be logo, pulse or an image", cfg.Waiting)
+	}
+	lost, err := parsePlaceholder(cfg.SignalLost)
+	if err != nil {
+		return idleConfig{}, err
+	}
+	return idleConfig{waiting: waiting, lost: lost, lostAfter: cfg.SignalLostAfter, hide: cfg.HideWhenIdle}, nil
+}
+
+// idleState is whether the window shows live frames.
+type idleState int
+
+const (
+	stateLive idleState = iota
+	// stateWaiting is before the first frame arrives.
+	stateWaiting
+	// stateLost is once no publisher has been connected for lostAfter.
+	stateLost
+)
+
+// state returns the window's state at now and, for stateLost, when the
+// signal was lost. While the last publisher is gone but the signal not yet
+// lost, a wake-up is scheduled for when it will be.
+func (r *renderer) state(now time.Time) (idleState, time.Time) {
+	ring := r.server.GetRingBuffer()
+	if !ring.HasFrames() {
+		return stateWaiting, time.Time{}
+	}
+	publishers, changed := r.server.Publishers()
+	if publishers > 0 || r.idle.lostAfter <= 0 {
+		return stateLive, time.Time{}
+	}
+	lostAt := changed.Add(r.idle.lostAfter)
+	if now.Before(lostAt) {
+		if !lostAt.Equal(r.lostAt) {
+			if r.lostTimer != nil {
+				r.lostTimer.Stop()
+			}
+			r.lostAt = lostAt
+			r.lostTimer = time.AfterFunc(lostAt.Sub(now), ring.Notify)
+		}
+		return stateLive, time.Time{}
+	}
+	return stateLost, lostAt
+}
+
+// presentPlaceholder shows p, which replaces frames, scaled to fit the
+// window. since is when p was first shown, for animated placeholders.
+func (r *renderer) presentPlaceholder(p presenter, ph placeholder, now, since time.Time) {
+	width, height := p.size()
+	src, sw, sh := ph.image, ph.width, ph.height
+	if ph.kind != placeholderImage {
+		size := min(width, height)
+		if size <= 0 {
+			return
+		}
+		if len(r.logo) != size*size*4 {
+			r.logo = websocket.CreateBiDirectLogo(size)
+		}
+		src, sw, sh = r.logo, size, size
+	}
+
+	pix := src
+	if sw != width || sh != height {
+		_, r.idleScaler.Filter = r.server.Scaling()
+		r.idleScaler.Mode = scale.Fit
+		if pix = r.idleScaler.Scale(src, sw, sh, width, height); pix == nil {
+			return
+		}
+	}
+	if ph.kind == placeholderPulse {
+		phase := 2 * math.Pi * float64(now.Sub(since)) / float64(pulsePeriod)
+		pix = r.fade(pix, uint32(256*(0.7+0.3*math.Cos(phase))))
+		r.animating = true
+	}
+	p.applyFrameDirect(pix, width, height)
+}
+
+// lostFrame applies a lost signal's placeholder, which keeps the last
+// frame, to the frame's pixels at now, since the signal was lost at lost.
+func (r *renderer) lostFrame(pix []byte, now, lost time.Time) []byte {
+	switch r.idle.lost.kind {
+	case placeholderDim:
+		return r.fade(pix, dimWeight)
+	case placeholderFade:
+		t := float64(now.Sub(lost)) / float64(fadeOutTime)
+		// The fade is redrawn until the frame is gone.
+		if t < 1 {
+			r.animating = true
+		}
+		return r.fade(pix, uint32(256*(1-min(t, 1))))
+	}
+	return pix
+}
+
+// fade returns a copy of the premultiplied frame pix with every channel,
+// alpha included, scaled by w out of 256. Scaling color and alpha alike
+// keeps the frame premultiplied, so it fades to transparent.
+func (r *renderer) fade(pix []byte, w uint32) []byte {
+	if cap(r.faded) < len(pix) {
+		r.faded = make([]byte, len(pix))
+	}
+	r.faded = r.faded[:len(pix)]
+	for i, v := range pix {
+		r.faded[i] = byte((uint32(v)*w + 128) >> 8)
+	}
+	return r.faded
+}
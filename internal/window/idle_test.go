@@ -0,0 +1,171 @@
+package window
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/websocket"
+)
+
+// newIdleOffscreen returns an 8x8 offscreen window for cfg with the
+// signal lost a second after the last publisher leaves, and the time at
+// which that happens.
+func newIdleOffscreen(t *testing.T, cfg config.Config) (*Offscreen, time.Time) {
+	t.Helper()
+	cfg.InitialSize = 8
+	cfg.SignalLostAfter = time.Second
+	o := NewOffscreen(cfg, websocket.NewServer(cfg))
+	t.Cleanup(func() {
+		if o.renderer.lostTimer != nil {
+			o.renderer.lostTimer.Stop()
+		}
+	})
+	_, changed := o.wsServer.Publishers()
+	return o, changed.Add(cfg.SignalLostAfter)
+}
+
+func writeWhite(o *Offscreen) {
+	white := websocket.CreateBlankFrame(8, 8, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	o.wsServer.GetRingBuffer().Write(white, 8, 8)
+}
+
+func TestRendererWaitingImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "waiting.png")
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{0, 0, 255, 255})
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Waiting = path
+	o, _ := newIdleOffscreen(t, cfg)
+	if o.Step() {
+		t.Error("Step presented a frame while waiting")
+	}
+	if got := o.Pixel(4, 4); got != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("waiting screen = %v, want the blue image", got)
+	}
+
+	writeWhite(o)
+	if !o.Step() {
+		t.Error("Step did not present the first frame")
+	}
+}
+
+func TestRendererSignalLost(t *testing.T) {
+	for _, tt := range []struct {
+		lost      string
+		after     time.Duration
+		want      uint8
+		animating bool
+	}{
+		{"keep", 0, 255, false},
+		{"dim", 0, dimWeight, false},
+		{"fade", fadeOutTime / 2, 128, true},
+		{"fade", fadeOutTime, 0, false},
+	} {
+		cfg := config.DefaultConfig()
+		cfg.SignalLost = tt.lost
+		o, lost := newIdleOffscreen(t, cfg)
+		writeWhite(o)
+
+		if !o.renderer.presentAt(o, lost.Add(-time.Millisecond)) || o.Pixel(4, 4).A != 255 {
+			t.Errorf("%s: frame before the signal is lost = %v, want white", tt.lost, o.Pixel(4, 4))
+		}
+		o.renderer.presentAt(o, lost.Add(tt.after))
+		if got := o.Pixel(4, 4); got.R != tt.want || got.A != tt.want {
+			t.Errorf("%s %v after the signal is lost = %v, want %d", tt.lost, tt.after, got, tt.want)
+		}
+		if o.renderer.animating != tt.animating {
+			t.Errorf("%s %v after the signal is lost: animating = %v", tt.lost, tt.after, o.renderer.animating)
+		}
+	}
+}
+
+func TestRendererSignalLostLogo(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SignalLost = "logo"
+	o, lost := newIdleOffscreen(t, cfg)
+	red := websocket.CreateBlankFrame(8, 8, color.NRGBA{R: 255, A: 255})
+	o.wsServer.GetRingBuffer().Write(red, 8, 8)
+	o.renderer.presentAt(o, lost.Add(-time.Millisecond))
+
+	if o.renderer.presentAt(o, lost) {
+		t.Error("presented a frame once the signal was lost")
+	}
+	if !bytes.Equal(o.Pixels(), websocket.CreateBiDirectLogo(8)) {
+		t.Error("the logo is not shown once the signal was lost")
+	}
+	if o.renderer.animating {
+		t.Error("animating while the still logo is shown")
+	}
+}
+
+func TestRendererWaitingPulse(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Waiting = "pulse"
+	o, _ := newIdleOffscreen(t, cfg)
+	now := time.Now()
+	o.renderer.presentAt(o, now)
+	bright := o.Pixels()
+	if !o.renderer.animating {
+		t.Error("the pulse is not animating")
+	}
+	o.renderer.presentAt(o, now.Add(pulsePeriod/2))
+	if bytes.Equal(o.Pixels(), bright) {
+		t.Error("the pulse did not change over half a period")
+	}
+}
+
+func TestRendererHidesWhenIdle(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.HideWhenIdle = true
+	o, lost := newIdleOffscreen(t, cfg)
+	if o.Visible() {
+		t.Fatal("visible before the first frame")
+	}
+
+	now := lost.Add(-2 * time.Second)
+	if o.renderer.presentAt(o, now) || o.Visible() {
+		t.Error("shown while waiting")
+	}
+	writeWhite(o)
+	if !o.renderer.presentAt(o, now) || !o.Visible() {
+		t.Error("hidden with a live frame")
+	}
+	if o.renderer.presentAt(o, lost) || o.Visible() {
+		t.Error("shown once the signal was lost")
+	}
+}
+
+func TestNewIdleConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	if idle, err := newIdleConfig(cfg); err != nil || idle.waiting.kind != placeholderLogo || idle.lost.kind != placeholderKeep {
+		t.Errorf("defaults = %+v, %v", idle, err)
+	}
+
+	cfg.Waiting = "dim"
+	if _, err := newIdleConfig(cfg); err == nil {
+		t.Error("accepted a waiting screen without a frame to dim")
+	}
+	cfg.Waiting = "pulse"
+	cfg.SignalLost = filepath.Join(t.TempDir(), "missing.png")
+	if _, err := newIdleConfig(cfg); err == nil {
+		t.Error("accepted a missing image")
+	}
+}
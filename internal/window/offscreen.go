@@ -0,0 +1,165 @@
+package window
+
+import (
+	"image/color"
+	"sync"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/logging"
+	"github.com/example/bidirect/internal/websocket"
+)
+
+// Offscreen is a window backend that presents into an in-memory BGRA
+// framebuffer instead of a native window. It runs the same render and
+// hit-test logic as the desktop backends, which makes it usable from tests.
+type Offscreen struct {
+	cfg      config.Config
+	wsServer *websocket.Server
+	width    int
+	height   int
+	pixels   []byte
+	stride   int
+	renderMu sync.Mutex
+	renderer *renderer
+	visible  bool
+	mu       sync.RWMutex
+	quitCh   chan struct{}
+	quitOnce sync.Once
+}
+
+func NewOffscreen(cfg config.Config, server *websocket.Server) *Offscreen {
+	o := &Offscreen{
+		cfg:      cfg,
+		wsServer: server,
+		visible:  !cfg.HideWhenIdle,
+		quitCh:   make(chan struct{}),
+	}
+	idle, err := newIdleConfig(cfg)
+	if err != nil {
+		logging.Errorf("Idle screens: %v", err)
+		idle = defaultIdle
+	}
+	o.renderer = newRenderer(server, idle)
+	o.resizeWindow(cfg.InitialSize, cfg.InitialSize)
+
+	logo := websocket.CreateBiDirectLogo(o.width)
+	o.applyFrameDirect(logo, o.width, o.height)
+	return o
+}
+
+// Run presents frames from the server as they arrive until Close is
+// called.
+func (o *Offscreen) Run() error {
+	renderLoop(o.wsServer.GetRingBuffer(), o.cfg.MaxFPS, o.quitCh, func() bool {
+		o.Step()
+		return o.animating()
+	})
+	return nil
+}
+
+func (o *Offscreen) Close() {
+	o.quitOnce.Do(func() { close(o.quitCh) })
+}
+
+// InjectFrame writes a BGRA frame into the server's ring buffer and
+// presents it immediately.
+func (o *Offscreen) InjectFrame(data []byte, width, height int) {
+	o.wsServer.GetRingBuffer().Write(data, width, height)
+	o.Step()
+}
+
+// animating reports whether the last Step left an animation running.
+func (o *Offscreen) animating() bool {
+	o.renderMu.Lock()
+	defer o.renderMu.Unlock()
+	return o.renderer.animating
+}
+
+// Step runs one iteration of the render loop. It reports whether a frame
+// was presented.
+func (o *Offscreen) Step() bool {
+	o.renderMu.Lock()
+	defer o.renderMu.Unlock()
+	return o.renderer.present(o)
+}
+
+// Click returns what a click at client point (x, y) would hit.
+func (o *Offscreen) Click(x, y int) Hit {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return hitTest(o.cfg, o.pixels, o.stride, o.width, o.height, x, y)
+}
+
+// Resize simulates the user resizing the window.
+func (o *Offscreen) Resize(width, height int) {
+	o.resizeWindow(width, height)
+	o.wsServer.GetRingBuffer().Notify()
+}
+
+// Visible reports whether the window would be shown on screen.
+func (o *Offscreen) Visible() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.visible
+}
+
+func (o *Offscreen) show(visible bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.visible = visible
+}
+
+func (o *Offscreen) Size() (int, int) {
+	return o.size()
+}
+
+// Pixel returns the premultiplied color at (x, y), or transparent black
+// outside the framebuffer.
+func (o *Offscreen) Pixel(x, y int) color.RGBA {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if x < 0 || y < 0 || x >= o.width || y >= o.height {
+		return color.RGBA{}
+	}
+	idx := y*o.stride + x*4
+	return color.RGBA{
+		R: o.pixels[idx+2],
+		G: o.pixels[idx+1],
+		B: o.pixels[idx+0],
+		A: o.pixels[idx+3],
+	}
+}
+
+// Pixels returns a copy of the BGRA framebuffer.
+func (o *Offscreen) Pixels() []byte {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return append([]byte(nil), o.pixels...)
+}
+
+func (o *Offscreen) size() (int, int) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.width, o.height
+}
+
+func (o *Offscreen) resizeWindow(newWidth, newHeight int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.width = newWidth
+	o.height = newHeight
+	o.stride = newWidth * 4
+	o.pixels = make([]byte, o.stride*newHeight)
+}
+
+func (o *Offscreen) applyFrameDirect(frame []byte, width, height int) error {
+	expectedSize := width * height * 4
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(frame) < expectedSize || len(o.pixels) < expectedSize {
+		return nil
+	}
+	copy(o.pixels, frame[:expectedSize])
+	return nil
+}
@@ -0,0 +1,332 @@
+package window
+
+import (
+	"image/color"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/transition"
+	"github.com/example/bidirect/internal/websocket"
+)
+
+func newTestOffscreen() *Offscreen {
+	cfg := config.DefaultConfig()
+	cfg.InitialSize = 64
+	return NewOffscreen(cfg, websocket.NewServer(cfg))
+}
+
+func TestOffscreenStartsWithLogo(t *testing.T) {
+	o := newTestOffscreen()
+
+	if w, h := o.Size(); w != 64 || h != 64 {
+		t.Fatalf("Size = %dx%d, want 64x64", w, h)
+	}
+	if got := o.Click(0, 0); got != HitTransparent {
+		t.Errorf("corner of logo = %v, want transparent", got)
+	}
+	if got := o.Click(32, 20); got != HitCaption {
+		t.Errorf("center of logo = %v, want caption", got)
+	}
+}
+
+func TestOffscreenInjectFrameKeepsSize(t *testing.T) {
+	o := newTestOffscreen()
+
+	frame := websocket.CreateBlankFrame(40, 20, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+	o.InjectFrame(frame, 40, 20)
+
+	if w, h := o.Size(); w != 64 || h != 64 {
+		t.Fatalf("Size = %dx%d, want 64x64", w, h)
+	}
+	// Fit scales the 2:1 frame to 64x32, centered vertically.
+	if got, want := o.Pixel(32, 32), (color.RGBA{R: 10, G: 20, B: 30, A: 255}); got != want {
+		t.Errorf("Pixel(32, 32) = %v, want %v", got, want)
+	}
+	if got := o.Pixel(32, 8); got != (color.RGBA{}) {
+		t.Errorf("Pixel(32, 8) = %v, want transparent letterbox", got)
+	}
+	if got := len(o.Pixels()); got != 64*64*4 {
+		t.Errorf("len(Pixels) = %d, want %d", got, 64*64*4)
+	}
+}
+
+func TestOffscreenScalingModes(t *testing.T) {
+	o := newTestOffscreen()
+	frame := websocket.CreateBlankFrame(40, 20, color.NRGBA{G: 255, A: 255})
+
+	tests := []struct {
+		mode   scale.Mode
+		x, y   int
+		opaque bool
+	}{
+		{scale.Fit, 32, 8, false},
+		{scale.Stretch, 32, 8, true},
+		{scale.Fill, 32, 8, true},
+		{scale.Center, 32, 30, true},
+		{scale.Center, 5, 30, false},
+	}
+	for _, tt := range tests {
+		o.wsServer.SetScaling(tt.mode, scale.Lanczos)
+		o.InjectFrame(frame, 40, 20)
+		if got := o.Pixel(tt.x, tt.y).A == 255; got != tt.opaque {
+			t.Errorf("%v: Pixel(%d, %d) opaque = %v, want %v", tt.mode, tt.x, tt.y, got, tt.opaque)
+		}
+	}
+}
+
+func TestOffscreenUserResize(t *testing.T) {
+	o := newTestOffscreen()
+	o.InjectFrame(websocket.CreateBlankFrame(40, 40, color.NRGBA{B: 255, A: 255}), 40, 40)
+
+	o.Resize(90, 90)
+	o.Step()
+
+	if w, h := o.Size(); w != 90 || h != 90 {
+		t.Fatalf("Size = %dx%d, want 90x90", w, h)
+	}
+	if got := o.Click(45, 45); got != HitCaption {
+		t.Errorf("Click(45, 45) = %v, want caption", got)
+	}
+}
+
+func TestOffscreenHitTest(t *testing.T) {
+	o := newTestOffscreen()
+	o.Resize(100, 100)
+
+	frame := websocket.CreateBlankFrame(100, 100, color.NRGBA{R: 255, A: 255})
+	// Punch a transparent hole in the middle.
+	for y := 40; y < 60; y++ {
+		for x := 40; x < 60; x++ {
+			frame[(y*100+x)*4+3] = 0
+		}
+	}
+	o.InjectFrame(frame, 100, 100)
+
+	tests := []struct {
+		x, y int
+		want Hit
+	}{
+		{50, 50, HitTransparent},
+		{20, 20, HitCaption},
+		{2, 50, HitLeft},
+		{97, 50, HitRight},
+		{50, 2, HitTop},
+		{50, 97, HitBottom},
+		{2, 2, HitTopLeft},
+		{97, 2, HitTopRight},
+		{2, 97, HitBottomLeft},
+		{97, 97, HitBottomRight},
+		{-1, 50, HitTransparent},
+		{100, 50, HitTransparent},
+	}
+	for _, tt := range tests {
+		if got := o.Click(tt.x, tt.y); got != tt.want {
+			t.Errorf("Click(%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestOffscreenStepWithoutFrames(t *testing.T) {
+	o := newTestOffscreen()
+	if o.Step() {
+		t.Error("Step presented a frame from an empty ring buffer")
+	}
+}
+
+func TestOffscreenHUD(t *testing.T) {
+	o := newTestOffscreen()
+	o.Resize(200, 120)
+	frame := websocket.CreateBlankFrame(200, 120, color.NRGBA{G: 255, A: 255})
+	ring := o.wsServer.GetRingBuffer()
+	ring.Write(frame, 200, 120)
+	green := color.RGBA{G: 255, A: 255}
+
+	o.Step()
+	if got := o.Pixel(10, 10); got != green {
+		t.Fatalf("Pixel(10, 10) without HUD = %v, want %v", got, green)
+	}
+
+	o.wsServer.SetHUD(true)
+	o.Step()
+	if got := o.Pixel(10, 10); got == green {
+		t.Error("HUD panel was not drawn")
+	}
+	if got := o.Pixel(190, 110); got != green {
+		t.Errorf("Pixel(190, 110) = %v, want %v outside the HUD", got, green)
+	}
+	latest, _ := ring.Acquire()
+	if latest.Data[(10*200+10)*4+1] != 255 {
+		t.Error("HUD was drawn into the ring buffer's frame")
+	}
+	latest.Release()
+
+	o.wsServer.SetHUD(false)
+	o.Step()
+	if got := o.Pixel(10, 10); got != green {
+		t.Errorf("Pixel(10, 10) after hiding HUD = %v, want %v", got, green)
+	}
+}
+
+func TestOffscreenRunPresentsOnWrite(t *testing.T) {
+	o := newTestOffscreen()
+	done := make(chan error)
+	go func() { done <- o.Run() }()
+	defer func() {
+		o.Close()
+		<-done
+	}()
+
+	green := color.RGBA{G: 255, A: 255}
+	deadline := time.Now().Add(2 * time.Second)
+	// Run may not have subscribed yet when the first frame is written.
+	for o.Pixel(32, 32) != green {
+		if time.Now().After(deadline) {
+			t.Fatal("frame written to the ring buffer was never presented")
+		}
+		o.wsServer.GetRingBuffer().Write(websocket.CreateBlankFrame(64, 64, color.NRGBA{G: 255, A: 255}), 64, 64)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRenderLoopCapsRate(t *testing.T) {
+	rb := websocket.NewRingBuffer()
+	quit := make(chan struct{})
+	var presents atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		renderLoop(rb, 20, quit, func() bool {
+			if frame, ok := rb.Acquire(); ok {
+				frame.Release()
+			}
+			presents.Add(1)
+			return false
+		})
+		close(done)
+	}()
+
+	start := time.Now()
+	for time.Since(start) < 200*time.Millisecond {
+		rb.Write(make([]byte, 4), 1, 1)
+		time.Sleep(time.Millisecond)
+	}
+	close(quit)
+	<-done
+	// 20 fps over 200ms is 4 or 5 presents, however many frames arrived.
+	if n := presents.Load(); n < 2 || n > 6 {
+		t.Errorf("presented %d times in 200ms at 20 fps", n)
+	}
+}
+
+func TestRenderLoopAnimatesAtDisplayRate(t *testing.T) {
+	rb := websocket.NewRingBuffer()
+	quit := make(chan struct{})
+	var presents atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		// The picture animates for the first ten presents, then settles.
+		renderLoop(rb, 0, quit, func() bool {
+			return presents.Add(1) < 10
+		})
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	// Uncapped, an animation is redrawn at animationFPS rather than as
+	// fast as the loop can spin.
+	if n := presents.Load(); n < 2 || n > 9 {
+		t.Errorf("presented %d times in 100ms while animating", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := presents.Load(); n != 10 {
+		t.Errorf("presented %d times, want 10 once the animation ended", n)
+	}
+	close(quit)
+	<-done
+}
+
+func TestRenderLoopDrainsFIFO(t *testing.T) {
+	rb := websocket.NewRingBufferWith(websocket.RingOptions{Depth: 8, Policy: config.FIFO})
+	for width := 1; width <= 5; width++ {
+		rb.Write(make([]byte, width*4), width, 1)
+	}
+
+	quit := make(chan struct{})
+	widths := make(chan int, 8)
+	done := make(chan struct{})
+	go func() {
+		renderLoop(rb, 0, quit, func() bool {
+			if frame, ok := rb.Acquire(); ok {
+				widths <- frame.Width
+				frame.Release()
+			}
+			return false
+		})
+		close(done)
+	}()
+	defer func() {
+		close(quit)
+		<-done
+	}()
+
+	// Frames queued before the loop started are shown in order without
+	// waiting for another write.
+	for want := 1; want <= 5; want++ {
+		select {
+		case got := <-widths:
+			if got != want {
+				t.Fatalf("presented frame %d, want %d", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("frame %d was never presented", want)
+		}
+	}
+}
+
+func TestRendererCrossfades(t *testing.T) {
+	o := newTestOffscreen()
+	o.Resize(8, 8)
+	ring := o.wsServer.GetRingBuffer()
+	fade := transition.Spec{Effect: transition.Crossfade, Duration: time.Second}
+	write := func(seq uint64, c color.NRGBA) {
+		ring.WriteSeq(websocket.FrameInfo{Seq: seq, Transition: fade}, websocket.CreateBlankFrame(8, 8, c), 8, 8)
+	}
+	r := newRenderer(o.wsServer, defaultIdle)
+	now := time.Now()
+
+	write(1, color.NRGBA{R: 255, A: 255})
+	r.presentAt(o, now)
+	if got := o.Pixel(4, 4); got != (color.RGBA{R: 255, A: 255}) {
+		t.Fatalf("first frame = %v, want red without a transition", got)
+	}
+
+	// Frames arriving faster than the transition, like video, are cut to.
+	write(2, color.NRGBA{G: 255, A: 255})
+	now = now.Add(100 * time.Millisecond)
+	r.presentAt(o, now)
+	if got := o.Pixel(4, 4); got != (color.RGBA{G: 255, A: 255}) {
+		t.Errorf("quick replacement = %v, want green at once", got)
+	}
+
+	// A frame replacing one that has been up for the transition's length
+	// fades in over it.
+	write(3, color.NRGBA{B: 255, A: 255})
+	now = now.Add(2 * time.Second)
+	r.presentAt(o, now)
+	r.presentAt(o, now.Add(fade.Duration/2))
+	if got := o.Pixel(4, 4); got.G == 0 || got.B == 0 || got.A != 255 {
+		t.Errorf("halfway through = %v, want a blend of green and blue", got)
+	}
+	if !r.animating {
+		t.Error("not animating halfway through the transition")
+	}
+	r.presentAt(o, now.Add(fade.Duration))
+	if got := o.Pixel(4, 4); got != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("after the transition = %v, want blue", got)
+	}
+	if r.animating {
+		t.Error("still animating after the transition")
+	}
+}
@@ -0,0 +1,289 @@
+package window
+
+import (
+	"time"
+
+	"github.com/example/bidirect/internal/hud"
+	"github.com/example/bidirect/internal/scale"
+	"github.com/example/bidirect/internal/transition"
+	"github.com/example/bidirect/internal/websocket"
+)
+
+// presenter is the part of a backend the render loop drives.
+type presenter interface {
+	size() (int, int)
+	applyFrameDirect(frame []byte, width, height int) error
+	// show shows or hides the window. It is called on every present and
+	// should do nothing when the window is already in that state.
+	show(visible bool)
+}
+
+// hudInterval is how often the HUD's rates and averages are recomputed.
+const hudInterval = time.Second
+
+// renderer presents the server's frames to a backend, scaling them to the
+// window, playing transitions between them and drawing the performance
+// HUD when it is enabled. Without live frames it shows the idle
+// placeholders. A renderer is not safe for concurrent use.
+type renderer struct {
+	server *websocket.Server
+	scaler scale.Scaler
+	// overlay holds the frame the HUD is drawn into, so the ring buffer's
+	// frames are never written to.
+	overlay []byte
+
+	// last is a lease on the frame presented last, kept while its stream
+	// has transitions enabled so the next frame can transition from it.
+	// seq is the sequence number on screen and shown when it was first
+	// presented.
+	last  *websocket.Frame
+	seq   uint64
+	shown time.Time
+	// from is a lease on the frame a transition started at start is
+	// leaving, scaled with fromScaler and blended into blend.
+	from       *websocket.Frame
+	fromScaler scale.Scaler
+	spec       transition.Spec
+	start      time.Time
+	blend      []byte
+	// animating is set while the picture presented last keeps changing
+	// without new frames, so it is presented again at the display rate.
+	animating bool
+
+	// idle is shown without live frames. current is the state presented
+	// last and entered when the window entered it.
+	idle       idleConfig
+	current    idleState
+	entered    time.Time
+	lostAt     time.Time
+	lostTimer  *time.Timer
+	logo       []byte
+	idleScaler scale.Scaler
+	faded      []byte
+
+	stats       hud.Stats
+	since       time.Time
+	presented   int
+	received    uint64
+	decoded     uint64
+	decodeNanos uint64
+}
+
+func newRenderer(server *websocket.Server, idle idleConfig) *renderer {
+	return &renderer{server: server, idle: idle, current: -1}
+}
+
+// present shows the newest frame in the server's ring buffer, or the idle
+// placeholder, and reports whether it showed a frame. The window keeps its
+// own size; frames of any other size are resampled into it with the
+// server's current fit mode and filter.
+func (r *renderer) present(p presenter) bool {
+	return r.presentAt(p, time.Now())
+}
+
+func (r *renderer) presentAt(p presenter, now time.Time) bool {
+	r.animating = false
+	state, lost := r.state(now)
+	if state != r.current {
+		r.current, r.entered = state, now
+	}
+	visible := state == stateLive || !r.idle.hide
+	p.show(visible)
+	switch {
+	case !visible:
+		return false
+	case state == stateWaiting:
+		r.presentPlaceholder(p, r.idle.waiting, now, r.entered)
+		return false
+	case state == stateLost && r.idle.lost.replaces():
+		r.presentPlaceholder(p, r.idle.lost, now, r.entered)
+		return false
+	}
+
+	ring := r.server.GetRingBuffer()
+	frame, ok := ring.Acquire()
+	if !ok {
+		return false
+	}
+	r.track(now, frame)
+	if r.last != frame {
+		defer frame.Release()
+	}
+
+	width, height := p.size()
+	pix := r.scale(&r.scaler, frame, width, height)
+	if pix == nil {
+		return false
+	}
+	if r.from != nil {
+		t := float64(now.Sub(r.start)) / float64(r.spec.Duration)
+		from := r.scale(&r.fromScaler, r.from, width, height)
+		if t >= 1 || from == nil {
+			r.endTransition()
+		} else {
+			n := width * height * 4
+			if cap(r.blend) < n {
+				r.blend = make([]byte, n)
+			}
+			r.blend = r.blend[:n]
+			transition.Blend(r.blend, from, pix, width, height, r.spec.Effect, t)
+			pix = r.blend
+			r.animating = true
+		}
+	}
+
+	if state == stateLost {
+		pix = r.lostFrame(pix, now, lost)
+	}
+
+	if r.server.HUD() {
+		r.update(now, frame)
+		n := width * height * 4
+		if cap(r.overlay) < n {
+			r.overlay = make([]byte, n)
+		}
+		r.overlay = r.overlay[:n]
+		copy(r.overlay, pix)
+		hud.Draw(r.overlay, width, height, r.stats)
+		pix = r.overlay
+	}
+	p.applyFrameDirect(pix, width, height)
+	return true
+}
+
+// scale returns frame's pixels at width x height.
+func (r *renderer) scale(s *scale.Scaler, frame *websocket.Frame, width, height int) []byte {
+	if frame.Width == width && frame.Height == height {
+		return frame.Data
+	}
+	s.Mode, s.Filter = r.server.Scaling()
+	return s.Scale(frame.Data, frame.Width, frame.Height, width, height)
+}
+
+// track notes that frame is being presented at now, starting a transition
+// when it replaces a frame that has been on screen for at least the
+// transition's duration; frames arriving faster than that, such as
+// video, are cut to directly. The renderer takes over frame's lease when
+// it keeps the frame to transition from.
+func (r *renderer) track(now time.Time, frame *websocket.Frame) {
+	if frame.Seq != r.seq {
+		// Animation frames share a sequence number and never transition.
+		spec := frame.Transition
+		if r.last != nil && spec.Enabled() && now.Sub(r.shown) >= spec.Duration {
+			r.endTransition()
+			r.from, r.spec, r.start = r.last, spec, now
+			r.last = nil
+		}
+		r.seq, r.shown = frame.Seq, now
+	}
+	if r.last != nil {
+		r.last.Release()
+		r.last = nil
+	}
+	if frame.Transition.Enabled() {
+		r.last = frame
+	}
+}
+
+func (r *renderer) endTransition() {
+	if r.from != nil {
+		r.from.Release()
+		r.from = nil
+	}
+}
+
+// update folds one presentation of frame at now into the HUD's
+// statistics.
+func (r *renderer) update(now time.Time, frame *websocket.Frame) {
+	r.stats.Width, r.stats.Height = frame.Width, frame.Height
+	r.stats.Publisher = r.server.Publisher()
+	r.stats.Dropped = r.server.GetRingBuffer().Overwritten()
+	// Latency is measured when a frame is first shown, not each time it
+	// is shown again.
+	if frame.Seq != r.stats.Seq {
+		r.stats.Latency, r.stats.Transit = 0, 0
+		if !frame.Received.IsZero() {
+			r.stats.Latency = now.Sub(frame.Received)
+		}
+		if !frame.Sent.IsZero() {
+			r.stats.Transit = frame.Received.Sub(frame.Sent)
+		}
+	}
+	r.stats.Seq, r.stats.Stream, r.stats.ConnID = frame.Seq, frame.Stream, frame.ConnID
+	r.stats.Format, r.stats.EncodedSize = frame.Format, frame.EncodedSize
+
+	r.presented++
+	elapsed := now.Sub(r.since)
+	if elapsed < hudInterval {
+		return
+	}
+	m := r.server.Metrics()
+	received, decoded, decodeNanos := m.FramesReceived.Load(), m.FramesDecoded.Load(), m.DecodeNanos.Load()
+	if !r.since.IsZero() {
+		secs := elapsed.Seconds()
+		r.stats.RenderFPS = float64(r.presented) / secs
+		r.stats.ReceiveFPS = float64(received-r.received) / secs
+		if n := decoded - r.decoded; n > 0 {
+			r.stats.Decode = time.Duration((decodeNanos - r.decodeNanos) / n)
+		}
+	}
+	r.since, r.presented = now, 0
+	r.received, r.decoded, r.decodeNanos = received, decoded, decodeNanos
+}
+
+// animationFPS is how often animations are redrawn when presents are not
+// capped.
+const animationFPS = 60
+
+// renderLoop calls present once at start, so the window shows its
+// waiting screen, then whenever rb has a frame pending or has been told to
+// present again, at most maxFPS times a second. While present reports that
+// the picture is animating it is called again on every tick of the display
+// rate, maxFPS or animationFPS when uncapped. Otherwise it sleeps while
+// nothing is written.
+func renderLoop(rb *websocket.RingBuffer, maxFPS int, quitCh <-chan struct{}, present func() (animating bool)) {
+	frames, cancel := rb.Subscribe()
+	defer cancel()
+	animating := present()
+
+	var interval time.Duration
+	if maxFPS > 0 {
+		interval = time.Second / time.Duration(maxFPS)
+	}
+	tick := interval
+	if tick == 0 {
+		tick = time.Second / animationFPS
+	}
+	last := time.Now()
+	for {
+		wait := interval
+		if animating {
+			wait = tick
+		} else if rb.Pending() == 0 {
+			// A FIFO buffer can still hold frames after a present; they
+			// are shown at the capped rate without waiting for another
+			// write.
+			select {
+			case <-quitCh:
+				return
+			case <-frames:
+			}
+		}
+		if wait -= time.Since(last); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-quitCh:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		// Frames written so far are covered by this present.
+		select {
+		case <-frames:
+		default:
+		}
+		last = time.Now()
+		animating = present()
+	}
+}
@@ -0,0 +1,341 @@
+//go:build linux
+
+package window
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/logging"
+	"github.com/example/bidirect/internal/websocket"
+	"github.com/example/bidirect/internal/x11"
+)
+
+const eventMask = x11.ButtonPressMask | x11.ButtonReleaseMask | x11.ButtonMotionMask |
+	x11.ExposureMask | x11.StructureNotifyMask
+
+type Window struct {
+	cfg      config.Config
+	wsServer *websocket.Server
+	conn     *x11.Conn
+	win      uint32
+	gc       uint32
+	cmap     uint32
+	shape    x11.Extension
+	hasShape bool
+	maskPix  uint32
+	maskGC   uint32
+	mask     []byte
+	x, y     int
+	width    int
+	height   int
+	pixels   []byte
+	stride   int
+	mu       sync.RWMutex
+	dragging bool
+	dragX    int
+	dragY    int
+	closing  atomic.Bool
+	idle     idleConfig
+	visible  atomic.Bool
+	quitCh   chan struct{}
+}
+
+func NewWindow(cfg config.Config, server *websocket.Server) (*Window, error) {
+	width := cfg.InitialSize
+	height := cfg.InitialSize
+	idle, err := newIdleConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Window{
+		idle:     idle,
+		cfg:      cfg,
+		width:    width,
+		height:   height,
+		stride:   width * 4,
+		pixels:   make([]byte, width*height*4),
+		quitCh:   make(chan struct{}),
+		wsServer: server,
+	}
+	return w, nil
+}
+
+// Run opens an override-redirect ARGB window on the X server named by
+// $DISPLAY and processes its events until the process is interrupted.
+func (w *Window) Run() error {
+	if err := w.open(); err != nil {
+		return err
+	}
+	defer w.destroy()
+
+	// Show initial logo
+	logo := websocket.CreateBiDirectLogo(w.width)
+	w.applyFrameDirect(logo, w.width, w.height)
+
+	// A window hidden while idle is mapped by the render loop once frames
+	// arrive.
+	if !w.idle.hide {
+		if err := w.conn.MapWindow(w.win); err != nil {
+			return fmt.Errorf("MapWindow failed: %v", err)
+		}
+		w.visible.Store(true)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			w.closing.Store(true)
+			w.conn.Close()
+		case <-w.quitCh:
+		}
+	}()
+
+	go w.wsRenderLoop()
+
+	err := w.eventLoop()
+	close(w.quitCh)
+	if w.closing.Load() {
+		return nil
+	}
+	return err
+}
+
+func (w *Window) open() error {
+	conn, err := x11.Dial("")
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+
+	screen := conn.DefaultScreen()
+	visual, ok := screen.FindARGBVisual()
+	if !ok {
+		conn.Close()
+		return errors.New("x11: no 32-bit ARGB visual; per-pixel alpha needs a compositing X server")
+	}
+
+	for _, id := range []*uint32{&w.win, &w.gc, &w.cmap} {
+		if *id, err = conn.NewID(); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	w.x = (screen.Width - w.width) / 2
+	w.y = (screen.Height - w.height) / 2
+
+	if err := conn.CreateColormap(w.cmap, screen.Root, visual.ID); err != nil {
+		conn.Close()
+		return fmt.Errorf("CreateColormap failed: %v", err)
+	}
+	// A depth-32 child of a depth-24 root must supply its own border
+	// pixel and colormap, or the server rejects it with BadMatch.
+	err = conn.CreateWindow(32, w.win, screen.Root, w.x, w.y, w.width, w.height, visual.ID,
+		x11.CWBackPixel|x11.CWBorderPixel|x11.CWOverrideRedirect|x11.CWEventMask|x11.CWColormap,
+		0, 0, 1, eventMask, w.cmap)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("CreateWindow failed: %v", err)
+	}
+	conn.SetTitle(w.win, w.cfg.WindowTitle)
+	conn.CreateGC(w.gc, w.win, 0, 0)
+
+	shape, ok, err := conn.QueryExtension("SHAPE")
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("x11: %v", err)
+	}
+	if ok {
+		major, minor, err := conn.ShapeVersion(shape)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("x11: %v", err)
+		}
+		// Input shapes arrived in SHAPE 1.1.
+		w.shape = shape
+		w.hasShape = major > 1 || (major == 1 && minor >= 1)
+	}
+	if !w.hasShape {
+		logging.Errorf("X server lacks SHAPE 1.1; transparent pixels will not be click-through")
+	}
+	return nil
+}
+
+func (w *Window) destroy() {
+	if w.closing.Load() {
+		return
+	}
+	if w.maskPix != 0 {
+		w.conn.FreePixmap(w.maskPix)
+	}
+	if w.maskGC != 0 {
+		w.conn.FreeGC(w.maskGC)
+	}
+	w.conn.FreeGC(w.gc)
+	w.conn.DestroyWindow(w.win)
+	w.conn.FreeColormap(w.cmap)
+	w.conn.Close()
+}
+
+func (w *Window) eventLoop() error {
+	for {
+		ev, err := w.conn.NextEvent()
+		if err != nil {
+			return err
+		}
+
+		switch ev := ev.(type) {
+		case x11.ButtonEvent:
+			w.handleButton(ev)
+		case x11.MotionEvent:
+			if w.dragging {
+				w.x = ev.RootX - w.dragX
+				w.y = ev.RootY - w.dragY
+				w.conn.MoveWindow(w.win, w.x, w.y)
+			}
+		case x11.ExposeEvent:
+			if ev.Count == 0 {
+				w.mu.RLock()
+				w.conn.PutImage(w.win, w.gc, w.width, w.height, w.pixels)
+				w.mu.RUnlock()
+			}
+		case x11.ConfigureEvent:
+			w.x, w.y = ev.X, ev.Y
+		case *x11.Error:
+			logging.Errorf("X11: %v", ev)
+		}
+	}
+}
+
+func (w *Window) handleButton(ev x11.ButtonEvent) {
+	if ev.Button != 1 {
+		return
+	}
+	if !ev.Pressed {
+		w.dragging = false
+		return
+	}
+
+	w.mu.RLock()
+	hit := hitTest(w.cfg, w.pixels, w.stride, w.width, w.height, ev.X, ev.Y)
+	w.mu.RUnlock()
+
+	if hit != HitTransparent {
+		w.dragging = true
+		w.dragX = ev.RootX - w.x
+		w.dragY = ev.RootY - w.y
+	}
+}
+
+func (w *Window) wsRenderLoop() {
+	r := newRenderer(w.wsServer, w.idle)
+	renderLoop(w.wsServer.GetRingBuffer(), w.cfg.MaxFPS, w.quitCh, func() bool {
+		r.present(w)
+		return r.animating
+	})
+}
+
+func (w *Window) show(visible bool) {
+	if w.visible.Swap(visible) == visible {
+		return
+	}
+	var err error
+	if visible {
+		err = w.conn.MapWindow(w.win)
+	} else {
+		err = w.conn.UnmapWindow(w.win)
+	}
+	if err != nil {
+		logging.Errorf("X11: showing window: %v", err)
+	}
+}
+
+func (w *Window) size() (int, int) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.width, w.height
+}
+
+func (w *Window) applyFrameDirect(frame []byte, width, height int) error {
+	expectedSize := width * height * 4
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(frame) < expectedSize || len(w.pixels) < expectedSize {
+		return nil
+	}
+	copy(w.pixels, frame[:expectedSize])
+
+	if err := w.conn.PutImage(w.win, w.gc, width, height, w.pixels); err != nil {
+		return err
+	}
+	return w.updateInputShape()
+}
+
+// updateInputShape makes transparent pixels click-through by setting the
+// window's input region to the pixels hitTest considers clickable.
+// Callers must hold w.mu.
+func (w *Window) updateInputShape() error {
+	if !w.hasShape {
+		return nil
+	}
+
+	stride := w.conn.BitmapStride(w.width)
+	bits := make([]byte, stride*w.height)
+	lsb := w.conn.BitmapBitOrder == x11.LSBFirst
+	for y := 0; y < w.height; y++ {
+		row := bits[y*stride:]
+		for x := 0; x < w.width; x++ {
+			if !clickable(w.cfg, w.pixels, w.stride, x, y) {
+				continue
+			}
+			if lsb {
+				row[x/8] |= 1 << (x % 8)
+			} else {
+				row[x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	if bytes.Equal(bits, w.mask) {
+		return nil
+	}
+	w.mask = bits
+
+	if w.maskPix == 0 {
+		pix, err := w.conn.NewID()
+		if err != nil {
+			return err
+		}
+		if err := w.conn.CreatePixmap(1, pix, w.win, w.width, w.height); err != nil {
+			return err
+		}
+		w.maskPix = pix
+		if w.maskGC == 0 {
+			gc, err := w.conn.NewID()
+			if err != nil {
+				return err
+			}
+			if err := w.conn.CreateGC(gc, pix, 1, 0); err != nil {
+				return err
+			}
+			w.maskGC = gc
+		}
+	}
+
+	if err := w.conn.PutBitmap(w.maskPix, w.maskGC, w.width, w.height, bits); err != nil {
+		return err
+	}
+	return w.conn.ShapeMask(w.shape, x11.ShapeKindInput, w.win, w.maskPix)
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package window
+
+import (
+	"image/color"
+	"os"
+	"testing"
+
+	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/websocket"
+)
+
+// TestX11Window runs against a real X server, e.g.
+//
+//	Xvfb :99 -screen 0 1280x720x24 & DISPLAY=:99 go test ./internal/window
+func TestX11Window(t *testing.T) {
+	if os.Getenv("DISPLAY") == "" {
+		t.Skip("DISPLAY not set")
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.InitialSize = 64
+	w, err := NewWindow(cfg, websocket.NewServer(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.destroy()
+
+	if err := w.conn.MapWindow(w.win); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := websocket.CreateBlankFrame(100, 50, color.NRGBA{G: 200, A: 255})
+	for x := 0; x < 50; x++ {
+		for y := 0; y < 50; y++ {
+			frame[(y*100+x)*4+3] = 0
+		}
+	}
+	w.wsServer.GetRingBuffer().Write(frame, 100, 50)
+	if !newRenderer(w.wsServer, defaultIdle).present(w) {
+		t.Fatal("no frame presented")
+	}
+
+	// A round trip flushes any asynchronous error the requests above caused.
+	if _, _, err := w.conn.QueryExtension("SHAPE"); err != nil {
+		t.Fatalf("X server reported: %v", err)
+	}
+
+	// The window keeps its size; the frame is fitted into rows 16-47.
+	if width, height := w.size(); width != 64 || height != 64 {
+		t.Errorf("size = %dx%d, want 64x64", width, height)
+	}
+	if got := hitTest(w.cfg, w.pixels, w.stride, w.width, w.height, 10, 32); got != HitTransparent {
+		t.Errorf("hit in transparent half = %v, want transparent", got)
+	}
+	if got := hitTest(w.cfg, w.pixels, w.stride, w.width, w.height, 48, 32); got != HitCaption {
+		t.Errorf("hit in opaque half = %v, want caption", got)
+	}
+	if got := hitTest(w.cfg, w.pixels, w.stride, w.width, w.height, 48, 5); got != HitTransparent {
+		t.Errorf("hit in letterbox = %v, want transparent", got)
+	}
+	if w.hasShape && w.mask == nil {
+		t.Error("input shape was not set")
+	}
+}
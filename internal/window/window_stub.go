@@ -1,4 +1,4 @@
-//go:build !windows
+//go:build !windows && !linux
 
 package window
 
@@ -6,12 +6,13 @@ import (
 	"errors"
 
 	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/websocket"
 )
 
 type Window struct{}
 
-func NewWindow(cfg config.Config) (*Window, error) {
-	return nil, errors.New("window: not implemented on this platform")
+func NewWindow(cfg config.Config, server *websocket.Server) (*Window, error) {
+	return nil, errors.New("window: not implemented on this platform (use -headless)")
 }
 
 func (w *Window) Run() error {
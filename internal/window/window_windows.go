@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
-	"time"
 	"unsafe"
 
 	"github.com/example/bidirect/internal/config"
+	"github.com/example/bidirect/internal/scale"
 	"github.com/example/bidirect/internal/websocket"
 	"golang.org/x/sys/windows"
 )
@@ -21,11 +22,13 @@ var (
 	procCreateWindowExW     = user32.NewProc("CreateWindowExW")
 	procDefWindowProcW      = user32.NewProc("DefWindowProcW")
 	procShowWindow          = user32.NewProc("ShowWindow")
+	procShowWindowAsync     = user32.NewProc("ShowWindowAsync")
 	procUpdateWindow        = user32.NewProc("UpdateWindow")
 	procGetMessageW         = user32.NewProc("GetMessageW")
 	procTranslateMessage    = user32.NewProc("TranslateMessage")
 	procDispatchMessageW    = user32.NewProc("DispatchMessageW")
 	procPostQuitMessage     = user32.NewProc("PostQuitMessage")
+	procPostMessageW        = user32.NewProc("PostMessageW")
 	procLoadCursorW         = user32.NewProc("LoadCursorW")
 	procSetWindowPos        = user32.NewProc("SetWindowPos")
 	procGetCursorPos        = user32.NewProc("GetCursorPos")
@@ -57,6 +60,9 @@ const (
 	WM_SIZING        = 0x0214
 	WM_COMMAND       = 0x0111
 	WM_RBUTTONUP     = 0x0205
+	WM_APP           = 0x8000
+	// WM_PRESENT asks the UI thread to put the staged frame on screen.
+	WM_PRESENT = WM_APP + 1
 
 	HTTRANSPARENT = ^uintptr(0)
 	HTCLIENT      = 1
@@ -70,7 +76,9 @@ const (
 	HTBOTTOMLEFT  = 16
 	HTBOTTOMRIGHT = 17
 
-	SW_SHOW = 5
+	SW_HIDE           = 0
+	SW_SHOWNOACTIVATE = 4
+	SW_SHOW           = 5
 
 	IDC_ARROW = 32512
 
@@ -89,6 +97,9 @@ const (
 	IDM_QUIT       = 1001
 	IDM_ALWAYS_TOP = 1003
 	IDM_ABOUT      = 1004
+	IDM_HUD        = 1005
+	IDM_FIT        = 1010 // + scale.Mode
+	IDM_FILTER     = 1020 // + scale.Filter
 
 	MB_OK       = 0x00000000
 	MB_ICONINFO = 0x00000040
@@ -169,22 +180,36 @@ type Window struct {
 	stride    int
 	mu        sync.RWMutex
 	isTopmost bool
-	quitCh    chan struct{}
-	wsServer  *websocket.Server
+	idle      idleConfig
+	visible   atomic.Bool
+	// staged is the frame the render loop last handed to the UI thread,
+	// and posted whether a WM_PRESENT for it is still queued.
+	staged       []byte
+	stagedWidth  int
+	stagedHeight int
+	posted       atomic.Bool
+	quitCh       chan struct{}
+	wsServer     *websocket.Server
 }
 
 var windowInstance *Window
 var windowInstanceMu sync.Mutex
 
-func NewWindow(cfg config.Config) (*Window, error) {
+func NewWindow(cfg config.Config, server *websocket.Server) (*Window, error) {
 	width := cfg.InitialSize
 	height := cfg.InitialSize
+	idle, err := newIdleConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	w := &Window{
-		cfg:    cfg,
-		width:  width,
-		height: height,
-		quitCh: make(chan struct{}),
+		idle:     idle,
+		cfg:      cfg,
+		width:    width,
+		height:   height,
+		quitCh:   make(chan struct{}),
+		wsServer: server,
 	}
 	return w, nil
 }
@@ -227,7 +252,12 @@ func (w *Window) Run() error {
 	y := (int(screenH) - w.height) / 2
 
 	exStyle := uint32(WS_EX_LAYERED | WS_EX_APPWINDOW)
-	style := uint32(WS_POPUP | WS_VISIBLE)
+	style := uint32(WS_POPUP)
+	// A window hidden while idle is shown by the render loop once frames
+	// arrive.
+	if !w.idle.hide {
+		style |= WS_VISIBLE
+	}
 
 	hwnd, _, err := procCreateWindowExW.Call(
 		uintptr(exStyle),
@@ -256,14 +286,12 @@ func (w *Window) Run() error {
 	logo := websocket.CreateBiDirectLogo(w.width)
 	w.applyFrameDirect(logo, w.width, w.height)
 
-	procShowWindow.Call(hwnd, SW_SHOW)
+	if !w.idle.hide {
+		procShowWindow.Call(hwnd, SW_SHOW)
+		w.visible.Store(true)
+	}
 	procUpdateWindow.Call(hwnd)
 
-	// Start WebSocket server
-	w.wsServer = websocket.NewServer(w.cfg.WSPort)
-	if err := w.wsServer.Start(); err != nil {
-		return fmt.Errorf("WebSocket server failed: %v", err)
-	}
 	go w.wsRenderLoop()
 
 	var msg MSG
@@ -279,10 +307,12 @@ func (w *Window) Run() error {
 		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
 	}
 
+	// The render loop may still be staging frames, but nothing presents
+	// them once the message loop has stopped.
+	w.mu.Lock()
 	destroyDIBSection(w.hdcMem, w.hBitmap)
-	if w.wsServer != nil {
-		w.wsServer.Stop()
-	}
+	w.dibPixels = nil
+	w.mu.Unlock()
 	close(w.quitCh)
 
 	return nil
@@ -362,6 +392,11 @@ func wndProcCallback(hwnd uintptr, msg uint32, wParam, lParam uintptr) uintptr {
 			w.handleCommand(int(wParam & 0xFFFF))
 			return 0
 		}
+	case WM_PRESENT:
+		if w != nil {
+			w.presentStaged()
+			return 0
+		}
 	case WM_DESTROY:
 		procPostQuitMessage.Call(0)
 		return 0
@@ -380,55 +415,24 @@ func (w *Window) handleNCHitTest(lParam uintptr) uintptr {
 
 	cx, cy := int(pt.X), int(pt.Y)
 
-	if cx < 0 || cy < 0 || cx >= w.width || cy >= w.height {
-		return HTTRANSPARENT
-	}
-
 	w.mu.RLock()
-	idx := (cy*w.stride + cx*4) + 3
-	alpha := uint8(0)
-	if idx < len(w.dibPixels) {
-		alpha = w.dibPixels[idx]
-	}
+	hit := hitTest(w.cfg, w.dibPixels, w.stride, w.width, w.height, cx, cy)
 	w.mu.RUnlock()
 
-	if alpha < w.cfg.AlphaThreshold {
-		return HTTRANSPARENT
-	}
-
-	grab := w.cfg.BorderGrabSize
-
-	onLeft := cx < grab
-	onRight := cx >= w.width-grab
-	onTop := cy < grab
-	onBottom := cy >= w.height-grab
-
-	if onTop && onLeft {
-		return HTTOPLEFT
-	}
-	if onTop && onRight {
-		return HTTOPRIGHT
-	}
-	if onBottom && onLeft {
-		return HTBOTTOMLEFT
-	}
-	if onBottom && onRight {
-		return HTBOTTOMRIGHT
-	}
-	if onLeft {
-		return HTLEFT
-	}
-	if onRight {
-		return HTRIGHT
-	}
-	if onTop {
-		return HTTOP
-	}
-	if onBottom {
-		return HTBOTTOM
-	}
+	return hitCodes[hit]
+}
 
-	return HTCAPTION
+var hitCodes = [...]uintptr{
+	HitTransparent: HTTRANSPARENT,
+	HitCaption:     HTCAPTION,
+	HitLeft:        HTLEFT,
+	HitRight:       HTRIGHT,
+	HitTop:         HTTOP,
+	HitTopLeft:     HTTOPLEFT,
+	HitTopRight:    HTTOPRIGHT,
+	HitBottom:      HTBOTTOM,
+	HitBottomLeft:  HTBOTTOMLEFT,
+	HitBottomRight: HTBOTTOMRIGHT,
 }
 
 func (w *Window) handleGetMinMaxInfo(lParam uintptr) {
@@ -487,7 +491,11 @@ func (w *Window) handleSizing(wParam, lParam uintptr) {
 	}
 }
 
+// resize replaces the DIB section with one of the new size. Like
+// presentStaged it runs on the UI thread; the swap happens under w.mu for
+// the render loop, which reads the window size.
 func (w *Window) resize(newWidth, newHeight int) {
+	w.mu.Lock()
 	destroyDIBSection(w.hdcMem, w.hBitmap)
 
 	w.width = newWidth
@@ -495,9 +503,14 @@ func (w *Window) resize(newWidth, newHeight int) {
 
 	var err error
 	w.hdcMem, w.hBitmap, w.dibPixels, w.stride, err = createDIBSection(w.width, w.height)
+	if err != nil {
+		w.dibPixels = nil
+	}
+	w.mu.Unlock()
 	if err != nil {
 		return
 	}
+	w.wsServer.GetRingBuffer().Notify()
 }
 
 func (w *Window) showContextMenu() {
@@ -513,10 +526,24 @@ func (w *Window) showContextMenu() {
 		topText = "Always On Top"
 	}
 	alwaysTop, _ := syscall.UTF16PtrFromString(topText)
+	hudText, _ := syscall.UTF16PtrFromString(checkedLabel(w.wsServer.HUD(), "Performance HUD"))
 	about, _ := syscall.UTF16PtrFromString("About")
 	quit, _ := syscall.UTF16PtrFromString("Quit")
 
 	procAppendMenuW.Call(hMenu, MF_STRING, IDM_ALWAYS_TOP, uintptr(unsafe.Pointer(alwaysTop)))
+	procAppendMenuW.Call(hMenu, MF_STRING, IDM_HUD, uintptr(unsafe.Pointer(hudText)))
+	procAppendMenuW.Call(hMenu, MF_SEPARATOR, 0, 0)
+	mode, filter := w.wsServer.Scaling()
+	for _, m := range []scale.Mode{scale.Fit, scale.Fill, scale.Stretch, scale.Center} {
+		label, _ := syscall.UTF16PtrFromString(checkedLabel(m == mode, scalingLabels[m]))
+		procAppendMenuW.Call(hMenu, MF_STRING, uintptr(IDM_FIT+int(m)), uintptr(unsafe.Pointer(label)))
+	}
+	procAppendMenuW.Call(hMenu, MF_SEPARATOR, 0, 0)
+	for _, f := range []scale.Filter{scale.Bilinear, scale.Lanczos} {
+		label, _ := syscall.UTF16PtrFromString(checkedLabel(f == filter, filterLabels[f]))
+		procAppendMenuW.Call(hMenu, MF_STRING, uintptr(IDM_FILTER+int(f)), uintptr(unsafe.Pointer(label)))
+	}
+	procAppendMenuW.Call(hMenu, MF_SEPARATOR, 0, 0)
 	procAppendMenuW.Call(hMenu, MF_STRING, IDM_ABOUT, uintptr(unsafe.Pointer(about)))
 	procAppendMenuW.Call(hMenu, MF_SEPARATOR, 0, 0)
 	procAppendMenuW.Call(hMenu, MF_STRING, IDM_QUIT, uintptr(unsafe.Pointer(quit)))
@@ -540,12 +567,43 @@ func (w *Window) showContextMenu() {
 	}
 }
 
+var scalingLabels = [...]string{
+	scale.Fit:     "Fit",
+	scale.Fill:    "Fill",
+	scale.Stretch: "Stretch",
+	scale.Center:  "Center",
+}
+
+var filterLabels = [...]string{
+	scale.Bilinear: "Bilinear",
+	scale.Lanczos:  "Lanczos",
+}
+
+func checkedLabel(checked bool, text string) string {
+	if checked {
+		return "✓ " + text
+	}
+	return text
+}
+
 func (w *Window) handleCommand(id int) {
+	mode, filter := w.wsServer.Scaling()
+	switch {
+	case id >= IDM_FIT && id < IDM_FIT+len(scalingLabels):
+		w.wsServer.SetScaling(scale.Mode(id-IDM_FIT), filter)
+		return
+	case id >= IDM_FILTER && id < IDM_FILTER+len(filterLabels):
+		w.wsServer.SetScaling(mode, scale.Filter(id-IDM_FILTER))
+		return
+	}
+
 	switch id {
 	case IDM_QUIT:
 		procPostQuitMessage.Call(0)
 	case IDM_ALWAYS_TOP:
 		w.toggleAlwaysOnTop()
+	case IDM_HUD:
+		w.wsServer.SetHUD(!w.wsServer.HUD())
 	case IDM_ABOUT:
 		w.showAbout()
 	}
@@ -576,60 +634,75 @@ func (w *Window) toggleAlwaysOnTop() {
 }
 
 func (w *Window) wsRenderLoop() {
-	ticker := time.NewTicker(16 * time.Millisecond) // ~60 FPS
-	defer ticker.Stop()
-
-	ringBuffer := w.wsServer.GetRingBuffer()
-
-	for {
-		select {
-		case <-w.quitCh:
-			return
-		case <-ticker.C:
-			frame, ok := ringBuffer.ReadLatest()
-			if !ok {
-				continue
-			}
-
-			if frame.Width != w.width || frame.Height != w.height {
-				w.resizeWindow(frame.Width, frame.Height)
-			}
-
-			w.applyFrameDirect(frame.Data, frame.Width, frame.Height)
-		}
-	}
+	r := newRenderer(w.wsServer, w.idle)
+	renderLoop(w.wsServer.GetRingBuffer(), w.cfg.MaxFPS, w.quitCh, func() bool {
+		r.present(w)
+		return r.animating
+	})
 }
 
-func (w *Window) resizeWindow(newWidth, newHeight int) {
-	destroyDIBSection(w.hdcMem, w.hBitmap)
-
-	w.width = newWidth
-	w.height = newHeight
-
-	var err error
-	w.hdcMem, w.hBitmap, w.dibPixels, w.stride, err = createDIBSection(w.width, w.height)
-	if err != nil {
+// show is called from the render loop, so it must not wait on the window's
+// own thread.
+func (w *Window) show(visible bool) {
+	if w.visible.Swap(visible) == visible {
 		return
 	}
+	cmd := uintptr(SW_HIDE)
+	if visible {
+		cmd = SW_SHOWNOACTIVATE
+	}
+	procShowWindowAsync.Call(uintptr(w.hwnd), cmd)
+}
 
-	procSetWindowPos.Call(
-		uintptr(w.hwnd),
-		0,
-		0, 0,
-		uintptr(w.width), uintptr(w.height),
-		SWP_NOMOVE,
-	)
+func (w *Window) size() (int, int) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.width, w.height
 }
 
+// applyFrameDirect hands the frame to the UI thread. UpdateLayeredWindow
+// may send messages to that thread, whose handlers take w.mu, so it is
+// only called from there, where resize cannot run at the same time.
 func (w *Window) applyFrameDirect(frame []byte, width, height int) error {
 	expectedSize := width * height * 4
-	if len(frame) < expectedSize || len(w.dibPixels) < expectedSize {
+	if len(frame) < expectedSize {
 		return nil
 	}
 
 	w.mu.Lock()
-	copy(w.dibPixels, frame[:expectedSize])
+	if cap(w.staged) < expectedSize {
+		w.staged = make([]byte, expectedSize)
+	}
+	w.staged = w.staged[:expectedSize]
+	copy(w.staged, frame)
+	w.stagedWidth, w.stagedHeight = width, height
+	w.mu.Unlock()
+
+	if !w.posted.Swap(true) {
+		procPostMessageW.Call(uintptr(w.hwnd), WM_PRESENT, 0, 0)
+	}
+	return nil
+}
+
+// presentStaged puts the staged frame on screen. It runs on the UI thread.
+// Frames sized for the window before a resize are skipped; the resize has
+// the frame presented again at the new size.
+func (w *Window) presentStaged() {
+	// Frames staged from here on post a message of their own.
+	w.posted.Store(false)
+
+	w.mu.Lock()
+	width, height := w.width, w.height
+	expectedSize := width * height * 4
+	ok := w.stagedWidth == width && w.stagedHeight == height &&
+		len(w.staged) >= expectedSize && len(w.dibPixels) >= expectedSize
+	if ok {
+		copy(w.dibPixels, w.staged[:expectedSize])
+	}
 	w.mu.Unlock()
+	if !ok {
+		return
+	}
 
 	srcPt := POINT{0, 0}
 	sz := SIZE{int32(width), int32(height)}
@@ -651,6 +724,4 @@ func (w *Window) applyFrameDirect(frame []byte, width, height int) error {
 		uintptr(unsafe.Pointer(&blend)),
 		ULW_ALPHA,
 	)
-
-	return nil
 }
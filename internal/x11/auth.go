@@ -0,0 +1,143 @@
+package x11
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+const (
+	familyInternet  = 0
+	familyInternet6 = 6
+	familyLocal     = 256
+	familyWild      = 65535
+
+	cookieAuth = "MIT-MAGIC-COOKIE-1"
+)
+
+type authEntry struct {
+	family  uint16
+	address string
+	number  string
+	name    string
+	data    []byte
+}
+
+// readAuth looks up the MIT-MAGIC-COOKIE-1 entry for a display in the
+// Xauthority file. A missing file or entry is not an error: many servers
+// (Xvfb in particular) run without access control.
+func readAuth(path, host, number string) (name string, data []byte) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+
+	local := host == "" || host == "unix" || host == "localhost"
+	hostname, _ := os.Hostname()
+	// Internet entries hold binary addresses, so a remote host is only
+	// resolved once such an entry comes up.
+	var ips []net.IP
+	resolved := false
+
+	r := bufio.NewReader(f)
+	for {
+		e, err := readAuthEntry(r)
+		if err != nil {
+			return "", nil
+		}
+		if e.name != cookieAuth {
+			continue
+		}
+		if e.number != "" && e.number != number {
+			continue
+		}
+		switch e.family {
+		case familyWild:
+		case familyLocal:
+			if !local || e.address != hostname {
+				continue
+			}
+		case familyInternet, familyInternet6:
+			if local {
+				continue
+			}
+			if !resolved {
+				ips, resolved = hostIPs(host), true
+			}
+			if !matchIP(e.family, e.address, ips) {
+				continue
+			}
+		default:
+			continue
+		}
+		return e.name, e.data
+	}
+}
+
+// hostIPs returns the addresses of host, which may be an IP literal.
+func hostIPs(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	ips, _ := net.LookupIP(host)
+	return ips
+}
+
+// matchIP reports whether the binary address of an Internet or Internet6
+// entry is one of ips.
+func matchIP(family uint16, address string, ips []net.IP) bool {
+	size := net.IPv4len
+	if family == familyInternet6 {
+		size = net.IPv6len
+	}
+	if len(address) != size {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.Equal(net.IP(address)) {
+			return true
+		}
+	}
+	return false
+}
+
+func readAuthEntry(r io.Reader) (authEntry, error) {
+	var e authEntry
+	var family [2]byte
+	if _, err := io.ReadFull(r, family[:]); err != nil {
+		return e, err
+	}
+	e.family = binary.BigEndian.Uint16(family[:])
+
+	fields := make([][]byte, 4)
+	for i := range fields {
+		var n [2]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return e, err
+		}
+		fields[i] = make([]byte, binary.BigEndian.Uint16(n[:]))
+		if _, err := io.ReadFull(r, fields[i]); err != nil {
+			return e, err
+		}
+	}
+	e.address = string(fields[0])
+	e.number = string(fields[1])
+	e.name = string(fields[2])
+	e.data = fields[3]
+	return e, nil
+}
+
+func authPath() string {
+	if p := os.Getenv("XAUTHORITY"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".Xauthority")
+}
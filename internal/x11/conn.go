@@ -0,0 +1,316 @@
+// Package x11 is a minimal pure-Go X11 protocol client: just enough of the
+// core protocol and the SHAPE extension to drive a borderless ARGB overlay
+// window.
+package x11
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Visual classes.
+const (
+	TrueColor = 4
+)
+
+// Byte orders reported by the server.
+const (
+	LSBFirst = 0
+	MSBFirst = 1
+)
+
+type Visual struct {
+	ID        uint32
+	Class     uint8
+	Depth     uint8
+	RedMask   uint32
+	GreenMask uint32
+	BlueMask  uint32
+}
+
+type Screen struct {
+	Root          uint32
+	DefaultCmap   uint32
+	WhitePixel    uint32
+	BlackPixel    uint32
+	Width         int
+	Height        int
+	RootVisual    uint32
+	RootDepth     uint8
+	Visuals       []Visual
+	AllowedDepths []uint8
+}
+
+// Conn is a connection to an X server. Requests may be sent from any
+// goroutine; events and replies must be read from a single one.
+type Conn struct {
+	conn net.Conn
+	wmu  sync.Mutex
+
+	idBase uint32
+	idMask uint32
+	idNext uint32
+	idMu   sync.Mutex
+	maxReq int
+
+	ImageByteOrder  uint8
+	BitmapBitOrder  uint8
+	BitmapScanPad   uint8
+	BitmapScanUnit  uint8
+	Screens         []Screen
+	DefaultScreenNo int
+}
+
+// Dial connects to the X server named by display, or by $DISPLAY when
+// display is empty.
+func Dial(display string) (*Conn, error) {
+	if display == "" {
+		display = os.Getenv("DISPLAY")
+	}
+	if display == "" {
+		return nil, errors.New("x11: DISPLAY is not set")
+	}
+
+	host, number, screen, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+
+	var nc net.Conn
+	if host == "" || host == "unix" {
+		nc, err = net.Dial("unix", "/tmp/.X11-unix/X"+number)
+	} else {
+		n, _ := strconv.Atoi(number)
+		nc, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("x11: connecting to %s: %w", display, err)
+	}
+
+	c := &Conn{conn: nc, DefaultScreenNo: screen}
+	authName, authData := readAuth(authPath(), host, number)
+	if err := c.handshake(authName, authData); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if c.DefaultScreenNo >= len(c.Screens) {
+		c.DefaultScreenNo = 0
+	}
+	return c, nil
+}
+
+// parseDisplay splits "[host]:display[.screen]".
+func parseDisplay(display string) (host, number string, screen int, err error) {
+	colon := strings.LastIndex(display, ":")
+	if colon < 0 {
+		return "", "", 0, fmt.Errorf("x11: invalid display %q", display)
+	}
+	host = display[:colon]
+	number = display[colon+1:]
+	if dot := strings.Index(number, "."); dot >= 0 {
+		screen, err = strconv.Atoi(number[dot+1:])
+		if err != nil {
+			return "", "", 0, fmt.Errorf("x11: invalid display %q", display)
+		}
+		number = number[:dot]
+	}
+	if _, err := strconv.Atoi(number); err != nil {
+		return "", "", 0, fmt.Errorf("x11: invalid display %q", display)
+	}
+	return host, number, screen, nil
+}
+
+func (c *Conn) handshake(authName string, authData []byte) error {
+	req := make([]byte, 12, 12+len(authName)+len(authData)+6)
+	req[0] = 'l'
+	binary.LittleEndian.PutUint16(req[2:], 11)
+	binary.LittleEndian.PutUint16(req[4:], 0)
+	binary.LittleEndian.PutUint16(req[6:], uint16(len(authName)))
+	binary.LittleEndian.PutUint16(req[8:], uint16(len(authData)))
+	req = append(req, authName...)
+	req = append(req, make([]byte, pad(len(authName)))...)
+	req = append(req, authData...)
+	req = append(req, make([]byte, pad(len(authData)))...)
+	if _, err := c.conn.Write(req); err != nil {
+		return fmt.Errorf("x11: sending setup: %w", err)
+	}
+
+	var head [8]byte
+	if _, err := io.ReadFull(c.conn, head[:]); err != nil {
+		return fmt.Errorf("x11: reading setup: %w", err)
+	}
+	body := make([]byte, int(binary.LittleEndian.Uint16(head[6:]))*4)
+	if _, err := io.ReadFull(c.conn, body); err != nil {
+		return fmt.Errorf("x11: reading setup: %w", err)
+	}
+
+	switch head[0] {
+	case 0:
+		reason := body
+		if n := int(head[1]); n <= len(reason) {
+			reason = reason[:n]
+		}
+		return fmt.Errorf("x11: connection refused: %s", strings.TrimSpace(string(reason)))
+	case 2:
+		return fmt.Errorf("x11: server requires further authentication: %s", strings.TrimSpace(string(body)))
+	}
+
+	return c.parseSetup(body)
+}
+
+func (c *Conn) parseSetup(b []byte) error {
+	if len(b) < 32 {
+		return errors.New("x11: short setup reply")
+	}
+	le := binary.LittleEndian
+	c.idBase = le.Uint32(b[4:])
+	c.idMask = le.Uint32(b[8:])
+	vendorLen := int(le.Uint16(b[16:]))
+	c.maxReq = int(le.Uint16(b[18:])) * 4
+	numScreens := int(b[20])
+	numFormats := int(b[21])
+	c.ImageByteOrder = b[22]
+	c.BitmapBitOrder = b[23]
+	c.BitmapScanUnit = b[24]
+	c.BitmapScanPad = b[25]
+
+	off := 32 + vendorLen + pad(vendorLen) + numFormats*8
+
+	for i := 0; i < numScreens; i++ {
+		if off+40 > len(b) {
+			return errors.New("x11: short setup reply")
+		}
+		s := Screen{
+			Root:        le.Uint32(b[off:]),
+			DefaultCmap: le.Uint32(b[off+4:]),
+			WhitePixel:  le.Uint32(b[off+8:]),
+			BlackPixel:  le.Uint32(b[off+12:]),
+			Width:       int(le.Uint16(b[off+20:])),
+			Height:      int(le.Uint16(b[off+22:])),
+			RootVisual:  le.Uint32(b[off+32:]),
+			RootDepth:   b[off+38],
+		}
+		numDepths := int(b[off+39])
+		off += 40
+		for d := 0; d < numDepths; d++ {
+			if off+8 > len(b) {
+				return errors.New("x11: short setup reply")
+			}
+			depth := b[off]
+			numVisuals := int(le.Uint16(b[off+2:]))
+			s.AllowedDepths = append(s.AllowedDepths, depth)
+			off += 8
+			for v := 0; v < numVisuals; v++ {
+				if off+24 > len(b) {
+					return errors.New("x11: short setup reply")
+				}
+				s.Visuals = append(s.Visuals, Visual{
+					ID:        le.Uint32(b[off:]),
+					Class:     b[off+4],
+					Depth:     depth,
+					RedMask:   le.Uint32(b[off+8:]),
+					GreenMask: le.Uint32(b[off+12:]),
+					BlueMask:  le.Uint32(b[off+16:]),
+				})
+				off += 24
+			}
+		}
+		c.Screens = append(c.Screens, s)
+	}
+
+	if len(c.Screens) == 0 {
+		return errors.New("x11: server reported no screens")
+	}
+	return nil
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) DefaultScreen() *Screen {
+	return &c.Screens[c.DefaultScreenNo]
+}
+
+// FindARGBVisual returns the 32-bit TrueColor visual with an alpha
+// channel, as exposed by servers that support compositing.
+func (s *Screen) FindARGBVisual() (Visual, bool) {
+	for _, v := range s.Visuals {
+		if v.Depth == 32 && v.Class == TrueColor &&
+			v.RedMask == 0xff0000 && v.GreenMask == 0xff00 && v.BlueMask == 0xff {
+			return v, true
+		}
+	}
+	return Visual{}, false
+}
+
+// NewID allocates a resource ID for a window, pixmap, GC or colormap.
+func (c *Conn) NewID() (uint32, error) {
+	c.idMu.Lock()
+	defer c.idMu.Unlock()
+
+	inc := c.idMask & -c.idMask
+	next := c.idNext + inc
+	if next&^c.idMask != 0 || next == 0 {
+		return 0, errors.New("x11: out of resource IDs")
+	}
+	c.idNext = next
+	return c.idBase | next, nil
+}
+
+func (c *Conn) send(req []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(req)
+	return err
+}
+
+// roundTrip sends a request and waits for its reply. Events arriving in
+// the meantime are discarded, so it is only meant for setup, before the
+// caller starts reading events.
+func (c *Conn) roundTrip(req []byte) ([]byte, error) {
+	if err := c.send(req); err != nil {
+		return nil, err
+	}
+	for {
+		b, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		switch b[0] {
+		case 0:
+			return nil, parseError(b)
+		case 1:
+			return b, nil
+		}
+	}
+}
+
+// readPacket reads one reply, error or event, including any extra reply
+// data.
+func (c *Conn) readPacket() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(c.conn, b); err != nil {
+		return nil, err
+	}
+	if b[0] == 1 || b[0]&0x7f == genericEvent {
+		if extra := int(binary.LittleEndian.Uint32(b[4:])) * 4; extra > 0 {
+			b = append(b, make([]byte, extra)...)
+			if _, err := io.ReadFull(c.conn, b[32:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
@@ -0,0 +1,120 @@
+package x11
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Event codes.
+const (
+	keyPress        = 2
+	buttonPress     = 4
+	buttonRelease   = 5
+	motionNotify    = 6
+	expose          = 12
+	configureNotify = 22
+	genericEvent    = 35
+)
+
+type KeyEvent struct {
+	Keycode uint8
+	State   uint16
+}
+
+type ButtonEvent struct {
+	Button  uint8
+	Pressed bool
+	RootX   int
+	RootY   int
+	X       int
+	Y       int
+	State   uint16
+}
+
+type MotionEvent struct {
+	RootX int
+	RootY int
+	X     int
+	Y     int
+	State uint16
+}
+
+type ExposeEvent struct {
+	Count int
+}
+
+type ConfigureEvent struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+// Error is an error reported asynchronously by the server.
+type Error struct {
+	Code        uint8
+	Sequence    uint16
+	BadValue    uint32
+	MinorOpcode uint16
+	MajorOpcode uint8
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("x11: error %d (request %d.%d, value 0x%x)", e.Code, e.MajorOpcode, e.MinorOpcode, e.BadValue)
+}
+
+func parseError(b []byte) *Error {
+	return &Error{
+		Code:        b[1],
+		Sequence:    binary.LittleEndian.Uint16(b[2:]),
+		BadValue:    binary.LittleEndian.Uint32(b[4:]),
+		MinorOpcode: binary.LittleEndian.Uint16(b[8:]),
+		MajorOpcode: b[10],
+	}
+}
+
+// NextEvent blocks until the next event or error arrives. Events this
+// package does not decode are skipped. A server error is returned as an
+// event of type *Error; a non-nil error means the connection failed.
+func (c *Conn) NextEvent() (any, error) {
+	for {
+		b, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		le := binary.LittleEndian
+		switch b[0] & 0x7f {
+		case 0:
+			return parseError(b), nil
+		case keyPress:
+			return KeyEvent{Keycode: b[1], State: le.Uint16(b[28:])}, nil
+		case buttonPress, buttonRelease:
+			return ButtonEvent{
+				Button:  b[1],
+				Pressed: b[0]&0x7f == buttonPress,
+				RootX:   int(int16(le.Uint16(b[20:]))),
+				RootY:   int(int16(le.Uint16(b[22:]))),
+				X:       int(int16(le.Uint16(b[24:]))),
+				Y:       int(int16(le.Uint16(b[26:]))),
+				State:   le.Uint16(b[28:]),
+			}, nil
+		case motionNotify:
+			return MotionEvent{
+				RootX: int(int16(le.Uint16(b[20:]))),
+				RootY: int(int16(le.Uint16(b[22:]))),
+				X:     int(int16(le.Uint16(b[24:]))),
+				Y:     int(int16(le.Uint16(b[26:]))),
+				State: le.Uint16(b[28:]),
+			}, nil
+		case expose:
+			return ExposeEvent{Count: int(le.Uint16(b[16:]))}, nil
+		case configureNotify:
+			return ConfigureEvent{
+				X:      int(int16(le.Uint16(b[16:]))),
+				Y:      int(int16(le.Uint16(b[18:]))),
+				Width:  int(le.Uint16(b[20:])),
+				Height: int(le.Uint16(b[22:])),
+			}, nil
+		}
+	}
+}
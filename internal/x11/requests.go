@@ -0,0 +1,268 @@
+package x11
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Core protocol opcodes.
+const (
+	opCreateWindow     = 1
+	opDestroyWindow    = 4
+	opMapWindow        = 8
+	opUnmapWindow      = 10
+	opConfigureWindow  = 12
+	opChangeProperty   = 18
+	opCreatePixmap     = 53
+	opFreePixmap       = 54
+	opCreateGC         = 55
+	opFreeGC           = 60
+	opPutImage         = 72
+	opCreateColormap   = 78
+	opFreeColormap     = 79
+	opQueryExtension   = 98
+	shapeQueryVersion  = 0
+	shapeMaskRequest   = 2
+	formatXYBitmap     = 0
+	formatZPixmap      = 2
+	classInputOutput   = 1
+	propModeReplace    = 0
+	atomString         = 31
+	atomWMName         = 39
+	shapeOpSet         = 0
+	ShapeKindInput     = 2
+	ShapeKindBounding  = 0
+	configureX         = 0x01
+	configureY         = 0x02
+	configureWidth     = 0x04
+	configureHeight    = 0x08
+	gcForeground       = 0x04
+	gcBackground       = 0x08
+	gcGraphicsExposure = 0x10000
+)
+
+// Window attribute value-mask bits.
+const (
+	CWBackPixel        = 0x0002
+	CWBorderPixel      = 0x0008
+	CWOverrideRedirect = 0x0200
+	CWEventMask        = 0x0800
+	CWColormap         = 0x2000
+)
+
+// Event mask bits.
+const (
+	KeyPressMask        = 0x00000001
+	ButtonPressMask     = 0x00000004
+	ButtonReleaseMask   = 0x00000008
+	ButtonMotionMask    = 0x00002000
+	ExposureMask        = 0x00008000
+	StructureNotifyMask = 0x00020000
+)
+
+// request builds a little-endian protocol request.
+type request []byte
+
+func newRequest(opcode, data byte) request {
+	return request{opcode, data, 0, 0}
+}
+
+func (r request) u8(v uint8) request { return append(r, v) }
+
+func (r request) u16(v uint16) request {
+	return binary.LittleEndian.AppendUint16(r, v)
+}
+
+func (r request) u32(v uint32) request {
+	return binary.LittleEndian.AppendUint32(r, v)
+}
+
+func (r request) bytes(b []byte) request {
+	r = append(r, b...)
+	return append(r, make([]byte, pad(len(b)))...)
+}
+
+func (r request) done() []byte {
+	binary.LittleEndian.PutUint16(r[2:], uint16(len(r)/4))
+	return r
+}
+
+// CreateWindow creates an InputOutput child of parent. values must be
+// given in value-mask bit order.
+func (c *Conn) CreateWindow(depth uint8, wid, parent uint32, x, y, width, height int, visual, mask uint32, values ...uint32) error {
+	r := newRequest(opCreateWindow, depth).
+		u32(wid).u32(parent).
+		u16(uint16(int16(x))).u16(uint16(int16(y))).
+		u16(uint16(width)).u16(uint16(height)).
+		u16(0).u16(classInputOutput).
+		u32(visual).u32(mask)
+	for _, v := range values {
+		r = r.u32(v)
+	}
+	return c.send(r.done())
+}
+
+func (c *Conn) DestroyWindow(wid uint32) error {
+	return c.send(newRequest(opDestroyWindow, 0).u32(wid).done())
+}
+
+func (c *Conn) MapWindow(wid uint32) error {
+	return c.send(newRequest(opMapWindow, 0).u32(wid).done())
+}
+
+func (c *Conn) UnmapWindow(wid uint32) error {
+	return c.send(newRequest(opUnmapWindow, 0).u32(wid).done())
+}
+
+func (c *Conn) MoveWindow(wid uint32, x, y int) error {
+	return c.send(newRequest(opConfigureWindow, 0).
+		u32(wid).u16(configureX | configureY).u16(0).
+		u32(uint32(int32(x))).u32(uint32(int32(y))).done())
+}
+
+func (c *Conn) ResizeWindow(wid uint32, width, height int) error {
+	return c.send(newRequest(opConfigureWindow, 0).
+		u32(wid).u16(configureWidth | configureHeight).u16(0).
+		u32(uint32(width)).u32(uint32(height)).done())
+}
+
+func (c *Conn) SetTitle(wid uint32, title string) error {
+	return c.send(newRequest(opChangeProperty, propModeReplace).
+		u32(wid).u32(atomWMName).u32(atomString).
+		u8(8).u8(0).u16(0).u32(uint32(len(title))).
+		bytes([]byte(title)).done())
+}
+
+func (c *Conn) CreateColormap(cmap, window, visual uint32) error {
+	return c.send(newRequest(opCreateColormap, 0).u32(cmap).u32(window).u32(visual).done())
+}
+
+func (c *Conn) FreeColormap(cmap uint32) error {
+	return c.send(newRequest(opFreeColormap, 0).u32(cmap).done())
+}
+
+func (c *Conn) CreatePixmap(depth uint8, pid, drawable uint32, width, height int) error {
+	return c.send(newRequest(opCreatePixmap, depth).
+		u32(pid).u32(drawable).u16(uint16(width)).u16(uint16(height)).done())
+}
+
+func (c *Conn) FreePixmap(pid uint32) error {
+	return c.send(newRequest(opFreePixmap, 0).u32(pid).done())
+}
+
+// CreateGC creates a graphics context with graphics exposures disabled
+// and the given foreground and background pixels.
+func (c *Conn) CreateGC(gc, drawable, foreground, background uint32) error {
+	return c.send(newRequest(opCreateGC, 0).
+		u32(gc).u32(drawable).
+		u32(gcForeground | gcBackground | gcGraphicsExposure).
+		u32(foreground).u32(background).u32(0).done())
+}
+
+func (c *Conn) FreeGC(gc uint32) error {
+	return c.send(newRequest(opFreeGC, 0).u32(gc).done())
+}
+
+// PutImage uploads a 32-bit BGRA image (0xAARRGGBB pixels in
+// little-endian order) to a depth-32 drawable. Large images are split
+// into bands of rows that fit the server's maximum request size.
+func (c *Conn) PutImage(drawable, gc uint32, width, height int, pixels []byte) error {
+	stride := width * 4
+	if len(pixels) < stride*height {
+		return errors.New("x11: image data too short")
+	}
+	var swapped []byte
+	if c.ImageByteOrder == MSBFirst {
+		swapped = make([]byte, stride)
+	}
+	return c.putBands(drawable, gc, formatZPixmap, 32, width, height, stride, func(y int) []byte {
+		row := pixels[y*stride : (y+1)*stride]
+		if swapped == nil {
+			return row
+		}
+		for i := 0; i < len(row); i += 4 {
+			swapped[i+0], swapped[i+1], swapped[i+2], swapped[i+3] = row[i+3], row[i+2], row[i+1], row[i+0]
+		}
+		return swapped
+	})
+}
+
+// PutBitmap uploads a 1-bit image to a depth-1 drawable. bits holds rows
+// of BitmapStride(width) bytes in the server's bitmap bit order.
+func (c *Conn) PutBitmap(drawable, gc uint32, width, height int, bits []byte) error {
+	stride := c.BitmapStride(width)
+	if len(bits) < stride*height {
+		return errors.New("x11: bitmap data too short")
+	}
+	return c.putBands(drawable, gc, formatXYBitmap, 1, width, height, stride, func(y int) []byte {
+		return bits[y*stride : (y+1)*stride]
+	})
+}
+
+// BitmapStride is the padded size in bytes of one bitmap scanline.
+func (c *Conn) BitmapStride(width int) int {
+	padBits := int(c.BitmapScanPad)
+	return (width + padBits - 1) / padBits * padBits / 8
+}
+
+func (c *Conn) putBands(drawable, gc uint32, format, depth uint8, width, height, stride int, row func(y int) []byte) error {
+	const headerSize = 24
+	rowsPerRequest := (c.maxReq - headerSize) / stride
+	if rowsPerRequest < 1 {
+		return fmt.Errorf("x11: image row of %d bytes exceeds the maximum request size", stride)
+	}
+
+	for y := 0; y < height; y += rowsPerRequest {
+		rows := min(rowsPerRequest, height-y)
+		r := make(request, 0, headerSize+rows*stride)
+		r = append(r, newRequest(opPutImage, format)...)
+		r = r.u32(drawable).u32(gc).
+			u16(uint16(width)).u16(uint16(rows)).
+			u16(0).u16(uint16(y)).
+			u8(0).u8(depth).u16(0)
+		for i := 0; i < rows; i++ {
+			r = append(r, row(y+i)...)
+		}
+		if err := c.send(r.done()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extension describes a protocol extension present on the server.
+type Extension struct {
+	MajorOpcode uint8
+	FirstEvent  uint8
+	FirstError  uint8
+}
+
+func (c *Conn) QueryExtension(name string) (Extension, bool, error) {
+	reply, err := c.roundTrip(newRequest(opQueryExtension, 0).
+		u16(uint16(len(name))).u16(0).bytes([]byte(name)).done())
+	if err != nil {
+		return Extension{}, false, err
+	}
+	if reply[8] == 0 {
+		return Extension{}, false, nil
+	}
+	return Extension{MajorOpcode: reply[9], FirstEvent: reply[10], FirstError: reply[11]}, true, nil
+}
+
+// ShapeVersion returns the SHAPE extension version.
+func (c *Conn) ShapeVersion(shape Extension) (major, minor int, err error) {
+	reply, err := c.roundTrip(newRequest(shape.MajorOpcode, shapeQueryVersion).done())
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(binary.LittleEndian.Uint16(reply[8:])), int(binary.LittleEndian.Uint16(reply[10:])), nil
+}
+
+// ShapeMask sets a window's shape of the given kind to the set bits of a
+// depth-1 pixmap.
+func (c *Conn) ShapeMask(shape Extension, kind uint8, wid, pixmap uint32) error {
+	return c.send(newRequest(shape.MajorOpcode, shapeMaskRequest).
+		u8(shapeOpSet).u8(kind).u16(0).
+		u32(wid).u16(0).u16(0).u32(pixmap).done())
+}
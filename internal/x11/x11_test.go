@@ -0,0 +1,99 @@
+package x11
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDisplay(t *testing.T) {
+	tests := []struct {
+		display string
+		host    string
+		number  string
+		screen  int
+		wantErr bool
+	}{
+		{":0", "", "0", 0, false},
+		{":99.1", "", "99", 1, false},
+		{"unix:1", "unix", "1", 0, false},
+		{"example.com:10.0", "example.com", "10", 0, false},
+		{"nocolon", "", "", 0, true},
+		{":x", "", "", 0, true},
+	}
+	for _, tt := range tests {
+		host, number, screen, err := parseDisplay(tt.display)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDisplay(%q) error = %v, wantErr %v", tt.display, err, tt.wantErr)
+			continue
+		}
+		if host != tt.host || number != tt.number || screen != tt.screen {
+			t.Errorf("parseDisplay(%q) = %q, %q, %d; want %q, %q, %d",
+				tt.display, host, number, screen, tt.host, tt.number, tt.screen)
+		}
+	}
+}
+
+func appendAuthEntry(b []byte, family uint16, address, number, name string, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, family)
+	for _, f := range [][]byte{[]byte(address), []byte(number), []byte(name), data} {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+func TestReadAuth(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	var b []byte
+	b = appendAuthEntry(b, familyLocal, hostname, "1", cookieAuth, []byte("one"))
+	b = appendAuthEntry(b, familyLocal, hostname, "0", "XDM-AUTHORIZATION-1", []byte("xdm"))
+	b = appendAuthEntry(b, familyLocal, hostname, "0", cookieAuth, []byte("zero"))
+	b = appendAuthEntry(b, familyInternet, string(net.ParseIP("192.0.2.7").To4()), "0", cookieAuth, []byte("remote"))
+	b = appendAuthEntry(b, familyInternet6, string(net.ParseIP("2001:db8::1")), "0", cookieAuth, []byte("remote6"))
+	// Internet entries are never matched against the host name as text.
+	b = appendAuthEntry(b, familyInternet, "192.0.2.8", "0", cookieAuth, []byte("text"))
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host, number string
+		want         string
+	}{
+		{"", "0", "zero"},
+		{"", "1", "one"},
+		{"192.0.2.7", "0", "remote"},
+		{"2001:db8::1", "0", "remote6"},
+		{"192.0.2.8", "0", ""},
+		{"192.0.2.7", "1", ""},
+		{"", "2", ""},
+	}
+	for _, tt := range tests {
+		_, data := readAuth(path, tt.host, tt.number)
+		if string(data) != tt.want {
+			t.Errorf("readAuth(%q, %q) = %q, want %q", tt.host, tt.number, data, tt.want)
+		}
+	}
+}
+
+func TestNewIDStaysInMask(t *testing.T) {
+	c := &Conn{idBase: 0x04000000, idMask: 0x001fffff}
+	first, err := c.NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := c.NewID()
+	if first == second {
+		t.Errorf("NewID returned %#x twice", first)
+	}
+	for _, id := range []uint32{first, second} {
+		if id&^c.idMask != c.idBase {
+			t.Errorf("id %#x outside base %#x / mask %#x", id, c.idBase, c.idMask)
+		}
+	}
+}